@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func evalCommand(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Compute values of [VARIJABLE] and [FORMULE] of every makro placed on plate, using cabinet variables (EVAR) and plate dimensions.
+`)
+		fmt.Fprintf(w, "Usage of %s eval [options] <FILE|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var format *string = flags.String("format", "text", "output format printed to standard output: text or json")
+	var makroName *string = flags.String("makro", "", "optional. Compute only makro with this name")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var write func(io.Writer, []corpus.MakroEvaluation) error
+	switch *format {
+	case "text":
+		write = corpus.WriteEvaluationText
+	case "json":
+		write = corpus.WriteEvaluationJSON
+	default:
+		log.Fatalf("-format must be text or json: %s", *format)
+	}
+	files := []string{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		if statInput.IsDir() {
+			files = append(files, corpus.FindCorpusFiles(input)...)
+		} else {
+			files = append(files, input)
+		}
+	}
+
+	failed := false
+	evaluations := []corpus.MakroEvaluation{}
+	for _, file := range files {
+		fileEvaluations, err := corpus.EvaluateCorpusFile(file)
+		if err != nil {
+			log.Printf("%s: %s", file, err)
+			failed = true
+		}
+		for _, evaluation := range fileEvaluations {
+			if *makroName == "" || evaluation.MakroName == *makroName {
+				evaluations = append(evaluations, evaluation)
+			}
+		}
+	}
+	if err := write(os.Stdout, evaluations); err != nil {
+		log.Fatalln(err)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func extractMakrosCommand(args []string) {
+	flags := flag.NewFlagSet("extract-makros", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Save every makro and submakro used in Corpus files as CMK file, for example to rebuild lost Makro folder.
+When the same makro has different content in different files, the most used version is saved and conflict is reported.
+Values in [VARIJABLE] are taken from one of the plates, they are not compared.
+`)
+		fmt.Fprintf(w, "Usage of %s extract-makros [options] -output <DIR> <FILE|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var output *string = flags.String("output", "", "required. Makro dir, makro 'folder/name' is saved as <DIR>/folder/name.CMK")
+	var force *bool = flags.Bool("force", false, "default: false. Override existing CMK files")
+	var dryRun *bool = flags.Bool("dry-run", false, "default: false. Only print report, do not write any file")
+	var encoding corpus.TextEncoding
+	flags.TextVar(&encoding, "encoding", corpus.EncodingWindows1250, `Encoding of saved CMK files. One of: windows-1250 (the same as Corpus), utf-8, utf-8-bom`)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *output == "" && !*dryRun {
+		log.Fatalln("-output can not be empty")
+	}
+	files := []string{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		if statInput.IsDir() {
+			files = append(files, corpus.FindCorpusFiles(input)...)
+		} else {
+			files = append(files, input)
+		}
+	}
+
+	makros, errExtract := corpus.ExtractMakros(files)
+	if errExtract != nil {
+		log.Println(errExtract)
+	}
+	corpus.WriteExtractReportText(os.Stdout, makros)
+	if !*dryRun {
+		saved, err := corpus.WriteExtractedMakros(*output, makros, *force, encoding)
+		for _, file := range saved {
+			fmt.Printf("Saved: '%s'\n", file)
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if errExtract != nil {
+		os.Exit(1)
+	}
+}
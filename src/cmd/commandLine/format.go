@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func formatCommand(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Rewrite makro files (.CMK) in canonical layout: sections in the order Corpus writes them, numbered from 1, blank line between sections,
+Windows-1250 (see -encoding) and CRLF. Makro is never changed: formatted file is read again and compared with original.
+Names of rewritten files are printed.
+`)
+		fmt.Fprintf(w, "Usage of %s fmt [options] <FILE.CMK|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var check *bool = flags.Bool("check", false, "default: false. Do not write anything, print files that are not formatted and exit with code 1 if there are any")
+	var encoding corpus.TextEncoding
+	flags.TextVar(&encoding, "encoding", corpus.EncodingWindows1250, `Encoding of formatted files, encoding of input is detected. One of: windows-1250 (the same as Corpus), utf-8, utf-8-bom`)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	files := []string{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		if statInput.IsDir() {
+			files = append(files, corpus.FindMakroFiles(input)...)
+		} else {
+			files = append(files, input)
+		}
+	}
+
+	failed := false
+	for _, file := range files {
+		formatted, changed, err := corpus.FormatMakroFile(file, encoding)
+		if err != nil {
+			log.Printf("%s: %s", file, err)
+			failed = true
+			continue
+		}
+		if !changed {
+			continue
+		}
+		fmt.Println(file)
+		if *check {
+			failed = true
+			continue
+		}
+		if err := corpus.WriteFileAtomic(file, formatted); err != nil {
+			log.Printf("%s: %s", file, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func inventoryCommand(args []string) {
+	flags := flag.NewFlagSet("inventory", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `List where makros are used: makro -> file -> cabinet (ENAME) -> plate (DNAME) -> count, including submakros from [MAKRO] section.
+Plates with different [VARIJABLE] values are listed separately.
+`)
+		fmt.Fprintf(w, "Usage of %s inventory [options] <FILE|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var format *string = flags.String("format", "text", "output format printed to standard output: text, csv or json")
+	var output *string = flags.String("output", "", "optional. Save inventory to file instead of printing it. Format depends on extension: .txt, .csv or .json")
+	var makroName *string = flags.String("makro", "", "optional. List only makro with this name")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var write func(io.Writer, []corpus.InventoryRecord) error
+	switch *format {
+	case "text":
+		write = corpus.WriteInventoryText
+	case "csv":
+		write = corpus.WriteInventoryCSV
+	case "json":
+		write = corpus.WriteInventoryJSON
+	default:
+		log.Fatalf("-format must be text, csv or json: %s", *format)
+	}
+	files := []string{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		if statInput.IsDir() {
+			files = append(files, corpus.FindCorpusFiles(input)...)
+		} else {
+			files = append(files, input)
+		}
+	}
+
+	records, errInventory := corpus.NewInventory(files)
+	if errInventory != nil {
+		log.Println(errInventory)
+	}
+	if *makroName != "" {
+		filtered := []corpus.InventoryRecord{}
+		for _, record := range records {
+			if record.MakroName == *makroName {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+	if *output != "" {
+		if err := corpus.WriteInventoryFile(*output, records); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Inventory saved: '%s'", *output)
+	} else if err := write(os.Stdout, records); err != nil {
+		log.Fatalln(err)
+	}
+	if errInventory != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"corpus_macro_replacer/corpus"
+)
+
+type lintRuleFlags []corpus.LintRule
+
+func (r *lintRuleFlags) String() string {
+	return fmt.Sprintf("%v", *r)
+}
+
+func (r *lintRuleFlags) Set(value string) error {
+	var rule corpus.LintRule
+	if err := rule.UnmarshalText([]byte(value)); err != nil {
+		return err
+	}
+	*r = append(*r, rule)
+	return nil
+}
+
+func lintCommand(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Check makro files (.CMK): undefined and unused variables, duplicate keys, unknown sections, empty [MAKRO] NAME= and missing submakro files.
+Every issue is printed with line number. Exit code is 1 if any issue is found, so it can be used in CI.
+`)
+		fmt.Fprintf(w, "Usage of %s lint [options] <FILE.CMK|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var makroRootPath *string = flags.String("makros", "", `optional. Makro dir used to find submakros, usually "C:\Tri D Corpus\Corpus 5.0\Makro". Default: DIR or dir of FILE`)
+	var collectionFile *string = flags.String("collection", "", `optional. MakroCollection.dat used to find makro files by name`)
+	var format *string = flags.String("format", "text", "output format printed to standard output: text or json")
+	var disabled lintRuleFlags
+	flags.Var(&disabled, "disable", `optional. Do not report issues of this rule. Can be specified multiple times.
+One of: undefined-variable, unused-variable, duplicate-key, unknown-section, empty-makro-name, missing-submakro`)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var write func(io.Writer, []corpus.LintIssue) error
+	switch *format {
+	case "text":
+		write = corpus.WriteLintText
+	case "json":
+		write = corpus.WriteLintJSON
+	default:
+		log.Fatalf("-format must be text or json: %s", *format)
+	}
+	var collection corpus.MakroCollection
+	if *collectionFile != "" {
+		var err error
+		collection, err = corpus.NewMakroCollection(*collectionFile)
+		if err != nil {
+			log.Fatalf("can not read makro collection: %s", err)
+		}
+	}
+
+	failed := false
+	issues := []corpus.LintIssue{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		files := []string{input}
+		rootPath := filepath.Dir(input)
+		if statInput.IsDir() {
+			files = corpus.FindMakroFiles(input)
+			rootPath = input
+		}
+		if *makroRootPath != "" {
+			rootPath = *makroRootPath
+		}
+		inputIssues, err := corpus.LintMakroFiles(files, corpus.NewMakroLibrary(rootPath, collection), disabled)
+		if err != nil {
+			log.Println(err)
+			failed = true
+		}
+		issues = append(issues, inputIssues...)
+	}
+	if err := write(os.Stdout, issues); err != nil {
+		log.Fatalln(err)
+	}
+	if failed || len(issues) > 0 {
+		os.Exit(1)
+	}
+}
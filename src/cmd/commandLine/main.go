@@ -27,28 +27,93 @@ func (i *arrayFlags) Set(value string) error {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rollback":
+			rollbackCommand(os.Args[2:])
+			return
+		case "verify-roundtrip":
+			verifyRoundTripCommand(os.Args[2:])
+			return
+		case "extract-makros":
+			extractMakrosCommand(os.Args[2:])
+			return
+		case "inventory":
+			inventoryCommand(os.Args[2:])
+			return
+		case "stale":
+			staleCommand(os.Args[2:])
+			return
+		case "eval":
+			evalCommand(os.Args[2:])
+			return
+		case "lint":
+			lintCommand(os.Args[2:])
+			return
+		case "fmt":
+			formatCommand(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
 		fmt.Fprint(w, `This program is used to update makro in Copus (.E3D) files. 
 It is alternative to doule ticks in macro editor that actually works: 
-- it does not edit [JOINT] section
+- keeps old values in [JOINT] section, new keys are added
 - does a smart merge on [VARIJABLE] section, see README: https://github.com/Mateusz-Grzelinski/corpus-macro-replacer
 `)
 		fmt.Fprintf(w, "Usage of %s -input <PATH> -output <PATH> -makro <PATH>:\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Fprintf(w, `
+Other commands:
+  %[1]s rollback <MANIFEST>	restore files modified by -in-place run
+  %[1]s verify-roundtrip <FILE|DIR>	check that files are decoded and encoded without loss
+  %[1]s extract-makros -output <DIR> <FILE|DIR>...	save all makros used in Corpus files as CMK files
+  %[1]s inventory <FILE|DIR>...	list where makros are used
+  %[1]s stale -makros <DIR> <FILE|DIR>...	list files with makros older than in Makro dir
+  %[1]s eval <FILE|DIR>...	compute values of makro variables and formulas
+  %[1]s lint <FILE.CMK|DIR>...	check makro files for undefined and unused variables, duplicate keys and missing submakros
+  %[1]s fmt [-check] <FILE.CMK|DIR>...	rewrite makro files in canonical layout
+`, os.Args[0])
 	}
 	var version *bool = flag.Bool("v", false, "print version")
 	var verbose *bool = flag.Bool("verbose", false, "print more output")
 	var input *string = flag.String("input", "", "required. File or dir, must exist. If dir then changes macro recursively for all .E3D files.")
-	var output *string = flag.String("output", "", `required. File or dir, does not need to exist. 
+	var output *string = flag.String("output", "", `required (unless -dry-run or -in-place). File or dir, does not need to exist. 
 If input is dir then output must be dir, but will be created if does not exist. Directory structure of input is mirrored.
 If input is file the output can be file (must end with .E3D) or directory.`)
 	var makroFiles arrayFlags
 	flag.Var(&makroFiles, "makro", `required. Path to macro that should be replaced. Can be specified multiple times. Usually one of files in "C:\Tri D Corpus\Corpus 5.0\Makro"`)
 	var force *bool = flag.Bool("force", false, `default: false. Specify to override file specified in -output`)
 	var minify *bool = flag.Bool("minify", false, `default: false. Reduce file size by deleting spaces, (~7% size reduction)`)
-	var alwaysConvertLocalToGlobal *bool = flag.Bool("alwaysConvertLocalToGlobal", false, `default: false. Global variable start with "_" prefix - it takes value from "evar". 
-Default logic allows adding "_" prefix to variables that consists only from integers (no if statements, no +-* operations). It prevents from erasing your custom logic.`)
+	var globalStrategy corpus.GlobalStrategy
+	flag.TextVar(&globalStrategy, "globalStrategy", corpus.OnlyIfValueIsNumber, `What to do when new makro adds "_" prefix to local variable. Global variable start with "_" prefix - it takes value from "evar". 
+One of: OnlyIfValueIsNumber (convert only values that are numbers, no if statements, no +-* operations), Always, OnlyIfValueIsTheSame (convert only if old value is the same as new), KeepLocal.
+Default prevents from erasing your custom logic.`)
+	var baseDir *string = flag.String("base-dir", "", `optional. Directory with old versions of makro files, every subdirectory is one version: <DIR>/2024-01/makro.CMK.
+Enables three-way merge: version that embedded makro was created from is found, values that were not customized are updated, customized values are kept and conflicts are reported`)
+	var variableRenames arrayFlags
+	flag.Var(&variableRenames, "rename-variable", `optional. Variable renamed in new makro, old value is copied to new name. Can be specified multiple times.
+"old=new" renames variable in every makro, "makro:old=new" only in given makro, e.g.: -rename-variable Zawiasy:przesuniecie_zawias=offset_zawias`)
+	var jobFile *string = flag.String("job", "", `optional. JSON file with settings of this run: {"variableRenames": {"*": {"old": "new"}, "makro": {"old": "new"}}}.
+-rename-variable is applied after job file`)
+	var policyFile *string = flag.String("policy", "", `optional. File with per-variable rules (.json, .yaml or .toml) that override default merge. Rule has makro name, variable name glob and action:
+keep-old, take-new, drop or keep-if-exists. First matching rule wins, e.g.: {"rules": [{"makro": "*", "variable": "NUMER_NARZEDZIA*", "action": "take-new"}]}`)
+	var jointTakeNew arrayFlags
+	flag.Var(&jointTakeNew, "joint-take-new", `optional. [JOINT] key (glob) that takes value from new makro, by default old value is kept. Can be specified multiple times, e.g.: -joint-take-new CONNECT.
+Same as -policy rule {"makro": "*", "section": "JOINT", "variable": "CONNECT", "action": "take-new"}, rules from -policy file are checked first`)
+	var order corpus.VariableOrder
+	flag.TextVar(&order, "order", corpus.OrderNew, `Order of variables in updated makro. One of: new (order and comments of new makro),
+old (order and comments of old makro, new variables are placed next to their neighbours from new makro, deleted variables are listed in trailing comment)`)
+	var alwaysConvertLocalToGlobal *bool = flag.Bool("alwaysConvertLocalToGlobal", false, `deprecated, use -globalStrategy Always`)
+	var dryRun *bool = flag.Bool("dry-run", false, `default: false. Read and update makros as usual, but do not write any file. Prints report of what would change`)
+	var reportFile *string = flag.String("report", "", `optional. Save report of all changed variables to file. Format depends on extension: .json or .csv`)
+	var inPlace *bool = flag.Bool("in-place", false, `default: false. Overwrite input files instead of writing to -output. Every original file is saved as backup first.
+Run manifest listing all modified files is saved, use "rollback" command to restore them`)
+	var backupDir *string = flag.String("backup-dir", "", `optional. Used with -in-place. Save backups (and run manifest) to this dir instead of next to original files`)
+	var preserveFormatting *bool = flag.Bool("preserve-formatting", false, `default: false. Encode only updated makros, the rest of file is copied byte for byte. -minify is ignored`)
+	var jobs *int = flag.Int("jobs", 1, `default: 1. Number of files processed at the same time when input is dir`)
 
 	flag.Parse()
 
@@ -59,41 +124,143 @@ Default logic allows adding "_" prefix to variables that consists only from inte
 	if *input == "" {
 		log.Fatalln("-input can not be empty")
 	}
-	if *output == "" {
+	if *output == "" && !*dryRun && !*inPlace {
 		log.Fatalln("-output can not be empty")
 	}
+	if *output != "" && *inPlace {
+		log.Fatalln("-output can not be used with -in-place")
+	}
+	if *backupDir != "" && !*inPlace {
+		log.Fatalln("-backup-dir can be used only with -in-place")
+	}
 	if len(makroFiles) == 0 {
 		log.Fatalln("-makroFile can not be empty")
 	}
+	if *jobs < 1 {
+		log.Fatalf("-jobs must be at least 1: %d", *jobs)
+	}
+	if ext := strings.ToLower(filepath.Ext(*reportFile)); *reportFile != "" && ext != ".json" && ext != ".csv" {
+		log.Fatalf("-report must end with .json or .csv: %s", *reportFile)
+	}
 
 	statInput, errInput := os.Stat(*input)
 	if errInput != nil {
 		log.Fatalf("input '%s' is invalid: %s", *input, errInput)
 	}
 	statOutput, errOutput := os.Stat(*output)
-	if errOutput == nil && !statOutput.IsDir() && !*force {
+	if errOutput == nil && !statOutput.IsDir() && !*force && !*dryRun && !*inPlace {
 		log.Fatalf("output %s already exists. Add --force to override", *output)
 	}
+	if *alwaysConvertLocalToGlobal {
+		globalStrategy = corpus.Always
+	}
+	var bases *corpus.MakroSnapshots
+	if *baseDir != "" {
+		var errBases error
+		bases, errBases = corpus.NewMakroSnapshots(*baseDir)
+		if errBases != nil {
+			log.Fatalf("-base-dir '%s' is invalid: %s", *baseDir, errBases)
+		}
+	}
+	renames := corpus.MakroVariableRenames{}
+	if *jobFile != "" {
+		job, errJob := corpus.LoadJobFile(*jobFile)
+		if errJob != nil {
+			log.Fatalln(errJob)
+		}
+		for makroName, makroRenames := range job.VariableRenames {
+			for oldName, newName := range makroRenames {
+				renames.Add(makroName, oldName, newName)
+			}
+		}
+	}
+	for _, rename := range variableRenames {
+		if errRename := renames.Parse(rename); errRename != nil {
+			log.Fatalf("-rename-variable: %s", errRename)
+		}
+	}
+	var policy *corpus.MergePolicy
+	if *policyFile != "" {
+		var errPolicy error
+		policy, errPolicy = corpus.LoadMergePolicy(*policyFile)
+		if errPolicy != nil {
+			log.Fatalln(errPolicy)
+		}
+	}
+	if len(jointTakeNew) > 0 && policy == nil {
+		policy = &corpus.MergePolicy{}
+	}
+	for _, key := range jointTakeNew {
+		policy.Rules = append(policy.Rules, corpus.PolicyRule{Makro: "*", Section: "JOINT", Variable: key, Action: corpus.PolicyTakeNew})
+	}
+	options := corpus.ReplaceOptions{
+		MergeOptions:       corpus.MergeOptions{GlobalStrategy: globalStrategy, Bases: bases, VariableRenames: renames, Policy: policy, Order: order},
+		Verbose:            *verbose,
+		Minify:             *minify,
+		DryRun:             *dryRun,
+		Jobs:               *jobs,
+		InPlace:            *inPlace,
+		BackupDir:          *backupDir,
+		PreserveFormatting: *preserveFormatting,
+		RunID:              corpus.NewRunID(),
+	}
+	if *inPlace && !*dryRun {
+		root := *input
+		if !statInput.IsDir() {
+			root = filepath.Dir(*input)
+		}
+		manifestPath := corpus.ManifestPath(root, *backupDir, options.RunID)
+		if _, err := os.Stat(manifestPath); err == nil {
+			log.Fatalf("Manifest '%s' already exists, it would be overwritten by this run", manifestPath)
+		}
+		options.Manifest = corpus.NewManifestWriter(manifestPath, options.RunID)
+	}
+	var reports []corpus.FileReport
+	var err error
 	if statInput.IsDir() {
 		macroNamesOverrides := []*string{}
-		corpus.ReplaceMakroInCorpusFolder(*input, *output, makroFiles, macroNamesOverrides, *alwaysConvertLocalToGlobal, *verbose, *minify) // todo support from cmd line all options
+		reports, err = corpus.ReplaceMakroInCorpusFolder(*input, *output, makroFiles, macroNamesOverrides, options) // todo support from cmd line all options
 	} else {
-		if errOutput == nil && statOutput.IsDir() || !strings.HasSuffix(strings.ToLower(*output), ".e3d") {
-			var newOutput string = filepath.Join(*output, filepath.Base(*input))
-			output = &newOutput
-		}
-		_, errNewOutput := os.Stat(*output)
-		if errNewOutput == nil && !*force {
-			log.Fatalf("output %s already exists. Add --force to override", *output)
+		if *inPlace {
+			output = input
+		} else {
+			if errOutput == nil && statOutput.IsDir() || !strings.HasSuffix(strings.ToLower(*output), ".e3d") {
+				var newOutput string = filepath.Join(*output, filepath.Base(*input))
+				output = &newOutput
+			}
+			_, errNewOutput := os.Stat(*output)
+			if errNewOutput == nil && !*force && !*dryRun {
+				log.Fatalf("output %s already exists. Add --force to override", *output)
+			}
 		}
 
-		makrosToReplace, err := corpus.ReadMakrosFromCMK(makroFiles, nil, nil)
-		if err != nil {
-			log.Fatalf("can not read makros: %s", err)
+		makrosToReplace, errRead := corpus.ReadMakrosFromCMK(makroFiles, nil, nil, nil)
+		if errRead != nil {
+			log.Fatalf("can not read makros: %s", errRead)
+		}
+		var report *corpus.FileReport
+		report, err = corpus.ReplaceMakroInCorpusFile(*input, *output, makrosToReplace, map[string]string{}, options)
+		if report != nil {
+			reports = append(reports, *report)
 		}
-		corpus.ReplaceMakroInCorpusFile(*input, *output, makrosToReplace, map[string]string{}, *alwaysConvertLocalToGlobal, *verbose, *minify)
 		// todo support from cmd line all options
 	}
+	if *dryRun {
+		corpus.WriteReportText(os.Stdout, reports)
+	}
+	if options.Manifest != nil && len(options.Manifest.Manifest().Files) > 0 {
+		log.Printf("Run manifest saved, to undo changes use: %s rollback \"%s\"", os.Args[0], options.Manifest.Path())
+	}
+	if *reportFile != "" {
+		if errReport := corpus.WriteReportFile(*reportFile, reports); errReport != nil {
+			log.Printf("can not write report: %s", errReport)
+		} else {
+			log.Printf("Report saved: '%s'", *reportFile)
+		}
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	// makroFile := `C:\Tri D Corpus\Corpus 5.0\Makro\custom.CMK`
 	// inputFile := `C:\Tri D Corpus\Corpus 5.0\elmsav\_modifications\simple_original_custom_v1.E3D`
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func rollbackCommand(args []string) {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Restore all files modified by -in-place run from their backups.
+Manifest is saved after every backup of -in-place run: next to input or in -backup-dir.
+`)
+		fmt.Fprintf(w, "Usage of %s rollback [options] <MANIFEST>:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var dryRun *bool = flags.Bool("dry-run", false, "default: false. Only check that backups exist, do not restore anything")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	manifest, err := corpus.LoadRunManifest(flags.Arg(0))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Rolling back run %s (%d files)", manifest.RunID, len(manifest.Files))
+	restored, err := corpus.Rollback(*manifest, *dryRun)
+	for _, file := range restored {
+		if *dryRun {
+			fmt.Printf("Would restore: '%s'\n", file)
+		} else {
+			fmt.Printf("Restored: '%s'\n", file)
+		}
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func staleCommand(args []string) {
+	flags := flag.NewFlagSet("stale", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Compare makros embedded in Corpus files with current version from Makro dir and list files and plates that are out of date.
+Sections [FORMULE], [PILA], [POTROSNI], [POCKET], [RASTER], [GRUPA] and [MAKRO] are compared, [VARIJABLE] and [JOINT] are kept by replacement anyway.
+`)
+		fmt.Fprintf(w, "Usage of %s stale [options] -makros <DIR> <FILE|DIR>...:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var makroRootPath *string = flags.String("makros", "", `required. Makro dir, usually "C:\Tri D Corpus\Corpus 5.0\Makro"`)
+	var collectionFile *string = flags.String("collection", "", `optional. MakroCollection.dat used to find makro files by name`)
+	var format *string = flags.String("format", "text", "output format: text, csv or json")
+	var all *bool = flags.Bool("all", false, "default: false. Text format lists also makros that are up to date")
+	var filesOnly *bool = flags.Bool("files", false, "default: false. Print only files with stale makros, one per line")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *makroRootPath == "" {
+		log.Fatalln("-makros can not be empty")
+	}
+	var collection corpus.MakroCollection
+	if *collectionFile != "" {
+		var err error
+		collection, err = corpus.NewMakroCollection(*collectionFile)
+		if err != nil {
+			log.Fatalf("can not read makro collection: %s", err)
+		}
+		corpus.SetMakroCollectionCache(collection)
+	}
+	files := []string{}
+	for _, input := range flags.Args() {
+		statInput, err := os.Stat(input)
+		if err != nil {
+			log.Fatalf("input '%s' is invalid: %s", input, err)
+		}
+		if statInput.IsDir() {
+			files = append(files, corpus.FindCorpusFiles(input)...)
+		} else {
+			files = append(files, input)
+		}
+	}
+
+	records, errStale := corpus.FindStaleMakros(files, corpus.NewMakroLibrary(*makroRootPath, collection))
+	if errStale != nil {
+		log.Println(errStale)
+	}
+	var err error
+	switch {
+	case *filesOnly:
+		for _, file := range corpus.StaleFiles(records) {
+			fmt.Println(file)
+		}
+	case *format == "text":
+		err = corpus.WriteStaleReportText(os.Stdout, records, *all)
+	case *format == "csv":
+		err = corpus.WriteStaleReportCSV(os.Stdout, records)
+	case *format == "json":
+		err = corpus.WriteStaleReportJSON(os.Stdout, records)
+	default:
+		log.Fatalf("-format must be text, csv or json: %s", *format)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if errStale != nil {
+		os.Exit(1)
+	}
+}
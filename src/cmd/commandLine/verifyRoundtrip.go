@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"corpus_macro_replacer/corpus"
+)
+
+func verifyRoundTripCommand(args []string) {
+	flags := flag.NewFlagSet("verify-roundtrip", flag.ExitOnError)
+	flags.Usage = func() {
+		w := flags.Output()
+		fmt.Fprint(w, `Decode and encode Corpus files without changing anything and report what would be lost.
+Reports semantic differences (elements, attributes, C6DAT compared decoded) and first byte that differs.
+Exit code is 1 if any file is not identical.
+`)
+		fmt.Fprintf(w, "Usage of %s verify-roundtrip [options] <FILE|DIR>:\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	var preserveFormatting *bool = flags.Bool("preserve-formatting", false, "default: false. Encode only makros and copy the rest of file byte for byte, the same as -preserve-formatting in replacement")
+	var minify *bool = flags.Bool("minify", false, "default: false. Encode minified file. Ignored with -preserve-formatting")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	input := flags.Arg(0)
+	statInput, err := os.Stat(input)
+	if err != nil {
+		log.Fatalf("input '%s' is invalid: %s", input, err)
+	}
+	files := []string{input}
+	if statInput.IsDir() {
+		files = corpus.FindCorpusFiles(input)
+	}
+
+	reports := []corpus.RoundTripReport{}
+	failed := false
+	for _, file := range files {
+		_, report, err := corpus.VerifyRoundTrip(file, *preserveFormatting, *minify)
+		if err != nil {
+			log.Printf("can not verify '%s': %s", file, err)
+			failed = true
+			continue
+		}
+		reports = append(reports, *report)
+		if !report.Identical() {
+			failed = true
+		}
+	}
+	corpus.WriteRoundTripReportText(os.Stdout, reports)
+	if failed {
+		os.Exit(1)
+	}
+}
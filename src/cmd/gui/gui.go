@@ -122,6 +122,12 @@ func CorpusFileTreeOnSelected(uid widget.TreeNodeID) {
 	if err == nil {
 		if !stat.IsDir() && corpus.IsCorpusExtension(SelectedPath) {
 			projectFile, elementFile, err := corpus.NewCorpusFile(SelectedPath)
+			// preview shows only Spoj
+			if err == nil && elementFile != nil && elementFile.VER.Value == "17" {
+				err = corpus.CorpusVersion17To16(elementFile.Element)
+			} else if err == nil && projectFile != nil && projectFile.VER.Value == "17" {
+				err = corpus.CorpusVersion17To16(projectFile.Element)
+			}
 			if elementFile != nil {
 				loadedS3DFileForPreview = nil
 				loadedE3DFileForPreview = elementFile
@@ -306,7 +312,7 @@ func getRightPanel(a fyne.App, myWindow *fyne.Window) *widget.Accordion {
 		newMacroPathEntry.OnChanged = func(path string) {
 			// wasteful but the best error reporting
 			makroRootPath := fyne.CurrentApp().Preferences().String("makroSearchPath")
-			_, err := corpus.NewMakroFromCMKFile(nil, path, &makroRootPath, corpus.MakroCollectionCache.GetMakroMappings())
+			_, err := corpus.NewMakroFromCMKFile(nil, path, &makroRootPath, corpus.GetMakroCollectionCache().GetMakroMappings())
 			if err != nil {
 				log.Printf("ERROR: reading makro failed: %s\n", err)
 				makroErrorLabel.SetText(fmt.Sprintf("ERROR: %s", err))
@@ -340,7 +346,7 @@ func getRightPanel(a fyne.App, myWindow *fyne.Window) *widget.Accordion {
 			} else {
 				makroSearchPath := a.Preferences().String("makroSearchPath")
 				if newMacroNameEntry.Text == "" {
-					newMacroNameEntry.SetText(corpus.GetMacroNameByFileName(makroSearchPath, path, &corpus.MakroCollectionCache))
+					newMacroNameEntry.SetText(corpus.GetMacroNameByFileName(makroSearchPath, path, corpus.GetMakroCollectionCache()))
 				}
 				makroErrorLabel.Importance = widget.MediumImportance
 				makroErrorLabel.Hide()
@@ -375,7 +381,7 @@ func getRightPanel(a fyne.App, myWindow *fyne.Window) *widget.Accordion {
 						newMacroPathEntry.SetText(path)
 
 						makroSearchPath := a.Preferences().String("makroSearchPath")
-						newMacroNameEntry.SetText(corpus.GetMacroNameByFileName(makroSearchPath, path, &corpus.MakroCollectionCache))
+						newMacroNameEntry.SetText(corpus.GetMacroNameByFileName(makroSearchPath, path, corpus.GetMakroCollectionCache()))
 						row.Refresh()
 					}, *myWindow)
 
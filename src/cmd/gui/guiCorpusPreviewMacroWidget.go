@@ -25,6 +25,8 @@ type MacroContainer struct {
 	parentPlate   *PlateContainer
 	oldMakro      *corpus.M1
 	newMakro      *corpus.M1
+	// cabinet variables and plates used to compute values of oldMakro
+	evalContext *corpus.EvalContext
 
 	// for reference when updating
 	openButton          *widget.Button
@@ -93,7 +95,7 @@ func NewMacroContainer(nestLevel int, parentPlate *PlateContainer) *MacroContain
 		}
 		MacrosDefaultPathNormal := fyne.CurrentApp().Preferences().String("makroSearchPath")
 		oldMakroNameWithExtension := mc.oldMakro.MakroName + ".CMK"
-		macroFileName := cmp.Or(corpus.MakroCollectionCache.GetMacroFileNameByName(mc.oldMakro.MakroName), &oldMakroNameWithExtension)
+		macroFileName := cmp.Or(corpus.GetMakroCollectionCache().GetMacroFileNameByName(mc.oldMakro.MakroName), &oldMakroNameWithExtension)
 		macroGuessedPath := filepath.Join(MacrosDefaultPathNormal, *macroFileName)
 		addToLoadedFilesAndRefresh(SelectedPath)
 		for _, makroTochangeName := range MacrosToChangeNamesEntries {
@@ -157,6 +159,10 @@ func smartTextComparison(changes []corpus.Change) (string, string) {
 
 	// actual diff
 	for _, change := range changes {
+		if change.Rule != nil && change.Result != corpus.ValueSame {
+			smartTextComparisonRule(change, &oldReformatted, &newReformatted)
+			continue
+		}
 		switch change.Result {
 		case corpus.ValueAdded:
 			// oldReformatted.WriteString("\n")
@@ -164,6 +170,19 @@ func smartTextComparison(changes []corpus.Change) (string, string) {
 		case corpus.ValueSame:
 			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
 			newReformatted.WriteString("\n")
+		case corpus.ValueChangedConvertedToGlobal:
+			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+			newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zamieniono na globalną (%s), wartość z evar\n", *change.NewName, change.OldValue, globalStrategyLabels[*change.Strategy]))
+		case corpus.ValueChangedRemainedToLocal:
+			localName, _ := strings.CutPrefix(*change.NewName, "_")
+			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+			newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zostawiono lokalną (%s), nowa nazwa: %s\n", localName, change.OldValue, globalStrategyLabels[*change.Strategy], *change.NewName))
+		case corpus.ValueDeleted:
+			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+			newReformatted.WriteString("// usunięto\n")
+		case corpus.ValueKept:
+			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+			newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zachowano, brak w nowym makrze\n", *change.OldName, change.OldValue))
 		default:
 			oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
 			newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zachowano starą wartość (wartość obecna:%s)\n", *change.NewName, change.OldValue, change.NewValue))
@@ -173,6 +192,39 @@ func smartTextComparison(changes []corpus.Change) (string, string) {
 	return oldReformatted.String(), newReformatted.String()
 }
 
+// line of variable decided by rule of merge policy, rule is shown in comment
+func smartTextComparisonRule(change corpus.Change, oldReformatted *strings.Builder, newReformatted *strings.Builder) {
+	rule := fmt.Sprintf("reguła %s", change.Rule)
+	switch change.Result {
+	case corpus.ValueAdded:
+		oldReformatted.WriteString("\n")
+		newReformatted.WriteString(fmt.Sprintf("%s=%s \t// nowa zmienna dodana (%s)\n", *change.NewName, change.NewValue, rule))
+	case corpus.ValueDeleted:
+		oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+		newReformatted.WriteString(fmt.Sprintf("// usunięto (%s)\n", rule))
+	case corpus.ValueKept:
+		oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+		newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zachowano, usunięta w nowym makrze (%s)\n", *change.OldName, change.OldValue, rule))
+	case corpus.ValueUpdatedFromNew:
+		oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+		newReformatted.WriteString(fmt.Sprintf("%s=%s \t// wzięto nową wartość (%s)\n", *change.NewName, change.NewValue, rule))
+	default:
+		oldReformatted.WriteString(fmt.Sprintf("%s=%s\n", *change.OldName, change.OldValue))
+		newReformatted.WriteString(fmt.Sprintf("%s=%s \t// zachowano starą wartość (%s, wartość obecna:%s)\n", *change.NewName, change.OldValue, rule, change.NewValue))
+	}
+}
+
+// section with computed value after every line: "a=obj1.gr/2 // = 9"
+func evaluatedSectionCode(values []corpus.EvaluatedValue, section string) string {
+	var code strings.Builder
+	for _, value := range values {
+		if value.Section == section {
+			code.WriteString(fmt.Sprintf("%s=%s \t// = %s\n", value.Name, value.Expression, value.Result()))
+		}
+	}
+	return code.String()
+}
+
 func NewRichTextFromCMK(prefix string, a *corpus.GenericNodeWithDat) *widget.RichText {
 	if a == nil {
 		return NewRichTextFromCode(prefix, "\n")
@@ -230,19 +282,31 @@ func (mc *MacroContainer) UpdateMacroForDiff(newMakro *corpus.M1, compact bool)
 	}
 	mc.contentHeader.Refresh()
 
+	mergedMakro := newMakro.Copy()
+	options, errOptions := MergeOptionsPreference(fyne.CurrentApp())
+	policyWarning := ""
+	if errOptions != nil {
+		policyWarning = fmt.Sprintf("// UWAGA: podgląd bez reguł, %s\n", errOptions)
+	}
+	changes := corpus.UpdateMakro(mc.oldMakro, mergedMakro, nil, options)
+	varijableChanges, jointChanges := []corpus.Change{}, []corpus.Change{}
+	for _, change := range changes {
+		if change.Section == "JOINT" {
+			jointChanges = append(jointChanges, change)
+		} else if change.Section == "VARIJABLE" {
+			varijableChanges = append(varijableChanges, change)
+		}
+	}
 	{ // VARIJABLE
-		// todo only varijable changes are returned
-
-		newMakroCopyUntilIFixTheUpdateMakro := *newMakro
-		varijableChanges := corpus.UpdateMakro(mc.oldMakro, &newMakroCopyUntilIFixTheUpdateMakro, nil, false)
 		old, new := smartTextComparison(varijableChanges)
-		newRichText := NewRichTextFromCode("[VARIJABLE]\n// po zaktualizowaniu z pliku .CMK, ", new)
+		newRichText := NewRichTextFromCode(policyWarning+"[VARIJABLE]\n// po zaktualizowaniu z pliku .CMK, ", new)
 		oldRichText := NewRichTextFromCode("[VARIJABLE]\n// wczytane z Corpusa\n", old)
 		mc.contentDiff.Objects[0] = NewHSplitFromCMK(oldRichText, newRichText)
 	}
 	if oldMakro.Joint != nil || newMakro.Joint != nil {
-		newRichText := NewRichTextFromCMK("[JOINT]\n// sekcja JOINT nigdy nie jest aktualizowana\n", oldMakro.Joint)
-		oldRichText := NewRichTextFromCMK("[JOINT]\n\n", oldMakro.Joint)
+		old, new := smartTextComparison(jointChanges)
+		newRichText := NewRichTextFromCode(policyWarning+"[JOINT]\n// po zaktualizowaniu z pliku .CMK, ", new)
+		oldRichText := NewRichTextFromCode("[JOINT]\n// wczytane z Corpusa\n", old)
 		mc.contentDiff.Objects[1] = NewHSplitFromCMK(oldRichText, newRichText)
 	}
 
@@ -275,8 +339,8 @@ func (mc *MacroContainer) UpdateMacroForDiff(newMakro *corpus.M1, compact bool)
 		}
 		makroVBox := mc.contentDiff.Objects[7].(*fyne.Container)
 		makroVBox.RemoveAll()
-		for i, item := range newMakro.Makro {
-			makroVBox.Add(NewRichTextFromCMK(fmt.Sprintf("[MAKRO%d]\n// Wczytane z pliku .CMK\n", i), &item.GenericNodeWithDat))
+		for i, item := range mergedMakro.Makro {
+			makroVBox.Add(NewRichTextFromCMK(fmt.Sprintf("[MAKRO%d]\n// Wczytane z pliku .CMK, parametry wywołania połączone ze starym makrem\n", i), &item.GenericNodeWithDat))
 		}
 
 		mc.contentDiff.Objects[8] = widget.NewButtonWithIcon("Zwiń", theme.VisibilityOffIcon(), func() {
@@ -298,7 +362,7 @@ func (mc *MacroContainer) Update(oldMakro *corpus.M1, compact bool) {
 	for i, makroToChangeName := range MacrosToChangeNamesEntries {
 		if oldMakro.MakroName == makroToChangeName.Text {
 			makroRootPath := fyne.CurrentApp().Preferences().String("makroSearchPath")
-			makro, err := corpus.NewMakroFromCMKFile(nil, MacrosToChangeEntries[i].Text, &makroRootPath, corpus.MakroCollectionCache.GetMakroMappings())
+			makro, err := corpus.NewMakroFromCMKFile(nil, MacrosToChangeEntries[i].Text, &makroRootPath, corpus.GetMakroCollectionCache().GetMakroMappings())
 			if err != nil {
 				log.Printf("ERROR: reading makro failed: %s\n", err)
 			}
@@ -333,14 +397,15 @@ func (mc *MacroContainer) Update(oldMakro *corpus.M1, compact bool) {
 		}
 	}
 	mc.contentHeader.Refresh()
-	mc.contentRead.Objects[0] = NewRichTextFromCMK("[VARIJABLE]\n", &oldMakro.Varijable)
+	evaluated := corpus.EvaluateMakro(mc.evalContext, oldMakro)
+	mc.contentRead.Objects[0] = NewRichTextFromCode("[VARIJABLE]\n// obliczone wartości dla tej formatki\n", evaluatedSectionCode(evaluated, "VARIJABLE"))
 	mc.contentRead.Objects[1] = NewRichTextFromCMK("[JOINT]\n", oldMakro.Joint)
 
 	var showAllMakro *widget.Button
 	showAllMakro = widget.NewButton("Pokaż całe makro", func() {
 		showAllMakro.Hide()
 
-		mc.contentRead.Objects[2] = NewRichTextFromCMK("[FORMULE]\n", oldMakro.Formule)
+		mc.contentRead.Objects[2] = NewRichTextFromCode("[FORMULE]\n// obliczone wartości dla tej formatki\n", evaluatedSectionCode(evaluated, "FORMULE"))
 
 		pocketVBox := mc.contentRead.Objects[3].(*fyne.Container)
 		pocketVBox.RemoveAll()
@@ -78,6 +78,7 @@ func NewPlate(element *corpus.Element, adIndex int, nestLevel int, compact bool)
 		_con := NewMacroContainer(nestLevel, pc)
 		c.Add(_con)
 		macrosContainer.Add(_con)
+		_con.evalContext = corpus.NewEvalContext(element, spoj.O1.Value, spoj.O2.Value)
 		_con.Update(&spoj.Makro1, compact) // todo update here?
 	}
 	stats := widget.NewLabel(fmt.Sprintf("Makra: %d", howMenyMacros))
@@ -143,6 +144,7 @@ func (pc *PlateContainer) Update(element *corpus.Element, adIndex int, compact b
 			continue
 		}
 		macroCon := pc.macrosContainers.Objects[howManyMacros].(*MacroContainer)
+		macroCon.evalContext = corpus.NewEvalContext(element, spoj.O1.Value, spoj.O2.Value)
 		macroCon.Update(&spoj.Makro1, compact)
 		howManyMacros++
 	}
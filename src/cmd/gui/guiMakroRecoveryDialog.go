@@ -4,7 +4,6 @@ import (
 	"cmp"
 	"corpus_macro_replacer/corpus"
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"fyne.io/fyne/v2"
@@ -74,27 +73,23 @@ func NewDialogMakroRecovery(a fyne.App, w fyne.Window, failedMakroName string) *
 							return // lame version of break early
 						}
 						makroName := ""
+						makro := parent
 						if child != nil {
 							makroName = child.CalledWith()
+							makro = child.MAK
 						} else {
 							makroName = parent.MakroName
 						}
-						if makroName != failedMakroName {
+						if makroName != failedMakroName || makro == nil {
 							return
 						}
-						f, err := os.Create(missingMakroPath)
+						err := makro.SaveToFile(missingMakroPath, fmt.Sprintf("CorpusMakroReplacer: odzyskano z %s", SelectedPath))
 						if err != nil {
-							card2Result.SetText(fmt.Sprintf("otwarty plik Corpusa ma makro \"%s\", ale nie można go zapisać: %s", failedMakroName, err))
+							card2Result.SetText(fmt.Sprintf("odzyskano Makro \"%s\" z Corpusa, ale wystąpił błąd przy zapisywaniu: %s", failedMakroName, err))
 						} else {
-							_, err1 := f.Write([]byte(fmt.Sprintf("// CorpusMakroReplacer: odzyskano z %s\n", SelectedPath)))
-							err := s.Makro1.Save(f)
-							if err1 != nil && err != nil {
-								card2Result.SetText(fmt.Sprintf("odzyskano Makro \"%s\" z Corpusa, ale wystąpił błąd przy zapisywaniu: %s", failedMakroName, err))
-							} else {
-								card2Result.SetText(fmt.Sprintf("odzyskano Makro \"%s\" z Corpusa i zapisano: %s. Zawartość pliku może być niekatualna.", failedMakroName, missingMakroPath))
-								card2Result.Importance = widget.HighImportance
-								continueRecovery = false
-							}
+							card2Result.SetText(fmt.Sprintf("odzyskano Makro \"%s\" z Corpusa i zapisano: %s. Zawartość pliku może być niekatualna.", failedMakroName, missingMakroPath))
+							card2Result.Importance = widget.HighImportance
+							continueRecovery = false
 						}
 					})
 				}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"corpus_macro_replacer/corpus"
@@ -49,14 +51,15 @@ func NewPopupListOfFiles(w fyne.Window, files []string) *widget.List {
 		})
 }
 
-func WriteOutputTask(inputFile string, outputFile string, makrosToReplace map[string]*corpus.M1, makroRename map[string]string, err *string, alwaysConvertLocalToGlobal bool, verbose bool, minify bool) error {
+func WriteOutputTask(inputFile string, outputFile string, makrosToReplace map[string]*corpus.M1, makroRename map[string]string, err *string, options corpus.ReplaceOptions) error {
 	defer func() {
 		if r := recover(); r != nil {
 			fmt.Println("panic occured: ", r)
 			*err = fmt.Sprintf("💀 FATAL: %s: %s", inputFile, r)
 		}
 	}()
-	return corpus.ReplaceMakroInCorpusFile(inputFile, outputFile, makrosToReplace, makroRename, alwaysConvertLocalToGlobal, verbose, minify)
+	_, errReplace := corpus.ReplaceMakroInCorpusFile(inputFile, outputFile, makrosToReplace, makroRename, options)
+	return errReplace
 }
 
 func WriteOutput(
@@ -66,9 +69,7 @@ func WriteOutput(
 	makroFiles []string,
 	makroNamesOverrides []*string,
 	makroOldNameToNewName map[string]string,
-	alwaysConvertLocalToGlobal bool,
-	verbose bool,
-	minify bool,
+	options corpus.ReplaceOptions,
 	makroRootPath *string,
 	makroMapping corpus.MakroMappings,
 ) {
@@ -90,27 +91,47 @@ func WriteOutput(
 		logData.Set(currentLog)
 		return
 	}
-	panicErrors := []string{}
-	normalErrors := []string{}
-	for i, inputFile := range foundCorpusFiles {
-		currentLog = append(currentLog, fmt.Sprintf("%d/%d: %s", i+1, len(foundCorpusFiles), inputFile))
+	// errors are stored by index, so they are reported in the same order regardless of options.Jobs
+	panicErrorsByFile := make([]string, len(foundCorpusFiles))
+	normalErrorsByFile := make([]string, len(foundCorpusFiles))
+	var currentLogMutex sync.Mutex
+	done := 0
+	corpus.RunParallel(options.Jobs, len(foundCorpusFiles), func(i int) {
+		inputFile := foundCorpusFiles[i]
 		outputFile := corpus.GetCleanOutputpath(outputDir, inputFile)
+		fileOptions := options
+		if options.Jobs > 1 {
+			var flush func()
+			fileOptions.Logger, flush = corpus.NewGroupedLogger()
+			defer flush()
+		}
 		var panicErrorToReport *string = new(string)
-		err := WriteOutputTask(inputFile, outputFile, makrosToReplace, makroOldNameToNewName, panicErrorToReport, alwaysConvertLocalToGlobal, verbose, minify)
+		err := WriteOutputTask(inputFile, outputFile, makrosToReplace, makroOldNameToNewName, panicErrorToReport, fileOptions)
+
+		currentLogMutex.Lock()
+		defer currentLogMutex.Unlock()
+		done++
+		currentLog = append(currentLog, fmt.Sprintf("%d/%d: %s", done, len(foundCorpusFiles), inputFile))
 		if *panicErrorToReport != "" {
-			panicErrors = append(panicErrors, *panicErrorToReport)
+			panicErrorsByFile[i] = *panicErrorToReport
 			currentLog = append(currentLog, *panicErrorToReport)
 		}
 		if err != nil {
 			normalErrorMessage := fmt.Sprintf("⚠ ERROR: '%s' %s", outputFile, err)
-			normalErrors = append(normalErrors, normalErrorMessage)
+			normalErrorsByFile[i] = normalErrorMessage
 			currentLog = append(currentLog, normalErrorMessage)
 		}
-		// _, err := io.Copy(&buf, r)
-		// fmt.Print(err)
-		// buf.ReadString(r)
-		// logWindow.Set(append(current, buf.String()))
 		logData.Set(currentLog)
+	})
+	panicErrors := []string{}
+	normalErrors := []string{}
+	for i := range foundCorpusFiles {
+		if panicErrorsByFile[i] != "" {
+			panicErrors = append(panicErrors, panicErrorsByFile[i])
+		}
+		if normalErrorsByFile[i] != "" {
+			normalErrors = append(normalErrors, normalErrorsByFile[i])
+		}
 	}
 	if len(normalErrors) != 0 {
 		message := fmt.Sprintf("⚠ W %d plikach wystąpiły błędy", len(normalErrors))
@@ -209,11 +230,19 @@ func onTappedOutputPopup(a fyne.App, self widget.ToolbarItem, w fyne.Window) fun
 								name := string(e.Text)
 								macroNamesOverrides = append(macroNamesOverrides, &name)
 							}
-							alwaysConvertLocalToGlobal := a.Preferences().Bool("alwaysConvertLocalToGlobal")
-							verbose := a.Preferences().Bool("verbose")
-							minify := a.Preferences().Bool("minify")
+							mergeOptions, err := MergeOptionsPreference(a)
+							if err != nil {
+								dialog.ShowError(err, w)
+								return
+							}
+							options := corpus.ReplaceOptions{
+								MergeOptions: mergeOptions,
+								Verbose:      a.Preferences().Bool("verbose"),
+								Minify:       a.Preferences().Bool("minify"),
+								Jobs:         a.Preferences().IntWithFallback("jobs", 1),
+							}
 							makroRootPath := a.Preferences().String("makroSearchPath")
-							WriteOutput(logData, foundCorpusFiles, outputPath.Text, macroFilesTochange, macroNamesOverrides, macrosToRename, alwaysConvertLocalToGlobal, verbose, minify, &makroRootPath, corpus.MakroCollectionCache.GetMakroMappings())
+							WriteOutput(logData, foundCorpusFiles, outputPath.Text, macroFilesTochange, macroNamesOverrides, macrosToRename, options, &makroRootPath, corpus.GetMakroCollectionCache().GetMakroMappings())
 							logWindow.Refresh()
 						}),
 						widget.NewButtonWithIcon("", theme.MoreVerticalIcon(), func() {
@@ -226,7 +255,17 @@ func onTappedOutputPopup(a fyne.App, self widget.ToolbarItem, w fyne.Window) fun
 								a.Preferences().SetBool("verbose", b)
 							})
 							checkVerbose.Checked = a.Preferences().Bool("verbose")
-							popup := dialog.NewCustom("Ustawienia wynikowych plików", "Ok", container.NewVBox(checkMinify, checkVerbose), w)
+
+							jobs := a.Preferences().IntWithFallback("jobs", 1)
+							jobsLabel := widget.NewLabel(fmt.Sprintf("Liczba plików przetwarzanych jednocześnie: %d", jobs))
+							sliderJobs := widget.NewSlider(1, float64(max(runtime.NumCPU(), jobs)))
+							sliderJobs.Step = 1
+							sliderJobs.Value = float64(jobs)
+							sliderJobs.OnChanged = func(f float64) {
+								a.Preferences().SetInt("jobs", int(f))
+								jobsLabel.SetText(fmt.Sprintf("Liczba plików przetwarzanych jednocześnie: %d", int(f)))
+							}
+							popup := dialog.NewCustom("Ustawienia wynikowych plików", "Ok", container.NewVBox(checkMinify, checkVerbose, jobsLabel, sliderJobs), w)
 							popup.Show()
 						}),
 					),
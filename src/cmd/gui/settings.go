@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"corpus_macro_replacer/corpus"
 
@@ -10,44 +13,93 @@ import (
 	"fyne.io/fyne/v2/widget"
 )
 
-type ConvertLocalVariablesToGlobal string
-
-const (
-	Always ConvertLocalVariablesToGlobal = "always"
-	OnlyIfValueIsTheSame
-	OnlyIfValueIsNumber
-	/*
-		{
-			[VARIJABLE] // old
-			grubosc=32
+var globalStrategyLabels = map[corpus.GlobalStrategy]string{
+	corpus.OnlyIfValueIsNumber:  "Tylko jeśli stara wartość jest liczbą",
+	corpus.Always:               "Zawsze",
+	corpus.OnlyIfValueIsTheSame: "Tylko jeśli stara wartość jest taka sama jak nowa",
+	corpus.KeepLocal:            "Nigdy, zostaw zmienną lokalną",
+}
 
-			[VARIJABLE] // new
-			_grubosc=18
+// saved in preferences as corpus.GlobalStrategy.MarshalText
+func GlobalStrategyPreference(a fyne.App) corpus.GlobalStrategy {
+	name := a.Preferences().String("globalStrategy")
+	if name == "" && a.Preferences().Bool("alwaysConvertLocalToGlobal") {
+		// setting of older versions, false was the same as OnlyIfValueIsNumber
+		return corpus.Always
+	}
+	var strategy corpus.GlobalStrategy
+	if err := strategy.UnmarshalText([]byte(name)); err != nil {
+		return corpus.OnlyIfValueIsNumber
+	}
+	return strategy
+}
 
-			[VARIJABLE] // output
-			grubosc=32
-		}
-		note global value is ignored:
-		{
-			[VARIJABLE] // output
-			_grubosc=32 // 32 is ignored
+func NewGlobalStrategySelect(a fyne.App) *widget.Select {
+	options := []string{}
+	for _, strategy := range corpus.GlobalStrategies {
+		options = append(options, globalStrategyLabels[strategy])
+	}
+	selectStrategy := widget.NewSelect(options, func(label string) {
+		for strategy, strategyLabel := range globalStrategyLabels {
+			if strategyLabel == label {
+				name, _ := strategy.MarshalText()
+				a.Preferences().SetString("globalStrategy", string(name))
+			}
 		}
-	*/
-	KeepLocal
-	/*
-		{
-			[VARIJABLE] // old
-			grubosc=if(sz>50;18;32)
+	})
+	selectStrategy.Selected = globalStrategyLabels[GlobalStrategyPreference(a)]
+	return selectStrategy
+}
 
-			[VARIJABLE] // new
-			_grubosc=18
+// policy is used for every preview and export, file is read again only when its path or modification time changes
+var mergePolicyCache struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	policy  *corpus.MergePolicy
+	err     error
+}
 
-			[VARIJABLE] // output
-			grubosc=if(sz>50;18;32)
-		}
-	*/
-	// KeepLocalIfHasCondition
-)
+func loadMergePolicyCached(policyPath string) (*corpus.MergePolicy, error) {
+	mergePolicyCache.Lock()
+	defer mergePolicyCache.Unlock()
+	var modTime time.Time
+	if stat, err := os.Stat(policyPath); err == nil {
+		modTime = stat.ModTime()
+	}
+	if policyPath != mergePolicyCache.path || modTime.IsZero() || !modTime.Equal(mergePolicyCache.modTime) {
+		mergePolicyCache.policy, mergePolicyCache.err = corpus.LoadMergePolicy(policyPath)
+		mergePolicyCache.path, mergePolicyCache.modTime = policyPath, modTime
+	}
+	return mergePolicyCache.policy, mergePolicyCache.err
+}
+
+// nil if policy is not set. Error if policy file can not be loaded, files must not be updated without its rules
+func MergePolicyPreference(a fyne.App) (*corpus.MergePolicy, error) {
+	policyPath := a.Preferences().String("mergePolicyPath")
+	if policyPath == "" {
+		return nil, nil
+	}
+	return loadMergePolicyCached(policyPath)
+}
+
+// saved in preferences as corpus.VariableOrder.MarshalText
+func VariableOrderPreference(a fyne.App) corpus.VariableOrder {
+	var order corpus.VariableOrder
+	if err := order.UnmarshalText([]byte(a.Preferences().String("variableOrder"))); err != nil {
+		return corpus.OrderNew
+	}
+	return order
+}
+
+// error if merge policy can not be loaded, see MergePolicyPreference. Options are still returned, without policy
+func MergeOptionsPreference(a fyne.App) (corpus.MergeOptions, error) {
+	policy, err := MergePolicyPreference(a)
+	if err != nil {
+		err = fmt.Errorf("reguły dla zmiennych: %w", err)
+	}
+	return corpus.MergeOptions{GlobalStrategy: GlobalStrategyPreference(a), Policy: policy, Order: VariableOrderPreference(a)}, err
+}
 
 func NewCorpusMakroReplacerSettings(a fyne.App) *widget.Card {
 	labelSearch := widget.NewLabel("Domyślna ścieżka szukania Makr")
@@ -66,7 +118,7 @@ func NewCorpusMakroReplacerSettings(a fyne.App) *widget.Card {
 	makroCollectionEntry.SetText(makroCollectionPath)
 	makroCollectionEntry.OnChanged = func(inputPath string) {
 		collection, err := corpus.NewMakroCollection(inputPath)
-		corpus.MakroCollectionCache = collection
+		corpus.SetMakroCollectionCache(collection)
 		errLabel.Show()
 		if err != nil {
 			errLabel.SetText(fmt.Sprintf("error: %s", err))
@@ -82,5 +134,40 @@ func NewCorpusMakroReplacerSettings(a fyne.App) *widget.Card {
 
 	}
 	makroCollectionEntry.OnChanged(makroCollectionPath) // run to report any errors
-	return widget.NewCard("Ustawienia makr", "", container.NewVBox(labelSearch, makroSearchEntry, label, makroCollectionEntry, errLabel))
+	labelStrategy := widget.NewLabel(`Kiedy zamienić zmienną lokalną na globalną (nowe makro dodaje "_" do nazwy zmiennej). Zmienna globalna bierze wartość z "evar", stara wartość jest ignorowana`)
+	labelStrategy.Wrapping = fyne.TextWrapBreak
+	labelPolicy := widget.NewLabel("Opcjonalna ścieżka do pliku z regułami dla zmiennych (.json, .yaml, .toml). Reguła: nazwa makra, nazwa zmiennej (może zawierać *) i akcja: keep-old, take-new, drop, keep-if-exists")
+	labelPolicy.Wrapping = fyne.TextWrapBreak
+	errPolicyLabel := widget.NewLabel("")
+	errPolicyLabel.Wrapping = fyne.TextWrapBreak
+	policyEntry := widget.NewEntry()
+	policyEntry.SetText(a.Preferences().String("mergePolicyPath"))
+	policyEntry.OnChanged = func(inputPath string) {
+		a.Preferences().SetString("mergePolicyPath", inputPath)
+		if inputPath == "" {
+			errPolicyLabel.Hide()
+			return
+		}
+		errPolicyLabel.Show()
+		policy, err := loadMergePolicyCached(inputPath)
+		if err != nil {
+			errPolicyLabel.SetText(fmt.Sprintf("error: %s", err))
+			errPolicyLabel.Importance = widget.DangerImportance
+		} else {
+			errPolicyLabel.SetText(fmt.Sprintf("Reguły: załadowano %d", len(policy.Rules)))
+			errPolicyLabel.Importance = widget.MediumImportance
+		}
+		errPolicyLabel.Refresh()
+	}
+	policyEntry.OnChanged(policyEntry.Text) // run to report any errors
+	keepOldOrder := widget.NewCheck("Zachowaj kolejność i komentarze zmiennych ze starego makra (usunięte zmienne zostaną wypisane w komentarzu na końcu)", func(checked bool) {
+		order := corpus.OrderNew
+		if checked {
+			order = corpus.OrderOld
+		}
+		name, _ := order.MarshalText()
+		a.Preferences().SetString("variableOrder", string(name))
+	})
+	keepOldOrder.Checked = VariableOrderPreference(a) == corpus.OrderOld
+	return widget.NewCard("Ustawienia makr", "", container.NewVBox(labelSearch, makroSearchEntry, label, makroCollectionEntry, errLabel, labelStrategy, NewGlobalStrategySelect(a), labelPolicy, policyEntry, errPolicyLabel, keepOldOrder))
 }
@@ -0,0 +1,165 @@
+package corpus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const ManifestFilePrefix = "Corpus_Macro_Replacer_run_"
+
+// identifies single in-place run, used in backup names and manifest name.
+// Milliseconds are included, so two runs started in the same second do not share manifest
+func NewRunID() string {
+	now := time.Now()
+	return fmt.Sprintf("%s-%03d", now.Format("2006-01-02_15-04-05"), now.Nanosecond()/int(time.Millisecond))
+}
+
+// where original file is copied before in-place update.
+// If backupDir is empty backup is saved next to file: simple.E3D -> simple.E3D.<runID>.bak
+// otherwise to backupDir/<runID>/ mirroring directory structure relative to root: simple.E3D.bak.
+// Backups never have Corpus extension, so they are not found by FindCorpusFiles when backupDir is inside input folder
+func BackupPath(file string, root string, backupDir string, runID string) string {
+	if backupDir == "" {
+		return file + "." + runID + ".bak"
+	}
+	relFile, err := filepath.Rel(root, file)
+	if err != nil || !filepath.IsLocal(relFile) {
+		relFile = filepath.Base(file)
+	}
+	return filepath.Join(backupDir, runID, relFile+".bak")
+}
+
+func BackupFile(file string, backupPath string) error {
+	err := os.MkdirAll(filepath.Dir(backupPath), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("can not create backup dir: %w", err)
+	}
+	if _, err := os.Stat(backupPath); err == nil {
+		return fmt.Errorf("backup already exists: '%s'", backupPath)
+	}
+	err = CopyFile(file, backupPath)
+	if err != nil {
+		return fmt.Errorf("can not backup '%s': %w", file, err)
+	}
+	return nil
+}
+
+type ManifestEntry struct {
+	File   string `json:"file"`
+	Backup string `json:"backup"`
+}
+
+// list of files modified by single in-place run, allows to rollback the whole run
+type RunManifest struct {
+	RunID   string          `json:"runId"`
+	Created time.Time       `json:"created"`
+	Files   []ManifestEntry `json:"files"`
+}
+
+// manifest is saved next to backups: in backupDir if specified, otherwise in root
+func ManifestPath(root string, backupDir string, runID string) string {
+	if backupDir != "" {
+		return filepath.Join(backupDir, runID, ManifestFilePrefix+runID+".json")
+	}
+	return filepath.Join(root, ManifestFilePrefix+runID+".json")
+}
+
+func (m RunManifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("can not create manifest dir: %w", err)
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// saves manifest after every added backup, so interrupted run can still be rolled back. Safe for concurrent use
+type ManifestWriter struct {
+	path     string
+	mutex    sync.Mutex
+	manifest RunManifest
+}
+
+// manifest file is created with first added backup
+func NewManifestWriter(path string, runID string) *ManifestWriter {
+	return &ManifestWriter{path: path, manifest: RunManifest{RunID: runID, Created: time.Now(), Files: []ManifestEntry{}}}
+}
+
+func (w *ManifestWriter) Path() string {
+	return w.path
+}
+
+// copy of manifest saved so far
+func (w *ManifestWriter) Manifest() RunManifest {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	manifest := w.manifest
+	manifest.Files = slices.Clone(w.manifest.Files)
+	return manifest
+}
+
+func (w *ManifestWriter) Add(file string, backup string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	file, _ = filepath.Abs(file)
+	backup, _ = filepath.Abs(backup)
+	w.manifest.Files = append(w.manifest.Files, ManifestEntry{File: file, Backup: backup})
+	return w.manifest.Save(w.path)
+}
+
+// removes file whose update failed (its backup is deleted)
+func (w *ManifestWriter) Remove(file string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	file, _ = filepath.Abs(file)
+	w.manifest.Files = slices.DeleteFunc(w.manifest.Files, func(entry ManifestEntry) bool { return entry.File == file })
+	return w.manifest.Save(w.path)
+}
+
+func LoadRunManifest(path string) (*RunManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read manifest: %w", err)
+	}
+	var manifest RunManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest '%s': %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// restores every file from manifest from its backup. Continues on error, returns all errors.
+// Returns restored files
+func Rollback(manifest RunManifest, dryRun bool) ([]string, error) {
+	restored := []string{}
+	var errs []error
+	for _, entry := range manifest.Files {
+		var err error
+		if !dryRun {
+			var data []byte
+			data, err = os.ReadFile(entry.Backup)
+			if err == nil {
+				err = WriteFileAtomic(entry.File, data)
+			}
+		} else if _, statErr := os.Stat(entry.Backup); statErr != nil {
+			err = statErr
+		}
+		if err != nil {
+			err = fmt.Errorf("can not restore '%s' from '%s': %w", entry.File, entry.Backup, err)
+			errs = append(errs, err)
+			continue
+		}
+		restored = append(restored, entry.File)
+	}
+	return restored, errors.Join(errs...)
+}
@@ -0,0 +1,102 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReplaceMakroInCorpusFolderInPlaceAndRollback(t *testing.T) {
+	cmk, err := os.ReadFile(filepath.Join(pathToCMKTestData, "simple.CMK"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	original, err := os.ReadFile(filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	dir := t.TempDir()
+	makroFile := filepath.Join(dir, "gorny.CMK")
+	os.WriteFile(makroFile, cmk, 0644)
+	inputFolder := filepath.Join(dir, "input")
+	// backups inside input folder must not be found as input files
+	backupDir := filepath.Join(inputFolder, "backup")
+	os.MkdirAll(filepath.Join(inputFolder, "sub"), os.ModePerm)
+	files := []string{filepath.Join(inputFolder, "a.E3D"), filepath.Join(inputFolder, "sub", "b.E3D")}
+	for _, file := range files {
+		os.WriteFile(file, original, 0644)
+	}
+	brokenFile := filepath.Join(inputFolder, "broken.E3D")
+	os.WriteFile(brokenFile, []byte("<ELEMENTFILE>"), 0644)
+
+	runID := "test"
+	manifestPath := ManifestPath(inputFolder, backupDir, runID)
+	options := ReplaceOptions{InPlace: true, BackupDir: backupDir, RunID: runID, Jobs: 2, Manifest: NewManifestWriter(manifestPath, runID)}
+	reports, err := ReplaceMakroInCorpusFolder(inputFolder, "", []string{makroFile}, nil, options)
+	if err == nil {
+		t.Error("broken file should fail")
+	}
+	reports = slices.DeleteFunc(reports, func(report FileReport) bool {
+		if report.InputFile == brokenFile && report.Backup != "" {
+			t.Errorf("backup of failed file should be removed: %s", report.Backup)
+		}
+		return report.InputFile == brokenFile
+	})
+	if found := FindCorpusFiles(inputFolder); len(found) != len(files)+1 {
+		t.Errorf("backups should not be found: %v", found)
+	}
+	for i, file := range files {
+		if reports[i].OutputFile != file {
+			t.Errorf("in place update should write to input file: '%s' != '%s'", reports[i].OutputFile, file)
+		}
+		expectedBackup := BackupPath(file, inputFolder, backupDir, runID)
+		if reports[i].Backup != expectedBackup {
+			t.Errorf("wrong backup path: '%s' != '%s'", reports[i].Backup, expectedBackup)
+		}
+		backup, _ := os.ReadFile(expectedBackup)
+		if string(backup) != string(original) {
+			t.Errorf("backup is different from original: '%s'", expectedBackup)
+		}
+		updated, _ := os.ReadFile(file)
+		if string(updated) == string(original) {
+			t.Errorf("file was not updated: '%s'", file)
+		}
+	}
+
+	// manifest is written as files are updated, failed file is not in it
+	manifest, err := LoadRunManifest(manifestPath)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(manifest.Files) != len(files) {
+		t.Errorf("wrong number of files in manifest: %d", len(manifest.Files))
+	}
+	restored, err := Rollback(*manifest, false)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(restored) != len(files) {
+		t.Errorf("wrong number of restored files: %d", len(restored))
+	}
+	for _, file := range files {
+		content, _ := os.ReadFile(file)
+		if string(content) != string(original) {
+			t.Errorf("file was not restored: '%s'", file)
+		}
+	}
+}
+
+func TestBackupPath(t *testing.T) {
+	root := filepath.Join("corpus", "elmsav")
+	file := filepath.Join(root, "kitchen", "simple.E3D")
+	if got := BackupPath(file, root, "", "run"); got != file+".run.bak" {
+		t.Errorf("backup next to file: %s", got)
+	}
+	if got := BackupPath(file, root, "backup", "run"); got != filepath.Join("backup", "run", "kitchen", "simple.E3D.bak") {
+		t.Errorf("backup in backup dir: %s", got)
+	}
+}
@@ -0,0 +1,112 @@
+package corpus
+
+import (
+	"strings"
+)
+
+/*
+DAT attribute (and every section of makro) is a list of lines saved the way Delphi TStringList.CommaText does it:
+
+  - lines are separated by ","
+  - line that contains character <= ' ', '"' or ',' is quoted, quote inside line is doubled: if(a,b) -> "if(a,b)", a"b -> "a""b"
+  - empty line is not quoted ("a,,b"), except list with single empty line which is "". Empty list is empty string
+  - when reading, whitespace around lines is skipped and not quoted text is also split on whitespace
+*/
+
+// true if line must be quoted in DAT, see EncodeDAT
+func needsDATQuotes(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; c <= ' ' || c == '"' || c == CMKLineSeparator[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// line as written in DAT, without separator
+func quoteDATLine(line string) string {
+	if !needsDATQuotes(line) {
+		return line
+	}
+	return `"` + strings.ReplaceAll(line, `"`, `""`) + `"`
+}
+
+// reverse of DecodeDAT
+func EncodeDAT(lines []string) string {
+	if len(lines) == 1 && lines[0] == "" {
+		return `""`
+	}
+	quoted := make([]string, len(lines))
+	for i, line := range lines {
+		quoted[i] = quoteDATLine(line)
+	}
+	return strings.Join(quoted, CMKLineSeparator)
+}
+
+// single line of DAT: decoded value and text of line as it is in DAT (with quotes)
+type datToken struct {
+	value string
+	raw   string
+}
+
+func isDATWhitespace(c byte) bool {
+	return c <= ' '
+}
+
+// splits DAT like Delphi TStrings.SetDelimitedText with QuoteChar '"' and Delimiter ','
+func tokenizeDAT(dat string) []datToken {
+	tokens := []datToken{}
+	i := 0
+	skipWhitespace := func() {
+		for i < len(dat) && isDATWhitespace(dat[i]) {
+			i++
+		}
+	}
+	skipWhitespace()
+	for i < len(dat) {
+		start := i
+		var value strings.Builder
+		if dat[i] == '"' {
+			// quoted line ends on single quote, doubled quote is part of line. Missing closing quote ends line at end of DAT
+			i++
+			for i < len(dat) {
+				if dat[i] != '"' {
+					value.WriteByte(dat[i])
+					i++
+				} else if i+1 < len(dat) && dat[i+1] == '"' {
+					value.WriteByte('"')
+					i += 2
+				} else {
+					i++
+					break
+				}
+			}
+		} else {
+			for i < len(dat) && !isDATWhitespace(dat[i]) && dat[i] != CMKLineSeparator[0] {
+				i++
+			}
+			value.WriteString(dat[start:i])
+		}
+		tokens = append(tokens, datToken{value: value.String(), raw: dat[start:i]})
+		skipWhitespace()
+		if i < len(dat) && dat[i] == CMKLineSeparator[0] {
+			i++
+			// separator at the end starts empty line
+			if i == len(dat) {
+				tokens = append(tokens, datToken{})
+			}
+			skipWhitespace()
+		}
+	}
+	return tokens
+}
+
+// lines of DAT, see EncodeDAT
+func DecodeDAT(dat string) []string {
+	tokens := tokenizeDAT(dat)
+	lines := make([]string, len(tokens))
+	for i, token := range tokens {
+		lines[i] = token.value
+	}
+	return lines
+}
@@ -0,0 +1,102 @@
+package corpus
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDAT(t *testing.T) {
+	type testCase struct {
+		lines    []string
+		expected string
+	}
+	tests := []testCase{
+		{[]string{}, ""},
+		{[]string{""}, `""`},
+		{[]string{"", ""}, ","},
+		{[]string{"a=1", "b=2"}, "a=1,b=2"},
+		{[]string{"GB=if(a,b)"}, `"GB=if(a,b)"`},
+		{[]string{"GN=frezowanie pila"}, `"GN=frezowanie pila"`},
+		{[]string{`GN=a"b`}, `"GN=a""b"`},
+		{[]string{"//=Kołek_3D"}, "//=Kołek_3D"},
+		{[]string{"a=\t1"}, "\"a=\t1\""},
+	}
+	for _, test := range tests {
+		if dat := EncodeDAT(test.lines); dat != test.expected {
+			t.Errorf("%q: expected '%s', got '%s'", test.lines, test.expected, dat)
+		}
+	}
+}
+
+func TestDecodeDAT(t *testing.T) {
+	type testCase struct {
+		dat      string
+		expected []string
+	}
+	tests := []testCase{
+		{"", []string{}},
+		{"   ", []string{}},
+		{`""`, []string{""}},
+		{",", []string{"", ""}},
+		{"a=1,", []string{"a=1", ""}},
+		{"a=1, ", []string{"a=1"}},
+		{"a=1,,b=2", []string{"a=1", "", "b=2"}},
+		{" a=1 , b=2 ", []string{"a=1", "b=2"}},
+		{"GN=bez cudzyslowu", []string{"GN=bez", "cudzyslowu"}},
+		{`"GB=if(a,b)",J=1`, []string{"GB=if(a,b)", "J=1"}},
+		{`"GN=a""b"`, []string{`GN=a"b`}},
+		{`"GN=bez konca`, []string{"GN=bez konca"}},
+		{`a"b"`, []string{`a"b"`}},
+	}
+	for _, test := range tests {
+		if lines := DecodeDAT(test.dat); !slices.Equal(lines, test.expected) {
+			t.Errorf("'%s': expected %q, got %q", test.dat, test.expected, lines)
+		}
+	}
+}
+
+func randomDATLine(r *rand.Rand) string {
+	alphabet := []rune("ab=_ \t,\"'();/\\ółż\r")
+	line := make([]rune, r.Intn(12))
+	for i := range line {
+		line[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(line)
+}
+
+func TestDATRoundTripRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		lines := make([]string, r.Intn(6))
+		for j := range lines {
+			lines[j] = randomDATLine(r)
+		}
+		dat := EncodeDAT(lines)
+		if decoded := DecodeDAT(dat); !slices.Equal(decoded, lines) {
+			t.Errorf("%q: encoded to '%s', decoded to %q", lines, dat, decoded)
+			t.FailNow()
+		}
+	}
+}
+
+func FuzzDAT(f *testing.F) {
+	f.Add("a=1\nb=2")
+	f.Add("GB=if(a,b)\n\"GN=a\"\"b\"")
+	f.Add("")
+	f.Add("\n")
+	f.Add(" , ")
+	f.Fuzz(func(t *testing.T, text string) {
+		lines := strings.Split(text, "\n")
+		dat := EncodeDAT(lines)
+		if decoded := DecodeDAT(dat); !slices.Equal(decoded, lines) {
+			t.Errorf("%q: encoded to '%s', decoded to %q", lines, dat, decoded)
+		}
+		// whatever Corpus saved, encoding decoded lines gives the same lines
+		decoded := DecodeDAT(text)
+		if again := DecodeDAT(EncodeDAT(decoded)); !slices.Equal(again, decoded) {
+			t.Errorf("'%s': decoded to %q, after encoding %q", text, decoded, again)
+		}
+	})
+}
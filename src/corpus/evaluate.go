@@ -0,0 +1,703 @@
+package corpus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type UndefinedVariableError struct {
+	Name string
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable: '%s'", e.Name)
+}
+
+// values known outside of makro: cabinet variables and plates
+type EvalContext struct {
+	// from EVAR: VAR0="wysokosc_nozki=100", keys are lower case
+	Evar map[string]string
+	// plate that makro is placed on (O1/OB1), nil if unknown
+	Obj1 *AD
+	// second plate of connection (O2/OB2), nil for "-1"
+	Obj2 *AD
+	// all plates of cabinet, keys are lower case DNAME: "bok_lewy.grubosc"
+	Plates map[string]*AD
+}
+
+var evarAttrRegex = regexp.MustCompile(`^VAR\d+$`)
+
+func plateByIndex(element *Element, index string) *AD {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(element.Daske.AD) {
+		return nil
+	}
+	return &element.Daske.AD[i]
+}
+
+// obj1Index and obj2Index are indexes of element.Daske.AD as saved in SPOJ (O1, O2) or MAKLINK (OB1, OB2)
+func NewEvalContext(element *Element, obj1Index string, obj2Index string) *EvalContext {
+	context := &EvalContext{
+		Evar:   map[string]string{},
+		Obj1:   plateByIndex(element, obj1Index),
+		Obj2:   plateByIndex(element, obj2Index),
+		Plates: map[string]*AD{},
+	}
+	for _, attr := range element.Evar.Attr {
+		if !evarAttrRegex.MatchString(attr.Name.Local) {
+			continue
+		}
+		name, value, found := strings.Cut(attr.Value, "=")
+		if found {
+			context.Evar[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+	}
+	for i := range element.Daske.AD {
+		ad := &element.Daske.AD[i]
+		context.Plates[strings.ToLower(ad.DName.Value)] = ad
+	}
+	return context
+}
+
+// names used in makros for plate dimensions
+var plateAttrAliases = map[string]string{
+	"gr":        "DEBLJINA",
+	"grubosc":   "DEBLJINA",
+	"debljina":  "DEBLJINA",
+	"wysokosc":  "VISINA",
+	"visina":    "VISINA",
+	"maxx":      "VISINA",
+	"glebokosc": "DUBINA",
+	"dubina":    "DUBINA",
+	"maxy":      "DUBINA",
+	"x":         "DXPOS",
+	"y":         "DYPOS",
+	"z":         "DZPOS",
+}
+
+// property is alias from plateAttrAliases or name of AD attribute: "VISINA", "DKUT"
+func plateProperty(ad *AD, property string) (float64, error) {
+	attrName, found := plateAttrAliases[strings.ToLower(property)]
+	if !found {
+		attrName = property
+	}
+	for _, attr := range ad.Attr {
+		if strings.EqualFold(attr.Name.Local, attrName) {
+			value, err := strconv.ParseFloat(attr.Value, 64)
+			if err != nil {
+				return 0, fmt.Errorf("plate '%s': %s is not a number: '%s'", ad.DName.Value, attrName, attr.Value)
+			}
+			return value, nil
+		}
+	}
+	return 0, fmt.Errorf("plate '%s': unknown property '%s'", ad.DName.Value, property)
+}
+
+/*
+Computes values of makro variables. Variables are not case sensitive, global variable ("_" prefix) takes value from evar if it is defined there.
+Identifier is resolved in order: evar.x, objN.x, <plate DNAME>.x, makro variable, pmaxx/pmaxy, cabinet variable from evar.
+Every variable is computed once.
+*/
+type Evaluator struct {
+	context *EvalContext
+	// lower case name without "_" -> expression
+	variables map[string]string
+	global    map[string]bool
+	values    map[string]float64
+	// detects circular references
+	evaluating map[string]bool
+	// evaluator of EVAR, for evaluator of EVAR it is itself
+	evar *Evaluator
+}
+
+func newEvaluator(context *EvalContext) *Evaluator {
+	return &Evaluator{
+		context:    context,
+		variables:  map[string]string{},
+		global:     map[string]bool{},
+		values:     map[string]float64{},
+		evaluating: map[string]bool{},
+	}
+}
+
+// makro can be nil, then only context is used
+func NewEvaluator(context *EvalContext, makro *M1) *Evaluator {
+	if context == nil {
+		context = &EvalContext{}
+	}
+	evar := newEvaluator(context)
+	for name, value := range context.Evar {
+		evar.variables[normalizeVariableName(name)] = value
+	}
+	evar.evar = evar
+	ev := newEvaluator(context)
+	ev.evar = evar
+	if makro != nil {
+		for _, section := range []*GenericNodeWithDat{&makro.Varijable, makro.Formule} {
+			if section == nil || section.DAT == "" {
+				continue
+			}
+			names, values, _ := loadValuesFromSection(section.DAT)
+			for _, name := range names {
+				key := normalizeVariableName(name)
+				ev.variables[key] = values[name]
+				ev.global[key] = strings.HasPrefix(name, "_")
+			}
+		}
+	}
+	return ev
+}
+
+func normalizeVariableName(name string) string {
+	name, _ = strings.CutPrefix(strings.TrimSpace(name), "_")
+	return strings.ToLower(name)
+}
+
+func (ev *Evaluator) Evaluate(expression string) (float64, error) {
+	tokens, err := TokenizeExpression(expression)
+	if err != nil {
+		return 0, err
+	}
+	if len(tokens) == 0 {
+		return 0, fmt.Errorf("empty expression")
+	}
+	p := &expressionParser{expression: expression, tokens: tokens, ev: ev}
+	value, err := p.parseOr(true)
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(tokens) {
+		return 0, p.errorf("unexpected '%s'", tokens[p.pos].Text)
+	}
+	return value, nil
+}
+
+// value of makro variable or formula, see Evaluator for name resolution
+func (ev *Evaluator) Variable(name string) (float64, error) {
+	key := normalizeVariableName(name)
+	if value, found := ev.values[key]; found {
+		return value, nil
+	}
+	expression, found := ev.variables[key]
+	if !found {
+		return 0, &UndefinedVariableError{name}
+	}
+	if ev.global[key] && ev != ev.evar {
+		if _, found := ev.evar.variables[key]; found {
+			return ev.evar.Variable(key)
+		}
+	}
+	if ev.evaluating[key] {
+		return 0, fmt.Errorf("circular reference: '%s'", name)
+	}
+	ev.evaluating[key] = true
+	defer delete(ev.evaluating, key)
+	value, err := ev.Evaluate(expression)
+	if err != nil {
+		return 0, fmt.Errorf("'%s': %w", name, err)
+	}
+	ev.values[key] = value
+	return value, nil
+}
+
+func (ev *Evaluator) lookup(name string) (float64, error) {
+	lower := strings.ToLower(name)
+	if IsEvarReference(lower) {
+		return ev.evar.Variable(lower[len("evar."):])
+	}
+	if IsObjectReference(lower) {
+		if strings.HasPrefix(lower, "parent.") {
+			return 0, fmt.Errorf("'%s': parent makro is not supported", name)
+		}
+		objName, _, _ := strings.Cut(lower, ".")
+		property := name[len(objName)+1:]
+		var obj *AD
+		switch objName {
+		case "obj1":
+			obj = ev.context.Obj1
+		case "obj2":
+			obj = ev.context.Obj2
+		}
+		if obj == nil {
+			return 0, fmt.Errorf("'%s': plate is not known", name)
+		}
+		return plateProperty(obj, property)
+	}
+	if plateName, property, found := strings.Cut(name, "."); found {
+		if ad, found := ev.context.Plates[strings.ToLower(plateName)]; found {
+			return plateProperty(ad, property)
+		}
+		return 0, &UndefinedVariableError{name}
+	}
+	if _, found := ev.variables[normalizeVariableName(lower)]; found {
+		return ev.Variable(name)
+	}
+	switch lower {
+	case "pmaxx", "pmaxy":
+		if ev.context.Obj1 == nil {
+			return 0, fmt.Errorf("'%s': plate is not known", name)
+		}
+		return plateProperty(ev.context.Obj1, lower[1:])
+	}
+	if ev != ev.evar {
+		if _, found := ev.evar.variables[normalizeVariableName(lower)]; found {
+			return ev.evar.Variable(lower)
+		}
+	}
+	return 0, &UndefinedVariableError{name}
+}
+
+// recursive descent parser that evaluates while parsing. Branch of if that is not taken is only parsed
+type expressionParser struct {
+	expression string
+	tokens     []Token
+	pos        int
+	ev         *Evaluator
+}
+
+func (p *expressionParser) errorf(format string, args ...any) error {
+	pos := len(p.expression)
+	if p.pos < len(p.tokens) {
+		pos = p.tokens[p.pos].Pos
+	}
+	return &ExpressionSyntaxError{p.expression, pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *expressionParser) peek() *Token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *expressionParser) acceptOperator(operators ...string) (string, bool) {
+	token := p.peek()
+	if token == nil || token.Kind != TokenOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if strings.EqualFold(token.Text, operator) {
+			p.pos++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *expressionParser) expect(kind TokenKind) error {
+	token := p.peek()
+	if token == nil {
+		return p.errorf("expected '%s', got end of expression", kind)
+	}
+	if token.Kind != kind {
+		return p.errorf("expected '%s', got '%s'", kind, token.Text)
+	}
+	p.pos++
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *expressionParser) parseOr(evaluate bool) (float64, error) {
+	left, err := p.parseAnd(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, found := p.acceptOperator("or"); !found {
+			return left, nil
+		}
+		right, err := p.parseAnd(evaluate)
+		if err != nil {
+			return 0, err
+		}
+		left = boolValue(left != 0 || right != 0)
+	}
+}
+
+func (p *expressionParser) parseAnd(evaluate bool) (float64, error) {
+	left, err := p.parseNot(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, found := p.acceptOperator("and"); !found {
+			return left, nil
+		}
+		right, err := p.parseNot(evaluate)
+		if err != nil {
+			return 0, err
+		}
+		left = boolValue(left != 0 && right != 0)
+	}
+}
+
+func (p *expressionParser) parseNot(evaluate bool) (float64, error) {
+	if _, found := p.acceptOperator("not"); found {
+		value, err := p.parseNot(evaluate)
+		return boolValue(value == 0), err
+	}
+	return p.parseComparison(evaluate)
+}
+
+func (p *expressionParser) parseComparison(evaluate bool) (float64, error) {
+	left, err := p.parseAdditive(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	operator, found := p.acceptOperator("<=", ">=", "<>", "=", "<", ">")
+	if !found {
+		return left, nil
+	}
+	right, err := p.parseAdditive(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	switch operator {
+	case "<=":
+		return boolValue(left <= right), nil
+	case ">=":
+		return boolValue(left >= right), nil
+	case "<>":
+		return boolValue(left != right), nil
+	case "=":
+		return boolValue(left == right), nil
+	case "<":
+		return boolValue(left < right), nil
+	}
+	return boolValue(left > right), nil
+}
+
+func (p *expressionParser) parseAdditive(evaluate bool) (float64, error) {
+	left, err := p.parseMultiplicative(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator, found := p.acceptOperator("+", "-")
+		if !found {
+			return left, nil
+		}
+		right, err := p.parseMultiplicative(evaluate)
+		if err != nil {
+			return 0, err
+		}
+		if operator == "+" {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *expressionParser) parseMultiplicative(evaluate bool) (float64, error) {
+	left, err := p.parseUnary(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		operator, found := p.acceptOperator("*", "/")
+		if !found {
+			return left, nil
+		}
+		right, err := p.parseUnary(evaluate)
+		if err != nil {
+			return 0, err
+		}
+		if operator == "*" {
+			left *= right
+		} else if !evaluate {
+			left = 0
+		} else if right == 0 {
+			return 0, fmt.Errorf("division by zero in '%s'", p.expression)
+		} else {
+			left /= right
+		}
+	}
+}
+
+func (p *expressionParser) parseUnary(evaluate bool) (float64, error) {
+	if operator, found := p.acceptOperator("-", "+"); found {
+		value, err := p.parseUnary(evaluate)
+		if operator == "-" {
+			value = -value
+		}
+		return value, err
+	}
+	return p.parsePower(evaluate)
+}
+
+func (p *expressionParser) parsePower(evaluate bool) (float64, error) {
+	base, err := p.parsePrimary(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	if _, found := p.acceptOperator("^"); !found {
+		return base, nil
+	}
+	exponent, err := p.parseUnary(evaluate)
+	if err != nil {
+		return 0, err
+	}
+	return math.Pow(base, exponent), nil
+}
+
+func (p *expressionParser) parsePrimary(evaluate bool) (float64, error) {
+	token := p.peek()
+	if token == nil {
+		return 0, p.errorf("unexpected end of expression")
+	}
+	switch token.Kind {
+	case TokenNumber:
+		p.pos++
+		return strconv.ParseFloat(token.Text, 64)
+	case TokenOpenParen:
+		p.pos++
+		value, err := p.parseOr(evaluate)
+		if err != nil {
+			return 0, err
+		}
+		return value, p.expect(TokenCloseParen)
+	case TokenIdentifier:
+		p.pos++
+		if next := p.peek(); next != nil && next.Kind == TokenOpenParen {
+			return p.parseFunction(token.Text, evaluate)
+		}
+		if !evaluate {
+			return 0, nil
+		}
+		return p.ev.lookup(token.Text)
+	case TokenString:
+		return 0, p.errorf("text can not be used in expression: '%s'", token.Text)
+	}
+	return 0, p.errorf("unexpected '%s'", token.Text)
+}
+
+// functions with fixed number of arguments, min and max take any number
+var expressionFunctions = map[string]struct {
+	arguments int
+	f         func(args []float64) float64
+}{
+	"abs":   {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"trunc": {1, func(args []float64) float64 { return math.Trunc(args[0]) }},
+	"sqrt":  {1, func(args []float64) float64 { return math.Sqrt(args[0]) }},
+}
+
+func (p *expressionParser) parseFunction(name string, evaluate bool) (float64, error) {
+	p.pos++ // (
+	lowerName := strings.ToLower(name)
+	args := []float64{}
+	for i := 0; ; i++ {
+		evaluateArg := evaluate
+		// if(condition;then;else), only taken branch is evaluated
+		if lowerName == "if" && i > 0 {
+			evaluateArg = evaluate && (args[0] != 0) == (i == 1)
+		}
+		value, err := p.parseOr(evaluateArg)
+		if err != nil {
+			return 0, err
+		}
+		args = append(args, value)
+		if token := p.peek(); token != nil && token.Kind == TokenSemicolon {
+			p.pos++
+			continue
+		}
+		if err := p.expect(TokenCloseParen); err != nil {
+			return 0, err
+		}
+		break
+	}
+	switch lowerName {
+	case "if":
+		if len(args) != 3 {
+			return 0, fmt.Errorf("if needs 3 arguments, got %d: '%s'", len(args), p.expression)
+		}
+		if args[0] != 0 {
+			return args[1], nil
+		}
+		return args[2], nil
+	case "round":
+		// round(x) or round(x;digits)
+		if len(args) > 2 {
+			return 0, fmt.Errorf("round needs 1 or 2 arguments, got %d: '%s'", len(args), p.expression)
+		}
+		scale := 1.0
+		if len(args) == 2 {
+			scale = math.Pow(10, args[1])
+		}
+		return math.Round(args[0]*scale) / scale, nil
+	case "min", "max":
+		value := args[0]
+		for _, arg := range args[1:] {
+			if lowerName == "min" {
+				value = min(value, arg)
+			} else {
+				value = max(value, arg)
+			}
+		}
+		return value, nil
+	}
+	function, found := expressionFunctions[lowerName]
+	if !found {
+		return 0, fmt.Errorf("unknown function '%s' in '%s'", name, p.expression)
+	}
+	if len(args) != function.arguments {
+		return 0, fmt.Errorf("%s needs %d arguments, got %d: '%s'", name, function.arguments, len(args), p.expression)
+	}
+	return function.f(args), nil
+}
+
+// computed value of single line in [VARIJABLE] or [FORMULE]
+type EvaluatedValue struct {
+	Section    string `json:"section"`
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	// nil if value is text or could not be computed
+	Value *float64 `json:"value,omitempty"`
+	// value that is not an expression: "rowek na dno"
+	Text  string `json:"text,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// "18", "'rowek na dno'" or "error: ..."
+func (v EvaluatedValue) Result() string {
+	switch {
+	case v.Error != "":
+		return "error: " + v.Error
+	case v.Value != nil:
+		return FormatNumber(*v.Value)
+	}
+	return "'" + v.Text + "'"
+}
+
+// every line of [VARIJABLE] and [FORMULE] in order
+func EvaluateMakro(context *EvalContext, makro *M1) []EvaluatedValue {
+	ev := NewEvaluator(context, makro)
+	values := []EvaluatedValue{}
+	sections := []struct {
+		name    string
+		section *GenericNodeWithDat
+	}{{"VARIJABLE", &makro.Varijable}, {"FORMULE", makro.Formule}}
+	for _, s := range sections {
+		if s.section == nil || s.section.DAT == "" {
+			continue
+		}
+		names, expressions, _ := loadValuesFromSection(s.section.DAT)
+		for _, name := range names {
+			value := EvaluatedValue{Section: s.name, Name: name, Expression: expressions[name]}
+			switch NewExpression(value.Expression).Kind {
+			case ExpressionString, ExpressionEmpty:
+				value.Text = value.Expression
+			default:
+				if result, err := ev.Variable(name); err != nil {
+					value.Error = err.Error()
+				} else {
+					value.Value = &result
+				}
+			}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// all values of single makro placed on plate
+type MakroEvaluation struct {
+	MakroName string           `json:"makro"`
+	File      string           `json:"file"`
+	Element   string           `json:"element"`
+	Plate     string           `json:"plate"`
+	Values    []EvaluatedValue `json:"values"`
+}
+
+/*
+Evaluates every makro placed on plate: M1 from SPOJ (version 16) and MM1 from MAKLINK (version 17).
+Submakros are not evaluated, their variables are set by [MAKRO] section of parent.
+*/
+func EvaluateCorpusFile(inputFile string) ([]MakroEvaluation, error) {
+	projectFile, elementFile, err := NewCorpusFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+	if projectFile != nil {
+		elementFile = &projectFile.ElementFile
+	}
+	evaluations := []MakroEvaluation{}
+	var errs []error
+	evaluate := func(element *Element, obj1Index string, obj2Index string, makro *M1) {
+		context := NewEvalContext(element, obj1Index, obj2Index)
+		evaluation := MakroEvaluation{MakroName: makro.MakroName, File: inputFile, Element: element.EName.Value, Values: EvaluateMakro(context, makro)}
+		if context.Obj1 != nil {
+			evaluation.Plate = context.Obj1.DName.Value
+		}
+		evaluations = append(evaluations, evaluation)
+	}
+	elementFile.VisitElementsAndSubelements(func(element *Element) {
+		for i := range element.Elinks.Spoj {
+			spoj := &element.Elinks.Spoj[i]
+			for _, makro := range spoj.Makros() {
+				if !makro.isEmpty() {
+					evaluate(element, spoj.O1.Value, spoj.O2.Value, makro)
+				}
+			}
+		}
+		for i := range element.Elinks.MakLink {
+			makLink := &element.Elinks.MakLink[i]
+			for _, mm := range makLink.Makros() {
+				makro, err := NewM1(mm)
+				if err != nil {
+					err = fmt.Errorf("cabinet '%s': can not decode makro '%s': %w", element.EName.Value, mm.MakroName, err)
+					errs = append(errs, err)
+					continue
+				}
+				if !makro.isEmpty() {
+					evaluate(element, makLink.OB1.Value, makLink.OB2.Value, makro)
+				}
+			}
+		}
+	})
+	return evaluations, errors.Join(errs...)
+}
+
+// human readable: makro header and one line per variable: "name=expression = value"
+func WriteEvaluationText(w io.Writer, evaluations []MakroEvaluation) error {
+	previousFile := ""
+	for _, evaluation := range evaluations {
+		if evaluation.File != previousFile {
+			fmt.Fprintf(w, "File: '%s'\n", evaluation.File)
+			previousFile = evaluation.File
+		}
+		fmt.Fprintf(w, "  Cabinet '%s', plate '%s', makro '%s'\n", evaluation.Element, evaluation.Plate, evaluation.MakroName)
+		section := ""
+		for _, value := range evaluation.Values {
+			if value.Section != section {
+				fmt.Fprintf(w, "    [%s]\n", value.Section)
+				section = value.Section
+			}
+			fmt.Fprintf(w, "      %s=%s = %s\n", value.Name, value.Expression, value.Result())
+		}
+	}
+	_, err := fmt.Fprintf(w, "Makros: %d\n", len(evaluations))
+	return err
+}
+
+func WriteEvaluationJSON(w io.Writer, evaluations []MakroEvaluation) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(evaluations)
+}
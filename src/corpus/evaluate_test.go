@@ -0,0 +1,128 @@
+package corpus
+
+import (
+	"encoding/xml"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEvalElement() *Element {
+	attr := func(name string, value string) xml.Attr {
+		return xml.Attr{Name: xml.Name{Local: name}, Value: value}
+	}
+	element := &Element{}
+	element.EName.Value = "szafka"
+	element.Evar.Attr = []xml.Attr{attr("VAR0", "grubosc=18"), attr("VAR1", "wysokosc_nozki=100"), attr("VAR2", "podwojna=grubosc*2")}
+	bok := AD{}
+	bok.DName.Value = "Bok_Lewy"
+	bok.Attr = []xml.Attr{attr("VISINA", "700"), attr("DUBINA", "600"), attr("DEBLJINA", "18"), attr("DXPOS", "0")}
+	wieniec := AD{}
+	wieniec.DName.Value = "Wieniec_Gorny"
+	wieniec.Attr = []xml.Attr{attr("VISINA", "564"), attr("DUBINA", "592"), attr("DEBLJINA", "16"), attr("DXPOS", "18")}
+	element.Daske.AD = []AD{bok, wieniec}
+	return element
+}
+
+func TestEvaluatorEvaluate(t *testing.T) {
+	context := NewEvalContext(testEvalElement(), "0", "1")
+	makro := &M1{
+		Varijable: GenericNodeWithDat{DAT: "a=2,_grubosc=32,b=a*3+1,lokalna=grubosc,\"nazwa=rowek na dno\""},
+		Formule:   &GenericNodeWithDat{DAT: "pol=obj1.gr/2"},
+	}
+	ev := NewEvaluator(context, makro)
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"-2^2", -4},
+		{"7/2", 3.5},
+		{"b", 7},
+		{"B", 7},
+		{"if(a=2;10;20)", 10},
+		{"if(a<>2;10;20)", 20},
+		{"if(a>1 and b<7;1;0)", 0},
+		{"if((a>1)or(b<7);1;0)", 1},
+		{"not a=2", 0},
+		{"if(a=2;1;undefined_variable)", 1},
+		{"round(2.5)", 3},
+		{"round(1.2345;2)", 1.23},
+		{"min(3;1;2)+max(3;1;2)+abs(-1)", 5},
+		{"evar.grubosc", 18},
+		{"evar.podwojna", 36},
+		{"grubosc", 18},
+		{"_grubosc", 18},
+		{"lokalna", 18},
+		{"wysokosc_nozki", 100},
+		{"obj1.wysokosc+obj1.glebokosc", 1300},
+		{"obj2.gr", 16},
+		{"obj1.DUBINA", 600},
+		{"pmaxx", 700},
+		{"pmaxy", 600},
+		{"Wieniec_Gorny.X-Bok_Lewy.GRUBOSC", 0},
+		{"pol", 9},
+	}
+	for _, test := range tests {
+		value, err := ev.Evaluate(test.expression)
+		if err != nil {
+			t.Errorf("'%s': %s", test.expression, err)
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("'%s': expected %v, got %v", test.expression, test.expected, value)
+		}
+	}
+}
+
+func TestEvaluatorErrors(t *testing.T) {
+	context := NewEvalContext(testEvalElement(), "0", "-1")
+	makro := &M1{Varijable: GenericNodeWithDat{DAT: "a=b+1,b=a+1,\"nazwa=rowek na dno\""}}
+	ev := NewEvaluator(context, makro)
+	_, err := ev.Evaluate("missing+1")
+	var undefined *UndefinedVariableError
+	if !errors.As(err, &undefined) || undefined.Name != "missing" {
+		t.Errorf("expected undefined variable: %v", err)
+	}
+	for _, expression := range []string{"a", "nazwa", "obj2.gr", "1/0", "if(1;2)", "(1+2", "1 2", "unknown(1)", "parent.obj1.gr"} {
+		if value, err := ev.Evaluate(expression); err == nil {
+			t.Errorf("'%s' should fail, got %v", expression, value)
+		}
+	}
+}
+
+func TestEvaluateMakro(t *testing.T) {
+	makro := &M1{Varijable: GenericNodeWithDat{DAT: "a=2,\"nazwa=rowek na dno\",zla=a+missing"}, Formule: &GenericNodeWithDat{DAT: "b=a*obj1.gr"}}
+	values := EvaluateMakro(NewEvalContext(testEvalElement(), "0", "-1"), makro)
+	results := []string{}
+	for _, value := range values {
+		results = append(results, value.Section+":"+value.Name+"="+value.Result())
+	}
+	expected := "VARIJABLE:a=2 VARIJABLE:nazwa='rowek na dno' VARIJABLE:zla=error: 'zla': undefined variable: 'missing' FORMULE:b=36"
+	if strings.Join(results, " ") != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, strings.Join(results, " "))
+	}
+}
+
+func TestEvaluateCorpusFile(t *testing.T) {
+	for _, inputFile := range []string{filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"), filepath.Join(pathToE3DTestDataVertsion17, "simple.E3D")} {
+		evaluations, err := EvaluateCorpusFile(inputFile)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(evaluations) != 2 {
+			t.Errorf("%s: wrong number of makros: %d", inputFile, len(evaluations))
+			continue
+		}
+		first := evaluations[0]
+		if first.MakroName != "gorny" || first.Plate != "Wieniec_Gorny" || len(first.Values) != 3 {
+			t.Errorf("%s: wrong evaluation: %+v", inputFile, first)
+		}
+		if first.Values[1].Value == nil || *first.Values[1].Value != 2 {
+			t.Errorf("%s: wrong value: %+v", inputFile, first.Values[1])
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package corpus
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+type TokenKind int
+
+const (
+	TokenNumber TokenKind = iota
+	// quoted with ' or ", Text is without quotes
+	TokenString
+	// variable or property, can contain dots: "grubosc", "evar.grubosc", "parent.obj1.param500SK"
+	TokenIdentifier
+	// + - * / ^ = <> < > <= >= and, or, not
+	TokenOperator
+	TokenOpenParen
+	TokenCloseParen
+	// separates function arguments: if(a;b;c)
+	TokenSemicolon
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenNumber:
+		return "number"
+	case TokenString:
+		return "string"
+	case TokenIdentifier:
+		return "identifier"
+	case TokenOperator:
+		return "operator"
+	case TokenOpenParen:
+		return "("
+	case TokenCloseParen:
+		return ")"
+	case TokenSemicolon:
+		return ";"
+	}
+	return fmt.Sprintf("TokenKind(%d)", int(k))
+}
+
+type Token struct {
+	Kind TokenKind
+	Text string
+	// byte offset in expression
+	Pos int
+}
+
+type ExpressionSyntaxError struct {
+	Expression string
+	Pos        int
+	Message    string
+}
+
+func (e *ExpressionSyntaxError) Error() string {
+	return fmt.Sprintf("invalid expression '%s' at %d: %s", e.Expression, e.Pos, e.Message)
+}
+
+func isIdentifierRune(r rune) bool {
+	return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+var wordOperators = []string{"and", "or", "not"}
+
+// splits Corpus expression into tokens, whitespace is skipped
+func TokenizeExpression(expression string) ([]Token, error) {
+	tokens := []Token{}
+	runes := []rune(expression)
+	// byte offset of every rune
+	offsets := make([]int, 0, len(runes)+1)
+	for offset := range expression {
+		offsets = append(offsets, offset)
+	}
+	offsets = append(offsets, len(expression))
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			// "2abc" is not a number
+			if i < len(runes) && isIdentifierRune(runes[i]) {
+				for i < len(runes) && isIdentifierRune(runes[i]) {
+					i++
+				}
+				return tokens, &ExpressionSyntaxError{expression, offsets[start], fmt.Sprintf("invalid number '%s'", string(runes[start:i]))}
+			}
+			text := string(runes[start:i])
+			if strings.Count(text, ".") > 1 {
+				return tokens, &ExpressionSyntaxError{expression, offsets[start], fmt.Sprintf("invalid number '%s'", text)}
+			}
+			tokens = append(tokens, Token{TokenNumber, text, offsets[start]})
+			continue
+		case isIdentifierRune(r):
+			for i < len(runes) && isIdentifierRune(runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			kind := TokenIdentifier
+			for _, operator := range wordOperators {
+				if strings.EqualFold(text, operator) {
+					kind = TokenOperator
+				}
+			}
+			tokens = append(tokens, Token{kind, text, offsets[start]})
+			continue
+		case r == '\'' || r == '"':
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return tokens, &ExpressionSyntaxError{expression, offsets[start], "string is not closed"}
+			}
+			i++
+			tokens = append(tokens, Token{TokenString, string(runes[start+1 : i-1]), offsets[start]})
+			continue
+		case r == '(':
+			tokens = append(tokens, Token{TokenOpenParen, "(", offsets[start]})
+		case r == ')':
+			tokens = append(tokens, Token{TokenCloseParen, ")", offsets[start]})
+		case r == ';':
+			tokens = append(tokens, Token{TokenSemicolon, ";", offsets[start]})
+		case r == '<' && i+1 < len(runes) && (runes[i+1] == '=' || runes[i+1] == '>'),
+			r == '>' && i+1 < len(runes) && runes[i+1] == '=':
+			i++
+			tokens = append(tokens, Token{TokenOperator, string(runes[start : i+1]), offsets[start]})
+		case strings.ContainsRune("+-*/^=<>", r):
+			tokens = append(tokens, Token{TokenOperator, string(r), offsets[start]})
+		default:
+			return tokens, &ExpressionSyntaxError{expression, offsets[start], fmt.Sprintf("unexpected character '%c'", r)}
+		}
+		i++
+	}
+	return tokens, nil
+}
+
+type ExpressionKind int
+
+const (
+	ExpressionEmpty ExpressionKind = iota
+	// 18, -5, 2.5
+	ExpressionNumber
+	// quoted string or free text that is not an expression: "rowek na dno"
+	ExpressionString
+	// evar.grubosc
+	ExpressionEvarReference
+	// obj1.param500SK, obj2.autost, parent.parent.obj1.gr
+	ExpressionObjectReference
+	// other variable: grubosc, pmaxx
+	ExpressionVariableReference
+	// operators and function calls: obj1.gr/2, round(x)
+	ExpressionArithmetic
+	// contains if(a;b;c)
+	ExpressionConditional
+)
+
+func (k ExpressionKind) String() string {
+	switch k {
+	case ExpressionEmpty:
+		return "empty"
+	case ExpressionNumber:
+		return "number"
+	case ExpressionString:
+		return "string"
+	case ExpressionEvarReference:
+		return "evar reference"
+	case ExpressionObjectReference:
+		return "object reference"
+	case ExpressionVariableReference:
+		return "variable reference"
+	case ExpressionArithmetic:
+		return "arithmetic"
+	case ExpressionConditional:
+		return "conditional"
+	}
+	return fmt.Sprintf("ExpressionKind(%d)", int(k))
+}
+
+// objN.x, optionally prefixed by parent.
+var objectReferenceRegex = regexp.MustCompile(`(?i)^(parent\.)*obj\d+\.`)
+
+func IsEvarReference(name string) bool {
+	return len(name) > len("evar.") && strings.EqualFold(name[:len("evar.")], "evar.")
+}
+
+func IsObjectReference(name string) bool {
+	return objectReferenceRegex.MatchString(name)
+}
+
+// value of variable, formula or any other CMK key, see NewExpression
+type Expression struct {
+	Text   string
+	Tokens []Token
+	Kind   ExpressionKind
+	// set when Text could not be tokenized, Kind is ExpressionString
+	Err error
+}
+
+func isOperand(token Token) bool {
+	return token.Kind == TokenNumber || token.Kind == TokenString || token.Kind == TokenIdentifier || token.Kind == TokenCloseParen
+}
+
+func NewExpression(text string) Expression {
+	tokens, err := TokenizeExpression(text)
+	e := Expression{Text: text, Tokens: tokens, Err: err}
+	e.Kind = e.classify()
+	return e
+}
+
+func (e Expression) classify() ExpressionKind {
+	tokens := e.Tokens
+	switch {
+	case e.Err != nil:
+		return ExpressionString
+	case len(tokens) == 0:
+		return ExpressionEmpty
+	case len(tokens) == 2 && tokens[0].Text == "-" && tokens[1].Kind == TokenNumber:
+		return ExpressionNumber
+	case len(tokens) == 1:
+		switch {
+		case tokens[0].Kind == TokenNumber:
+			return ExpressionNumber
+		case tokens[0].Kind == TokenString:
+			return ExpressionString
+		case tokens[0].Kind != TokenIdentifier:
+			return ExpressionString
+		case IsEvarReference(tokens[0].Text):
+			return ExpressionEvarReference
+		case IsObjectReference(tokens[0].Text):
+			return ExpressionObjectReference
+		}
+		return ExpressionVariableReference
+	}
+	conditional := false
+	for i, token := range tokens {
+		// two values next to each other, this is text: "rowek na dno"
+		if i > 0 && isOperand(tokens[i-1]) && isOperand(token) && token.Kind != TokenCloseParen {
+			return ExpressionString
+		}
+		if token.Kind == TokenIdentifier && strings.EqualFold(token.Text, "if") && i+1 < len(tokens) && tokens[i+1].Kind == TokenOpenParen {
+			conditional = true
+		}
+	}
+	if conditional {
+		return ExpressionConditional
+	}
+	return ExpressionArithmetic
+}
+
+// names of variables used in expression, without function names. Order of first use, text has no references
+func (e Expression) References() []string {
+	references := []string{}
+	if e.Kind == ExpressionString {
+		return references
+	}
+	seen := map[string]bool{}
+	for i, token := range e.Tokens {
+		isFunction := i+1 < len(e.Tokens) && e.Tokens[i+1].Kind == TokenOpenParen
+		if token.Kind != TokenIdentifier || isFunction || seen[token.Text] {
+			continue
+		}
+		seen[token.Text] = true
+		references = append(references, token.Text)
+	}
+	return references
+}
+
+func ClassifyExpression(text string) ExpressionKind {
+	return NewExpression(text).Kind
+}
@@ -0,0 +1,87 @@
+package corpus
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestClassifyExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   ExpressionKind
+	}{
+		{"", ExpressionEmpty},
+		{"  ", ExpressionEmpty},
+		{"18", ExpressionNumber},
+		{"-5", ExpressionNumber},
+		{"2.5", ExpressionNumber},
+		{" 32 ", ExpressionNumber},
+		{"'abc'", ExpressionString},
+		{"rowek na dno", ExpressionString},
+		{"Frezowanie dna antaro", ExpressionString},
+		{"kolki wiercone w obiekcie przylegajacym", ExpressionString},
+		{"2abc", ExpressionString},
+		{"a#b", ExpressionString},
+		{"evar.grubosc", ExpressionEvarReference},
+		{"EVAR.Grubosc", ExpressionEvarReference},
+		{"obj1.param500SK", ExpressionObjectReference},
+		{"obj2.autost", ExpressionObjectReference},
+		{"parent.parent.obj1.param8010GN", ExpressionObjectReference},
+		{"grubosc", ExpressionVariableReference},
+		{"pmaxx", ExpressionVariableReference},
+		{"evar", ExpressionVariableReference},
+		{"obj1.gr/2", ExpressionArithmetic},
+		{"(11/2)+wpust_boki", ExpressionArithmetic},
+		{"obj1.wysokosc-(42/2)-wpust_wieniec", ExpressionArithmetic},
+		{"round(x)", ExpressionArithmetic},
+		{"-x", ExpressionArithmetic},
+		{"if(obj1.param9876FREZ_DNO=0;0;1)", ExpressionConditional},
+		{"if(sz>50;18;32)", ExpressionConditional},
+		{"2+if(a<>b;1;0)", ExpressionConditional},
+		{"if((Hafele_Zawieszki_Wybor=0)and((Hafele_Zawieszki_plecy=0)or(Hafele_Zawieszki_plecy=1));1;0)", ExpressionConditional},
+	}
+	for _, test := range tests {
+		if got := ClassifyExpression(test.expression); got != test.expected {
+			t.Errorf("'%s': expected %s, got %s", test.expression, test.expected, got)
+		}
+	}
+}
+
+func TestTokenizeExpression(t *testing.T) {
+	tokens, err := TokenizeExpression("if(a>=2;'x y';b<>c)")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	texts := []string{}
+	kinds := []TokenKind{}
+	for _, token := range tokens {
+		texts = append(texts, token.Text)
+		kinds = append(kinds, token.Kind)
+	}
+	expectedTexts := []string{"if", "(", "a", ">=", "2", ";", "x y", ";", "b", "<>", "c", ")"}
+	expectedKinds := []TokenKind{TokenIdentifier, TokenOpenParen, TokenIdentifier, TokenOperator, TokenNumber, TokenSemicolon, TokenString, TokenSemicolon, TokenIdentifier, TokenOperator, TokenIdentifier, TokenCloseParen}
+	if !slices.Equal(texts, expectedTexts) || !slices.Equal(kinds, expectedKinds) {
+		t.Errorf("wrong tokens: %q %s", texts, kinds)
+	}
+	if tokens[6].Pos != 8 {
+		t.Errorf("wrong position of string: %d", tokens[6].Pos)
+	}
+
+	_, err = TokenizeExpression("grubość+'abc")
+	var syntaxError *ExpressionSyntaxError
+	if !errors.As(err, &syntaxError) || syntaxError.Pos != len("grubość+") {
+		t.Errorf("expected syntax error at %d: %v", len("grubość+"), err)
+	}
+}
+
+func TestExpressionReferences(t *testing.T) {
+	references := NewExpression("if(a and evar.b=round(obj1.gr);a;2)").References()
+	if !slices.Equal(references, []string{"a", "evar.b", "obj1.gr"}) {
+		t.Errorf("wrong references: %q", references)
+	}
+	if references := NewExpression("rowek na dno").References(); len(references) != 0 {
+		t.Errorf("text should not have references: %q", references)
+	}
+}
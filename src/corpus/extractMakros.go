@@ -0,0 +1,161 @@
+package corpus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// one definition of makro found in corpus files
+type MakroVariant struct {
+	Makro  *M1
+	Usages []MakroUsage
+}
+
+type ExtractedMakro struct {
+	Name string
+	// different definitions of the same makro, the most used first
+	Variants []MakroVariant
+}
+
+// the same makro name has different content in different places
+func (em ExtractedMakro) HasConflict() bool {
+	return len(em.Variants) > 1
+}
+
+/*
+Content of makro used to compare makros found in different places.
+Values in [VARIJABLE] are set for every plate, so only variable names are compared. All other sections are compared as they are.
+*/
+func makroDefinition(m *M1) string {
+	definition := *m
+	definition.MakroName = ""
+	if definition.Varijable.DAT != "" {
+		names, _, _ := loadValuesFromSection(definition.Varijable.DAT)
+		definition.Varijable = GenericNodeWithDat{DAT: strings.ToLower(strings.Join(names, CMKLineSeparator))}
+	}
+	var out bytes.Buffer
+	definition.Save(&out)
+	return out.String()
+}
+
+// collects every makro and submakro used in inputFiles. Files that can not be read are skipped, errors are returned
+func ExtractMakros(inputFiles []string) ([]ExtractedMakro, error) {
+	makros := map[string]*ExtractedMakro{}
+	definitions := map[string][]string{}
+	var errs []error
+	for _, inputFile := range inputFiles {
+		err := VisitMakrosInCorpusFile(inputFile, func(name string, makro *M1, usage MakroUsage) {
+			// makro that is not saved in any file
+			if name == "" {
+				return
+			}
+			extracted, found := makros[name]
+			if !found {
+				extracted = &ExtractedMakro{Name: name}
+				makros[name] = extracted
+			}
+			definition := makroDefinition(makro)
+			i := slices.Index(definitions[name], definition)
+			if i == -1 {
+				definitions[name] = append(definitions[name], definition)
+				extracted.Variants = append(extracted.Variants, MakroVariant{Makro: makro.Copy()})
+				i = len(extracted.Variants) - 1
+			}
+			extracted.Variants[i].Usages = append(extracted.Variants[i].Usages, usage)
+		})
+		if err != nil {
+			err = fmt.Errorf("%s: %w", inputFile, err)
+			errs = append(errs, err)
+		}
+	}
+
+	out := []ExtractedMakro{}
+	for _, extracted := range makros {
+		sort.SliceStable(extracted.Variants, func(i, j int) bool {
+			return len(extracted.Variants[i].Usages) > len(extracted.Variants[j].Usages)
+		})
+		out = append(out, *extracted)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, errors.Join(errs...)
+}
+
+// makro "folder/makro" is saved as makroDir/folder/makro.CMK
+func MakroFilePath(makroDir string, name string) (string, error) {
+	relative := filepath.FromSlash(strings.ReplaceAll(name, `\`, "/")) + ".CMK"
+	if !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("makro name can not be used as file name: '%s'", name)
+	}
+	return filepath.Join(makroDir, relative), nil
+}
+
+/*
+Saves the most used variant of every makro as CMK file in makroDir, encoded with encoding. Existing files are not overwritten unless force is set.
+Returns saved files.
+*/
+func WriteExtractedMakros(makroDir string, makros []ExtractedMakro, force bool, encoding TextEncoding) ([]string, error) {
+	saved := []string{}
+	var errs []error
+	for _, extracted := range makros {
+		err := func() error {
+			makroFile, err := MakroFilePath(makroDir, extracted.Name)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(makroFile); err == nil && !force {
+				return fmt.Errorf("makro file already exists: '%s'", makroFile)
+			}
+			if err := os.MkdirAll(filepath.Dir(makroFile), os.ModePerm); err != nil {
+				return err
+			}
+			variant := extracted.Variants[0]
+			comment := fmt.Sprintf("CorpusMakroReplacer: extracted from %s", variant.Usages[0].File)
+			if err := variant.Makro.SaveToFileWithOptions(makroFile, CMKSaveOptions{Comment: comment, Encoding: encoding}); err != nil {
+				return err
+			}
+			saved = append(saved, makroFile)
+			return nil
+		}()
+		if err != nil {
+			err = fmt.Errorf("makro '%s': %w", extracted.Name, err)
+			errs = append(errs, err)
+		}
+	}
+	return saved, errors.Join(errs...)
+}
+
+func writeMakroUsage(w io.Writer, indent string, usage MakroUsage) {
+	fmt.Fprintf(w, "%s'%s': cabinet '%s', plate '%s'", indent, usage.File, usage.Element, usage.Plate)
+	if usage.CalledBy != "" {
+		fmt.Fprintf(w, ", called by '%s'", usage.CalledBy)
+	}
+	fmt.Fprintln(w)
+}
+
+// human readable report, lists all places of conflicting variants
+func WriteExtractReportText(w io.Writer, makros []ExtractedMakro) error {
+	conflicts := 0
+	for _, extracted := range makros {
+		if !extracted.HasConflict() {
+			fmt.Fprintf(w, "Makro: '%s': used %d times\n", extracted.Name, len(extracted.Variants[0].Usages))
+			continue
+		}
+		conflicts++
+		fmt.Fprintf(w, "Makro: '%s': CONFLICT, %d different versions (first one is saved)\n", extracted.Name, len(extracted.Variants))
+		for i, variant := range extracted.Variants {
+			fmt.Fprintf(w, "  Version %d: used %d times\n", i+1, len(variant.Usages))
+			for _, usage := range variant.Usages {
+				writeMakroUsage(w, "    ", usage)
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "Makros: %d, conflicts: %d\n", len(makros), conflicts)
+	return err
+}
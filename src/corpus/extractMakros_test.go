@@ -0,0 +1,70 @@
+package corpus
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractMakros(t *testing.T) {
+	makros, err := ExtractMakros([]string{
+		filepath.Join(pathToE3DTestDataVertsion16, "simple_macro_in_macro.E3D"),
+		filepath.Join(pathToE3DTestDataVertsion17, "simple_macro_in_macro.E3D"),
+		filepath.Join(pathToE3DTestDataVertsion16, "nested_variables.E3D"),
+	})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	byName := map[string]ExtractedMakro{}
+	for _, extracted := range makros {
+		byName[extracted.Name] = extracted
+	}
+	// version 16 and 17 have the same makro, text in version 17 is encoded in Windows-1250
+	blenda, found := byName["Blenda"]
+	if !found || blenda.HasConflict() || len(blenda.Variants[0].Usages) != 2 {
+		t.Errorf("Blenda is bad: %v", blenda)
+	} else if !strings.Contains(blenda.Variants[0].Makro.Potrosni[0].DAT, "Kołek_3D") {
+		t.Errorf("Blenda is decoded badly: %s", blenda.Variants[0].Makro.Potrosni[0].DAT[:40])
+	}
+	dodatkowa, found := byName["Blenda_dodatkowa"]
+	if !found || dodatkowa.Variants[0].Usages[0].CalledBy != "Blenda" {
+		t.Errorf("submakro of submakro is bad: %v", dodatkowa)
+	}
+	custom, found := byName["custom"]
+	if !found || !custom.HasConflict() || len(custom.Variants[0].Usages) != 2 {
+		t.Errorf("custom should have conflict, the most used version first: %v", custom)
+	}
+	usage := custom.Variants[0].Usages[0]
+	if usage.Element != "simple_original_custom" || usage.Plate != "Wieniec_Gorny" || usage.CalledBy != "" {
+		t.Errorf("usage is bad: %v", usage)
+	}
+
+	dir := t.TempDir()
+	saved, err := WriteExtractedMakros(dir, makros, false, EncodingAuto)
+	if err != nil || len(saved) != len(makros) {
+		t.Error(saved, err)
+		t.FailNow()
+	}
+	reloaded, err := partialNewMakroFromCMKFile("Blenda", filepath.Join(dir, "Blenda.CMK"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if makroDefinition(reloaded) != makroDefinition(blenda.Variants[0].Makro) {
+		t.Error("saved makro is different from extracted")
+	}
+	if _, err := WriteExtractedMakros(dir, makros, false, EncodingAuto); err == nil {
+		t.Error("existing files should not be overwritten without force")
+	}
+}
+
+func TestMakroFilePath(t *testing.T) {
+	path, err := MakroFilePath("Makro", "folder with space/simple")
+	if err != nil || path != filepath.Join("Makro", "folder with space", "simple.CMK") {
+		t.Errorf("wrong path: %s %s", path, err)
+	}
+	if _, err := MakroFilePath("Makro", "../simple"); err == nil {
+		t.Error("makro outside of makro dir should fail")
+	}
+}
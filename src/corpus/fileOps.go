@@ -70,3 +70,37 @@ func removeRelativePrefix(path string) string {
 	}
 	return path
 }
+
+// writes to temporary file in the same directory and renames it over path,
+// so path contains either old or new content, never partially written file
+func WriteFileAtomic(path string, data []byte) error {
+	perm := os.FileMode(0644)
+	if stat, err := os.Stat(path); err == nil {
+		perm = stat.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("can not create temporary file: %w", err)
+	}
+	// no-op after successful rename
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can not write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can not write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can not write temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can not replace '%s': %w", path, err)
+	}
+	return nil
+}
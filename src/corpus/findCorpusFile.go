@@ -25,3 +25,15 @@ func FindCorpusFiles(inputFolder string) []string {
 	})
 	return foundCorpusFiles
 }
+
+// .CMK files in folder and subfolders
+func FindMakroFiles(inputFolder string) []string {
+	foundMakroFiles := []string{}
+	filepath.Walk(inputFolder, func(path string, info fs.FileInfo, err error) error {
+		if info != nil && !info.IsDir() && strings.EqualFold(filepath.Ext(info.Name()), ".cmk") {
+			foundMakroFiles = append(foundMakroFiles, path)
+		}
+		return nil
+	})
+	return foundMakroFiles
+}
@@ -0,0 +1,70 @@
+package corpus
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+)
+
+type CMKFormatError struct {
+	Message string
+}
+
+func (e *CMKFormatError) Error() string {
+	return fmt.Sprintf("can not format makro: %s", e.Message)
+}
+
+/*
+Rewrites CMK file in canonical layout, the same as Save: sections in the order Corpus writes them, numbered from 1 ([PILA1], [PILA2]),
+one blank line between sections, lines without quotes and CRLF. Lines before first section (comments) stay at the top.
+Encoding of data is detected, formatted text is encoded with encoding (EncodingAuto is Windows-1250).
+
+Formatting never changes makro: formatted text is read again and must give identical M1, otherwise CMKFormatError is returned.
+File with unknown section can not be formatted, the section would be lost.
+*/
+func FormatMakro(data []byte, encoding TextEncoding) ([]byte, error) {
+	if encoding == EncodingAuto {
+		encoding = EncodingWindows1250
+	}
+	makro, sections, err := readCMK("", data, EncodingAuto)
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	for _, section := range sections {
+		if section.Err != nil {
+			return nil, &CMKFormatError{Message: fmt.Sprintf("line %d: %s", section.Line, section.Err)}
+		}
+		if section.Name == "" {
+			for _, line := range section.Lines {
+				header = append(header, line.Text)
+			}
+		}
+	}
+	var formatted bytes.Buffer
+	if err := makro.saveWithHeader(&formatted, header, encoding); err != nil {
+		return nil, err
+	}
+	reparsed, _, err := readCMK("", formatted.Bytes(), encoding)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(makro, reparsed) {
+		return nil, &CMKFormatError{Message: fmt.Sprintf("formatted makro is different from original, are there characters that can not be saved with %s?", encoding)}
+	}
+	return formatted.Bytes(), nil
+}
+
+// formatted content of file and true if it is different from file, see FormatMakro
+func FormatMakroFile(makroFile string, encoding TextEncoding) ([]byte, bool, error) {
+	data, err := os.ReadFile(makroFile)
+	if err != nil {
+		return nil, false, err
+	}
+	formatted, err := FormatMakro(data, encoding)
+	if err != nil {
+		return nil, false, err
+	}
+	return formatted, !bytes.Equal(data, formatted), nil
+}
@@ -0,0 +1,69 @@
+package corpus
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatMakro(t *testing.T) {
+	input := "// naglowek\n\n\n[PILA5]\nJ=1\nGN=rowek na dno\n[MAKRO3]\nNAME=sub\n\n[pila7]\nJ=2\n[FORMULE]\nf=a*2\n[VARIJABLE]\n// komentarz \xb9\xea\na=1\n"
+	expected := "// naglowek\r\n\r\n[VARIJABLE]\r\n// komentarz \xb9\xea\r\na=1\r\n\r\n[FORMULE]\r\nf=a*2\r\n\r\n[PILA1]\r\nJ=1\r\nGN=rowek na dno\r\n\r\n[PILA2]\r\nJ=2\r\n\r\n[MAKRO1]\r\nNAME=sub\r\n"
+	formatted, err := FormatMakro([]byte(input), EncodingAuto)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if string(formatted) != expected {
+		t.Errorf("bad format: %q", formatted)
+	}
+	again, err := FormatMakro(formatted, EncodingAuto)
+	if err != nil || !bytes.Equal(again, formatted) {
+		t.Errorf("formatted makro should not change: %q %s", again, err)
+	}
+}
+
+func TestFormatMakroUnknownSection(t *testing.T) {
+	_, err := FormatMakro([]byte("[VARIJABLE]\r\na=1\r\n\r\n[NIEZNANA1]\r\nx=1\r\n"), EncodingAuto)
+	var formatError *CMKFormatError
+	if !errors.As(err, &formatError) {
+		t.Errorf("unknown section should not be formatted: %v", err)
+	}
+}
+
+func TestFormatMakroFileGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(pathToCMKTestData, "*.CMK"))
+	if err != nil || len(files) == 0 {
+		t.Error("no test files", err)
+		t.FailNow()
+	}
+	for _, file := range files {
+		formatted, changed, err := FormatMakroFile(file, EncodingAuto)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		golden, _ := os.ReadFile(filepath.Join(pathToCMKGoldenTestData, filepath.Base(file)))
+		if !changed || !bytes.Equal(formatted, golden) {
+			t.Errorf("formatted makro is different from golden file: %s\n%s", file, formatted)
+		}
+	}
+}
+
+func TestFormatMakroEncoding(t *testing.T) {
+	input := []byte("[VARIJABLE]\n// gęś\nx=1\n")
+	formatted, err := FormatMakro(input, EncodingWindows1250)
+	if err != nil || string(formatted) != "[VARIJABLE]\r\n// g\xea\x9c\r\nx=1\r\n" {
+		t.Errorf("UTF-8 makro should be saved as Windows-1250: %q %s", formatted, err)
+	}
+	input = []byte("[VARIJABLE]\n// ж\nx=1\n")
+	var formatError *CMKFormatError
+	if _, err := FormatMakro(input, EncodingWindows1250); !errors.As(err, &formatError) {
+		t.Errorf("character that can not be saved should fail: %v", err)
+	}
+	if formatted, err := FormatMakro(input, EncodingUTF8); err != nil || string(formatted) != "[VARIJABLE]\r\n// ж\r\nx=1\r\n" {
+		t.Errorf("bad UTF-8 format: %q %s", formatted, err)
+	}
+}
@@ -0,0 +1,141 @@
+package corpus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// how many times makro is used on single plate, with the same variables
+type InventoryRecord struct {
+	MakroName string `json:"makro"`
+	File      string `json:"file"`
+	Element   string `json:"element"`
+	Plate     string `json:"plate"`
+	// makro that calls this one in [MAKRO] section, empty for makro placed directly on plate
+	CalledBy string `json:"calledBy"`
+	// [VARIJABLE] of makro as saved on plate
+	Variables string `json:"variables"`
+	Count     int    `json:"count"`
+}
+
+// lists every makro and submakro used in inputFiles. Files that can not be read are skipped, errors are returned
+func NewInventory(inputFiles []string) ([]InventoryRecord, error) {
+	records := []InventoryRecord{}
+	index := map[InventoryRecord]int{}
+	var errs []error
+	for _, inputFile := range inputFiles {
+		err := VisitMakrosInCorpusFile(inputFile, func(name string, makro *M1, usage MakroUsage) {
+			key := InventoryRecord{
+				MakroName: name,
+				File:      usage.File,
+				Element:   usage.Element,
+				Plate:     usage.Plate,
+				CalledBy:  usage.CalledBy,
+				Variables: makro.Varijable.DAT,
+			}
+			i, found := index[key]
+			if !found {
+				i = len(records)
+				index[key] = i
+				records = append(records, key)
+			}
+			records[i].Count++
+		})
+		if err != nil {
+			err = fmt.Errorf("%s: %w", inputFile, err)
+			errs = append(errs, err)
+		}
+	}
+	// files keep the order they were given in
+	sort.SliceStable(records, func(i, j int) bool { return records[i].MakroName < records[j].MakroName })
+	return records, errors.Join(errs...)
+}
+
+// human readable inventory: makro -> file -> cabinet -> plate -> count
+func WriteInventoryText(w io.Writer, records []InventoryRecord) error {
+	total := map[string]int{}
+	for _, record := range records {
+		total[record.MakroName] += record.Count
+	}
+	var previous InventoryRecord
+	for i, record := range records {
+		first := i == 0
+		if first || record.MakroName != previous.MakroName {
+			fmt.Fprintf(w, "Makro: '%s': used %d times\n", record.MakroName, total[record.MakroName])
+			first = true
+		}
+		if first || record.File != previous.File {
+			fmt.Fprintf(w, "  File: '%s'\n", record.File)
+			first = true
+		}
+		if first || record.Element != previous.Element {
+			fmt.Fprintf(w, "    Cabinet: '%s'\n", record.Element)
+		}
+		fmt.Fprintf(w, "      Plate: '%s': %d", record.Plate, record.Count)
+		if record.CalledBy != "" {
+			fmt.Fprintf(w, " (called by '%s')", record.CalledBy)
+		}
+		fmt.Fprintln(w)
+		if record.Variables != "" {
+			fmt.Fprintf(w, "        [VARIJABLE] %s\n", strings.Join(DecodeAllCMKLines(record.Variables), ", "))
+		}
+		previous = record
+	}
+	_, err := fmt.Fprintf(w, "Makros: %d\n", len(total))
+	return err
+}
+
+func WriteInventoryJSON(w io.Writer, records []InventoryRecord) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+var inventoryCSVHeader = []string{"makro", "file", "element", "plate", "calledBy", "variables", "count"}
+
+func WriteInventoryCSV(w io.Writer, records []InventoryRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(inventoryCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{r.MakroName, r.File, r.Element, r.Plate, r.CalledBy, r.Variables, strconv.Itoa(r.Count)}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// format is picked by extension: .json, .csv or .txt
+func WriteInventoryFile(path string, records []InventoryRecord) error {
+	var write func(io.Writer, []InventoryRecord) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		write = WriteInventoryJSON
+	case ".csv":
+		write = WriteInventoryCSV
+	case ".txt":
+		write = WriteInventoryText
+	default:
+		return fmt.Errorf("unsupported inventory format: '%s', use .json, .csv or .txt", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("can not create inventory: %w", err)
+	}
+	defer f.Close()
+	if err := write(f, records); err != nil {
+		return err
+	}
+	return f.Close()
+}
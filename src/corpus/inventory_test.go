@@ -0,0 +1,56 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewInventory(t *testing.T) {
+	files := []string{
+		filepath.Join(pathToE3DTestDataVertsion16, "simple_macro_in_macro.E3D"),
+		filepath.Join(pathToE3DTestDataVertsion17, "simple_macro_in_macro.E3D"),
+	}
+	records, err := NewInventory(files)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	counts := map[string]int{}
+	for _, record := range records {
+		counts[record.MakroName] += record.Count
+		if record.Element != "simple_original_custom" || record.Plate != "Wieniec_Gorny" {
+			t.Errorf("wrong place: %v", record)
+		}
+	}
+	// the same makro and its submakros in both files
+	expected := map[string]int{"custom": 2, "Blenda": 2, "Blenda_dodatkowa": 2}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("makro '%s' used %d times, expected %d", name, counts[name], count)
+		}
+	}
+	if records[0].MakroName != "Blenda" || records[0].CalledBy != "custom" || records[0].File != files[0] {
+		t.Errorf("records are not sorted: %v", records[0])
+	}
+
+	var buf bytes.Buffer
+	if err := WriteInventoryCSV(&buf, records); err != nil {
+		t.Error(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != len(records)+1 || strings.Join(rows[0], ",") != strings.Join(inventoryCSVHeader, ",") {
+		t.Errorf("csv is bad: %v %s", rows, err)
+	}
+	buf.Reset()
+	if err := WriteInventoryJSON(&buf, records); err != nil {
+		t.Error(err)
+	}
+	var decoded []InventoryRecord
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != len(records) || decoded[0] != records[0] {
+		t.Errorf("json is bad: %s", err)
+	}
+}
@@ -0,0 +1,293 @@
+package corpus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type LintRule int
+
+const (
+	// used in [VARIJABLE], [FORMULE], [PILA] or [JOINT] but not defined in [VARIJABLE] or [FORMULE]
+	LintUndefinedVariable LintRule = iota
+	// defined in [VARIJABLE], but not used in any section of makro or its submakros. Global variables ("_" prefix) are skipped
+	LintUnusedVariable
+	// the same key twice in one section
+	LintDuplicateKey
+	LintUnknownSection
+	// [MAKRO] without NAME= or with empty NAME=
+	LintEmptyMakroName
+	// [MAKRO] NAME= of makro that has no CMK file
+	LintMissingSubmakro
+)
+
+var lintRuleNames = map[LintRule]string{
+	LintUndefinedVariable: "undefined-variable",
+	LintUnusedVariable:    "unused-variable",
+	LintDuplicateKey:      "duplicate-key",
+	LintUnknownSection:    "unknown-section",
+	LintEmptyMakroName:    "empty-makro-name",
+	LintMissingSubmakro:   "missing-submakro",
+}
+
+func (r LintRule) String() string {
+	name, found := lintRuleNames[r]
+	if !found {
+		return fmt.Sprintf("LintRule(%d)", int(r))
+	}
+	return name
+}
+
+func (r LintRule) MarshalText() ([]byte, error) {
+	name, found := lintRuleNames[r]
+	if !found {
+		return nil, fmt.Errorf("unknown LintRule: %d", int(r))
+	}
+	return []byte(name), nil
+}
+
+func (r *LintRule) UnmarshalText(text []byte) error {
+	for rule, name := range lintRuleNames {
+		if strings.EqualFold(name, string(text)) {
+			*r = rule
+			return nil
+		}
+	}
+	names := []string{}
+	for rule := LintUndefinedVariable; rule <= LintMissingSubmakro; rule++ {
+		names = append(names, rule.String())
+	}
+	return fmt.Errorf("unknown lint rule: '%s', use one of: %s", text, strings.Join(names, ", "))
+}
+
+type LintIssue struct {
+	File string `json:"file"`
+	// line in file, starts from 1
+	Line    int      `json:"line"`
+	Rule    LintRule `json:"rule"`
+	Message string   `json:"message"`
+}
+
+// "Zawiasy.CMK:12: message (rule)", like compiler errors
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", i.File, i.Line, i.Message, i.Rule)
+}
+
+// sections where values are expressions that must use defined variables
+var lintCheckedSections = []string{"varijable", "formule", "pila", "joint"}
+
+// keys of checked sections with text value, not expression: "GN=frezowanie pila"
+var lintTextKeys = []string{"gn"}
+
+// variable of makro, not evar.x, obj1.x, parent.obj1.x, <plate DNAME>.x or pmaxx
+func isLintVariableReference(name string) bool {
+	lower := strings.ToLower(name)
+	return !strings.Contains(lower, ".") && lower != "pmaxx" && lower != "pmaxy"
+}
+
+// variables used in value of line, comments have no references
+func lintLineReferences(line string) []string {
+	if strings.HasPrefix(line, "//") {
+		return nil
+	}
+	_, value, found := strings.Cut(line, "=")
+	if !found {
+		return nil
+	}
+	references := []string{}
+	for _, reference := range NewExpression(value).References() {
+		if isLintVariableReference(reference) {
+			references = append(references, reference)
+		}
+	}
+	return references
+}
+
+// key of line, empty for comments and lines without "="
+func lintLineKey(line string) string {
+	if strings.HasPrefix(line, "//") {
+		return ""
+	}
+	key, _, found := strings.Cut(line, "=")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(key)
+}
+
+// normalized names of variables used in all sections of makro file and its submakros (read recursively), visited prevents reading file twice
+func lintUsedVariables(sections []CMKFileSection, library *MakroLibrary, visited map[string]bool, used map[string]bool) {
+	for _, section := range sections {
+		isMakro := strings.EqualFold(section.Name, "makro")
+		for _, line := range section.Lines {
+			for _, reference := range lintLineReferences(line.Text) {
+				used[normalizeVariableName(reference)] = true
+			}
+			// parameter of submakro call takes value of parent variable with the same name
+			if key := lintLineKey(line.Text); isMakro && key != "" {
+				used[normalizeVariableName(key)] = true
+			}
+		}
+		if !isMakro {
+			continue
+		}
+		name, found := lintSubmakroName(section)
+		if !found || name.Text == "" {
+			continue
+		}
+		makroFile, err := library.MakroFile(name.Text)
+		if err != nil || visited[makroFile] {
+			continue
+		}
+		visited[makroFile] = true
+		if _, subSections, err := loadCMKFile(name.Text, makroFile); err == nil {
+			lintUsedVariables(subSections, library, visited, used)
+		}
+	}
+}
+
+// NAME= line of [MAKRO] section, Text is value of NAME
+func lintSubmakroName(section CMKFileSection) (CMKFileLine, bool) {
+	for _, line := range section.Lines {
+		if key := lintLineKey(line.Text); strings.EqualFold(key, "name") {
+			_, value, _ := strings.Cut(line.Text, "=")
+			return CMKFileLine{Number: line.Number, Text: strings.TrimSpace(value)}, true
+		}
+	}
+	return CMKFileLine{}, false
+}
+
+/*
+Checks CMK file without resolving submakros, so file with errors can be checked too. File is read by the same parser as
+partialNewMakroFromCMKFile (see loadCMKFile), sections are checked as they are written in file to report line numbers.
+Submakros are found with library, they are read only to find variables that they use. Library can not be nil.
+
+Undefined variable can be a false positive when makro uses cabinet variable (from evar) without "evar." prefix.
+*/
+func LintMakroFile(makroFile string, library *MakroLibrary) ([]LintIssue, error) {
+	makroName := strings.TrimSuffix(filepath.Base(makroFile), filepath.Ext(makroFile))
+	_, sections, err := loadCMKFile(makroName, makroFile)
+	if err != nil {
+		return nil, err
+	}
+	issues := []LintIssue{}
+	report := func(line int, rule LintRule, format string, args ...any) {
+		issues = append(issues, LintIssue{File: makroFile, Line: line, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	defined := map[string]bool{}
+	variables := []CMKFileLine{}
+	for _, section := range sections {
+		switch strings.ToLower(section.Name) {
+		case "varijable", "formule":
+			for _, line := range section.Lines {
+				if key := lintLineKey(line.Text); key != "" {
+					defined[normalizeVariableName(key)] = true
+					if strings.EqualFold(section.Name, "varijable") {
+						variables = append(variables, CMKFileLine{Number: line.Number, Text: key})
+					}
+				}
+			}
+		}
+	}
+
+	for _, section := range sections {
+		if section.Err != nil {
+			report(section.Line, LintUnknownSection, "%s", section.Err)
+		}
+		// key -> line of first use
+		keys := map[string]int{}
+		isChecked := slices.Contains(lintCheckedSections, strings.ToLower(section.Name))
+		for _, line := range section.Lines {
+			key := lintLineKey(line.Text)
+			if key == "" {
+				continue
+			}
+			if first, found := keys[strings.ToLower(key)]; found {
+				report(line.Number, LintDuplicateKey, "[%s] key '%s' is already defined in line %d", section.FullName, key, first)
+			} else {
+				keys[strings.ToLower(key)] = line.Number
+			}
+			if !isChecked || slices.Contains(lintTextKeys, strings.ToLower(key)) {
+				continue
+			}
+			for _, reference := range lintLineReferences(line.Text) {
+				if !defined[normalizeVariableName(reference)] {
+					report(line.Number, LintUndefinedVariable, "[%s] %s: variable '%s' is not defined in [VARIJABLE] or [FORMULE]", section.FullName, key, reference)
+				}
+			}
+		}
+		if !strings.EqualFold(section.Name, "makro") {
+			continue
+		}
+		name, found := lintSubmakroName(section)
+		switch {
+		case !found:
+			report(section.Line, LintEmptyMakroName, "[%s] has no NAME=", section.FullName)
+		case name.Text == "":
+			report(name.Number, LintEmptyMakroName, "[%s] NAME= is empty", section.FullName)
+		default:
+			submakroFile, err := library.MakroFile(name.Text)
+			if err == nil {
+				_, err = os.Stat(submakroFile)
+			}
+			if err != nil {
+				report(name.Number, LintMissingSubmakro, "[%s] submakro '%s' not found: %s", section.FullName, name.Text, err)
+			}
+		}
+	}
+
+	used := map[string]bool{}
+	absMakroFile, _ := filepath.Abs(makroFile)
+	lintUsedVariables(sections, library, map[string]bool{absMakroFile: true}, used)
+	for _, variable := range variables {
+		if !strings.HasPrefix(variable.Text, "_") && !used[normalizeVariableName(variable.Text)] {
+			report(variable.Number, LintUnusedVariable, "[VARIJABLE] variable '%s' is never used", variable.Text)
+		}
+	}
+	slices.SortStableFunc(issues, func(a, b LintIssue) int {
+		return a.Line - b.Line
+	})
+	return issues, nil
+}
+
+// issues with disabled rules are skipped, errors of files that can not be read are aggregated
+func LintMakroFiles(makroFiles []string, library *MakroLibrary, disabled []LintRule) ([]LintIssue, error) {
+	var errs []error
+	issues := []LintIssue{}
+	for _, makroFile := range makroFiles {
+		fileIssues, err := LintMakroFile(makroFile, library)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", makroFile, err)
+			errs = append(errs, err)
+			continue
+		}
+		for _, issue := range fileIssues {
+			if !slices.Contains(disabled, issue.Rule) {
+				issues = append(issues, issue)
+			}
+		}
+	}
+	return issues, errors.Join(errs...)
+}
+
+func WriteLintText(w io.Writer, issues []LintIssue) error {
+	for _, issue := range issues {
+		if _, err := fmt.Fprintln(w, issue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteLintJSON(w io.Writer, issues []LintIssue) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(issues)
+}
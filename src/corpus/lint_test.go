@@ -0,0 +1,102 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lintTestMakro = `[VARIJABLE]
+a=1
+b=a*2
+nieuzywana=3
+_globalna=5
+uzywana_w_sub=1
+a=7
+
+[FORMULE]
+f=b+brak
+
+[PILA1]
+GN=rowek
+GD=f+obj1.gr+evar.x+pmaxx
+
+[MAKRO1]
+NAME=sub
+
+[MAKRO2]
+NAME=
+
+[MAKRO3]
+NAME=nie_ma
+
+[DZIWNA]
+x=1
+`
+
+func writeLintTestMakros(t *testing.T) string {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "lint.CMK"), []byte(strings.ReplaceAll(lintTestMakro, "\n", "\r\n")), 0644)
+	os.WriteFile(filepath.Join(dir, "sub.CMK"), []byte("[FORMULE]\r\ng=uzywana_w_sub\r\n"), 0644)
+	return dir
+}
+
+func TestLintMakroFile(t *testing.T) {
+	dir := writeLintTestMakros(t)
+	issues, err := LintMakroFile(filepath.Join(dir, "lint.CMK"), NewMakroLibrary(dir, nil))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	type expectedIssue struct {
+		line int
+		rule LintRule
+	}
+	expected := []expectedIssue{
+		{4, LintUnusedVariable},
+		{7, LintDuplicateKey},
+		{10, LintUndefinedVariable},
+		{20, LintEmptyMakroName},
+		{23, LintMissingSubmakro},
+		{25, LintUnknownSection},
+	}
+	if len(issues) != len(expected) {
+		t.Errorf("expected %d issues, got %d: %v", len(expected), len(issues), issues)
+		t.FailNow()
+	}
+	for i, issue := range issues {
+		if issue.Line != expected[i].line || issue.Rule != expected[i].rule {
+			t.Errorf("expected %s in line %d, got: %s", expected[i].rule, expected[i].line, issue)
+		}
+	}
+	if !strings.Contains(issues[2].Message, "'brak'") {
+		t.Errorf("undefined variable should be named: %s", issues[2].Message)
+	}
+}
+
+func TestLintMakroFilesDisabled(t *testing.T) {
+	dir := writeLintTestMakros(t)
+	issues, err := LintMakroFiles(FindMakroFiles(dir), NewMakroLibrary(dir, nil), []LintRule{LintUnusedVariable, LintUndefinedVariable})
+	if err != nil {
+		t.Error(err)
+	}
+	for _, issue := range issues {
+		if issue.Rule == LintUnusedVariable || issue.Rule == LintUndefinedVariable {
+			t.Errorf("disabled rule reported: %s", issue)
+		}
+	}
+	if len(issues) != 4 {
+		t.Errorf("expected 4 issues, got: %v", issues)
+	}
+}
+
+func TestLintRuleUnmarshalText(t *testing.T) {
+	var rule LintRule
+	if err := rule.UnmarshalText([]byte("Missing-Submakro")); err != nil || rule != LintMissingSubmakro {
+		t.Errorf("expected missing-submakro, got %s: %s", rule, err)
+	}
+	if err := rule.UnmarshalText([]byte("typo")); err == nil {
+		t.Errorf("unknown rule should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package corpus
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
 	"fmt"
@@ -22,16 +23,25 @@ func (tr TrimmerDecoder) Token() (xml.Token, error) {
 	return t, err
 }
 
-/* converts Spoj to MakLink inplace */
+/*
+fills Spoj from MakLink inplace, including subelements. MakLink is kept, it is still used when saving version 17.
+Useful for read only access (preview), version 17 is read and written natively
+*/
 func CorpusVersion17To16(elements []Element) error {
 	for i := range elements {
-		el := elements[i]
-		for m := range el.Elinks.MakLink {
-			spoj, err := NewSpoj(&el.Elinks.MakLink[m])
-			if err != nil {
-				return fmt.Errorf("Error converting Spoj to MakLink: %w", err)
+		var errOut error
+		elements[i].VisitElementsAndSubelements(func(el *Element) {
+			for m := range el.Elinks.MakLink {
+				spoj, err := NewSpoj(&el.Elinks.MakLink[m])
+				if err != nil {
+					errOut = fmt.Errorf("Error converting MakLink to Spoj: %w", err)
+					return
+				}
+				el.Elinks.Spoj = append(el.Elinks.Spoj, *spoj)
 			}
-			elements[i].Elinks.Spoj = append(elements[i].Elinks.Spoj, *spoj)
+		})
+		if errOut != nil {
+			return errOut
 		}
 	}
 	return nil
@@ -50,7 +60,12 @@ func NewCorpusFile(inputFile string) (*ProjectFile, *ElementFile, error) {
 	}
 	defer input.Close()
 
-	rawDecoder := xml.NewDecoder(input)
+	reader := bufio.NewReader(input)
+	// E3D files saved by Corpus start with UTF-8 BOM, it is not part of XML
+	if start, err := reader.Peek(len(utf8BOM)); err == nil && bytes.Equal(start, utf8BOM) {
+		reader.Discard(len(utf8BOM))
+	}
+	rawDecoder := xml.NewDecoder(reader)
 	decoder := xml.NewTokenDecoder(TrimmerDecoder{rawDecoder})
 	for {
 		token, err := decoder.Token()
@@ -75,9 +90,6 @@ func NewCorpusFile(inputFile string) (*ProjectFile, *ElementFile, error) {
 				if !isSupportedVersion(root.VER.Value) {
 					return nil, nil, fmt.Errorf("unsupported corpus file version: %s", root.VER.Value)
 				}
-				if root.VER.Value == "17" {
-					CorpusVersion17To16(root.Element)
-				}
 				return root, nil, nil
 			} else if strings.ToUpper(t.Name.Local) == "ELEMENTFILE" {
 				t.Name.Local = "ELEMENTFILE"
@@ -91,9 +103,6 @@ func NewCorpusFile(inputFile string) (*ProjectFile, *ElementFile, error) {
 				if !isSupportedVersion(root.VER.Value) {
 					return nil, nil, fmt.Errorf("unsupported corpus file version: %s", root.VER.Value)
 				}
-				if root.VER.Value == "17" {
-					CorpusVersion17To16(root.Element)
-				}
 				return nil, root, nil
 			}
 		default:
@@ -103,24 +112,66 @@ func NewCorpusFile(inputFile string) (*ProjectFile, *ElementFile, error) {
 }
 
 // goes via file token by token thus has better chance of being correct
-func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool,
+// dryRun does everything except writing outputFile
+func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool, dryRun bool,
 	handleE3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
 	handleS3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
 ) error {
-	err := os.MkdirAll(filepath.Dir(outputFile), os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("can not create path: '%s': %w", outputFile, err)
+	return readWriteCorpusFile(log.Default(), inputFile, outputFile, minify, dryRun, handleE3DFile, handleS3DFile)
+}
+
+func readWriteCorpusFile(logger *log.Logger, inputFile string, outputFile string, minify bool, dryRun bool,
+	handleE3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+	handleS3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+) error {
+	return transformCorpusFile(logger, inputFile, outputFile, dryRun, func(input []byte) ([]byte, error) {
+		return encodeCorpusFile(logger, input, minify, handleE3DFile, handleS3DFile)
+	})
+}
+
+// reads inputFile and writes transformed content to outputFile, dryRun does everything except writing
+func transformCorpusFile(logger *log.Logger, inputFile string, outputFile string, dryRun bool, transform func(input []byte) ([]byte, error)) error {
+	if !dryRun {
+		err := os.MkdirAll(filepath.Dir(outputFile), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("can not create path: '%s': %w", outputFile, err)
+		}
 	}
 
-	log.Printf("Reading Corpus file: '%s'", inputFile)
-	input, err := os.Open(inputFile)
+	logger.Printf("Reading Corpus file: '%s'", inputFile)
+	input, err := os.ReadFile(inputFile)
 	if err != nil {
 		return fmt.Errorf("error opening input file: %w", err)
 	}
-	defer input.Close()
+	output, err := transform(input)
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		logger.Printf("Dry run, not writing file: '%s'", outputFile)
+		return nil
+	}
+	err = WriteFileAtomic(outputFile, output)
+	if err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+	logger.Printf("Done writing file: '%s'", outputFile)
+	return nil
+}
 
+// decodes whole file, ELEMENTFILE and PROJECTFILE are passed to handlers and encoded again
+func encodeCorpusFile(logger *log.Logger, input []byte, minify bool,
+	handleE3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+	handleS3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+) ([]byte, error) {
 	var encodedData bytes.Buffer
-	rawDecoder := xml.NewDecoder(input)
+	// BOM is not part of XML, it is written back only if input has it
+	input, hasBOM := bytes.CutPrefix(input, utf8BOM)
+	if hasBOM {
+		encodedData.Write(utf8BOM)
+	}
+	rawDecoder := xml.NewDecoder(bytes.NewReader(input))
 	decoder := xml.NewTokenDecoder(TrimmerDecoder{rawDecoder})
 	encoder := xml.NewEncoder(&encodedData)
 	// indentation is actually considered xml.CharData, so pretty printing is actually modifying it
@@ -136,7 +187,7 @@ func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool,
 			if err.Error() == "EOF" {
 				break
 			}
-			return fmt.Errorf("error decoding XML: %w", err)
+			return nil, fmt.Errorf("error decoding XML: %w", err)
 		}
 
 		switch t := token.(type) {
@@ -146,8 +197,8 @@ func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool,
 				handleOut := handleS3DFile(decoder, t)
 				if handleOut != nil {
 					if err = encoder.Encode(handleOut); err != nil {
-						log.Printf("Error during encode: %s", err)
-						return err
+						logger.Printf("Error during encode: %s", err)
+						return nil, err
 					}
 				}
 			} else if strings.ToUpper(t.Name.Local) == "ELEMENTFILE" {
@@ -155,8 +206,8 @@ func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool,
 				handleOut := handleE3DFile(decoder, t)
 				if handleOut != nil {
 					if err = encoder.Encode(handleOut); err != nil {
-						log.Printf("Error during encode: %s", err)
-						return err
+						logger.Printf("Error during encode: %s", err)
+						return nil, err
 					}
 				}
 			} else {
@@ -170,18 +221,9 @@ func ReadWriteCorpusFile(inputFile string, outputFile string, minify bool,
 			encoder.EncodeToken(t)
 		}
 	}
-	err = encoder.Flush()
+	err := encoder.Flush()
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	output, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("error creating output file: %w", err)
-	}
-	defer output.Close()
-
-	output.Write(encodedData.Bytes())
-	log.Printf("Done writing file: '%s'", outputFile)
-	return nil
+	return encodedData.Bytes(), nil
 }
@@ -8,7 +8,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"slices"
 	"strings"
+
+	"golang.org/x/text/encoding/charmap"
 )
 
 type GenericNode struct {
@@ -47,9 +50,9 @@ func (ef *ElementFile) UnmarshalXML(d *xml.Decoder, start xml.StartElement) erro
 		return err
 	}
 	ef = (*ElementFile)(temp.Alias)
-	for i := range ef.Element {
-		ef.Element[i].Elinks.EncodeVersion = ef.VER.Value
-	}
+	ef.VisitElementsAndSubelements(func(e *Element) {
+		e.Elinks.EncodeVersion = ef.VER.Value
+	})
 	return nil
 }
 
@@ -145,8 +148,13 @@ type MakLink struct {
 	/* unknown */
 	SP  xml.Attr `xml:"SP,attr"`
 	MM1 MM1      `xml:"MM1"`
-	/* unknown, M2 seems to be unused */
-	MM2 GenericNode `xml:"MM2"`
+	/* second makro slot, usually empty: <MM2 MN=""> */
+	MM2 MM1 `xml:"MM2"`
+}
+
+// makros in slot order: MM1, MM2
+func (l *MakLink) Makros() []*MM1 {
+	return []*MM1{&l.MM1, &l.MM2}
 }
 
 func NewMakLink(spoj *Spoj) (*MakLink, error) {
@@ -160,8 +168,11 @@ func NewMakLink(spoj *Spoj) (*MakLink, error) {
 		return nil, err
 	}
 	makLink.MM1 = *makNew
-	// todo MM2 not supported...
-	makLink.MM2 = spoj.Makro2
+	makNew, err = NewMM1(&spoj.Makro2)
+	if err != nil {
+		return nil, err
+	}
+	makLink.MM2 = *makNew
 	return &makLink, nil
 }
 
@@ -175,8 +186,13 @@ type Spoj struct {
 	/* unknown */
 	SP     xml.Attr `xml:"SP,attr"`
 	Makro1 M1       `xml:"M1"`
-	/* unknown, M2 seems to be unused */
-	Makro2 GenericNode `xml:"M2"`
+	/* second makro slot, usually empty: <M2 MN=""> */
+	Makro2 M1 `xml:"M2"`
+}
+
+// makros in slot order: M1, M2
+func (s *Spoj) Makros() []*M1 {
+	return []*M1{&s.Makro1, &s.Makro2}
 }
 
 // used for version conversion: 17 -> 16
@@ -192,8 +208,11 @@ func NewSpoj(makLink *MakLink) (*Spoj, error) {
 		return nil, err
 	}
 	spoj.Makro1 = *makNew
-	// todo M2 not supported...
-	spoj.Makro2 = GenericNode{}
+	makNew, err = NewM1(&makLink.MM2)
+	if err != nil {
+		return nil, err
+	}
+	spoj.Makro2 = *makNew
 	return &spoj, nil
 }
 
@@ -211,6 +230,10 @@ type GenericNodeWithC6Dat struct { // Varijable
 }
 
 func (gn *GenericNodeWithC6Dat) DecodeC6Dat() (string, error) {
+	// missing section, e.g. empty MM2 slot
+	if gn.C6DAT == "" {
+		return "", nil
+	}
 	decoded, err := base64.StdEncoding.DecodeString(gn.C6DAT)
 	if err != nil {
 		return "", err
@@ -230,14 +253,26 @@ func (gn *GenericNodeWithC6Dat) DecodeC6Dat() (string, error) {
 		return "", err
 	}
 
-	return output.String(), nil
+	// Corpus compresses text encoded in Windows-1250, the same as in CMK files
+	return charmap.Windows1250.NewDecoder().String(output.String())
 }
 
+// Characters that can not be encoded with Windows-1250 are replaced with "?" and warning is logged, the same as when saving CMK
 func EncodeC6Dat(input string) (*string, error) {
+	return encodeC6Dat("", input)
+}
+
+// makroName is used only in warning
+func encodeC6Dat(makroName string, input string) (*string, error) {
+	encoded, unrepresentable, err := EncodeText(input, EncodingWindows1250)
+	if err != nil {
+		return nil, fmt.Errorf("can not encode to Windows-1250: %w", err)
+	}
+	logUnrepresentable(makroName, EncodingWindows1250, unrepresentable)
 	// Compress using zlib
 	var compressed bytes.Buffer
 	writer := zlib.NewWriter(&compressed)
-	_, err := writer.Write([]byte(input))
+	_, err = writer.Write(encoded)
 	if err != nil {
 		return nil, err
 	}
@@ -345,6 +380,8 @@ type M1 struct {
 	Pocket    []GenericNodeWithDat `xml:"MSPOCK,omitempty"`
 	Raster    []GenericNodeWithDat `xml:"MSRA,omitempty"`
 	Makro     []M1EmbeddedMakro    `xml:"MSMA,omitempty"`
+	// order of sections as read from file, nil for makro loaded from CMK
+	layout *xmlLayout
 }
 
 // version 17
@@ -361,6 +398,8 @@ type MM1 struct {
 	Pocket    []GenericNodeWithC6Dat `xml:"MSPOCK,omitempty"`
 	Raster    []GenericNodeWithC6Dat `xml:"MSRA,omitempty"`
 	Makro     []MM1EmbeddedMakro     `xml:"MSMA,omitempty"`
+	// see M1.layout
+	layout *xmlLayout
 }
 
 // decoding version 17 -> 16
@@ -368,7 +407,10 @@ func NewM1(mm1 *MM1) (*M1, error) {
 	m1 := M1{
 		GenericNode: mm1.GenericNode,
 		MakroName:   mm1.MakroName,
+		layout:      mm1.layout,
 	}
+	// name is given by field tag: M1 or MM1
+	m1.XMLName = xml.Name{}
 	{
 		decoded, err := mm1.Varijable.DecodeC6Dat()
 		if err != nil {
@@ -391,6 +433,7 @@ func NewM1(mm1 *MM1) (*M1, error) {
 		if err != nil {
 			return nil, err
 		}
+		m1.Pila[i].GenericNode = pilaEncoded.GenericNode
 		m1.Pila[i].DAT = decoded
 	}
 
@@ -460,9 +503,12 @@ func NewMM1(m1 *M1) (*MM1, error) {
 	mm1 := MM1{
 		GenericNode: m1.GenericNode,
 		MakroName:   m1.MakroName,
+		layout:      m1.layout,
 	}
+	// name is given by field tag: M1 or MM1
+	mm1.XMLName = xml.Name{}
 	{
-		encoded, err := EncodeC6Dat(m1.Varijable.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, m1.Varijable.DAT)
 		if err != nil {
 			return nil, err
 		}
@@ -471,7 +517,7 @@ func NewMM1(m1 *M1) (*MM1, error) {
 	}
 
 	if m1.Formule != nil {
-		encoded, err := EncodeC6Dat(m1.Formule.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, m1.Formule.DAT)
 		if err != nil {
 			return nil, err
 		}
@@ -480,15 +526,16 @@ func NewMM1(m1 *M1) (*MM1, error) {
 
 	mm1.Pila = make([]GenericNodeWithC6Dat, len(m1.Pila))
 	for i, pilaEncoded := range m1.Pila {
-		encoded, err := EncodeC6Dat(pilaEncoded.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, pilaEncoded.DAT)
 		if err != nil {
 			return nil, err
 		}
-		mm1.Pila[i].DAT = *encoded
+		mm1.Pila[i].GenericNode = pilaEncoded.GenericNode
+		mm1.Pila[i].C6DAT = *encoded
 	}
 
 	if m1.Joint != nil {
-		encoded, err := EncodeC6Dat(m1.Joint.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, m1.Joint.DAT)
 		if err != nil {
 			return nil, err
 		}
@@ -497,42 +544,42 @@ func NewMM1(m1 *M1) (*MM1, error) {
 
 	mm1.Grupa = make([]GenericNodeWithC6Dat, len(m1.Grupa))
 	for i, encodedItem := range m1.Grupa {
-		encoded, err := EncodeC6Dat(encodedItem.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, encodedItem.DAT)
 		if err != nil {
 			return nil, err
 		}
 		mm1.Grupa[i].GenericNode = encodedItem.GenericNode
-		mm1.Grupa[i].DAT = *encoded
+		mm1.Grupa[i].C6DAT = *encoded
 	}
 
 	mm1.Potrosni = make([]GenericNodeWithC6Dat, len(m1.Potrosni))
 	for i, encodedItem := range m1.Potrosni {
-		encoded, err := EncodeC6Dat(encodedItem.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, encodedItem.DAT)
 		if err != nil {
 			return nil, err
 		}
 		mm1.Potrosni[i].GenericNode = encodedItem.GenericNode
-		mm1.Potrosni[i].DAT = *encoded
+		mm1.Potrosni[i].C6DAT = *encoded
 	}
 
 	mm1.Pocket = make([]GenericNodeWithC6Dat, len(m1.Pocket))
 	for i, encodedItem := range m1.Pocket {
-		encoded, err := EncodeC6Dat(encodedItem.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, encodedItem.DAT)
 		if err != nil {
 			return nil, err
 		}
 		mm1.Pocket[i].GenericNode = encodedItem.GenericNode
-		mm1.Pocket[i].DAT = *encoded
+		mm1.Pocket[i].C6DAT = *encoded
 	}
 
 	mm1.Raster = make([]GenericNodeWithC6Dat, len(m1.Raster))
 	for i, encodedItem := range m1.Raster {
-		encoded, err := EncodeC6Dat(encodedItem.DAT)
+		encoded, err := encodeC6Dat(m1.MakroName, encodedItem.DAT)
 		if err != nil {
 			return nil, err
 		}
 		mm1.Raster[i].GenericNode = encodedItem.GenericNode
-		mm1.Raster[i].DAT = *encoded
+		mm1.Raster[i].C6DAT = *encoded
 	}
 
 	mm1.Makro = make([]MM1EmbeddedMakro, len(m1.Makro))
@@ -547,88 +594,75 @@ func NewMM1(m1 *M1) (*MM1, error) {
 	return &mm1, nil
 }
 
-// save makro as CMK file
-func (m *M1) Save(w io.Writer) error {
-	if _, err := w.Write([]byte("[VARIJABLE]\n")); err != nil {
-		return err
-	}
-	{
-		text := strings.Join(DecodeAllCMKLines(m.Varijable.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
-	}
-	if m.Joint != nil {
-		if _, err := w.Write([]byte("\n\n[JOINT]\n")); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(m.Joint.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
+func (gn GenericNode) copy() GenericNode {
+	out := GenericNode{XMLName: gn.XMLName, Chardata: gn.Chardata}
+	if gn.Attr != nil {
+		out.Attr = slices.Clone(gn.Attr)
 	}
-	if m.Formule != nil {
-		if _, err := w.Write([]byte("\n\n[FORMULE]\n")); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(m.Formule.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
+	if gn.Content != nil {
+		out.Content = make([]GenericNode, len(gn.Content))
+		for i := range gn.Content {
+			out.Content[i] = gn.Content[i].copy()
 		}
 	}
+	return out
+}
 
-	for i, item := range m.Pocket {
-		section := fmt.Sprintf("\n\n[POCKET%d]\n", i)
-		if _, err := w.Write([]byte(section)); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(item.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
+func (gn GenericNodeWithDat) copy() GenericNodeWithDat {
+	return GenericNodeWithDat{GenericNode: gn.GenericNode.copy(), DAT: gn.DAT}
+}
+
+func copyNodesWithDat(nodes []GenericNodeWithDat) []GenericNodeWithDat {
+	if nodes == nil {
+		return nil
 	}
-	for i, item := range m.Potrosni {
-		section := fmt.Sprintf("\n\n[POTROSNI%d]\n", i)
-		if _, err := w.Write([]byte(section)); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(item.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
+	out := make([]GenericNodeWithDat, len(nodes))
+	for i := range nodes {
+		out[i] = nodes[i].copy()
 	}
-	for i, item := range m.Grupa {
-		section := fmt.Sprintf("\n\n[GRUPA%d]\n", i)
-		if _, err := w.Write([]byte(section)); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(item.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
+	return out
+}
+
+// deep copy. Makros loaded from CMK are shared between files (and goroutines), so copy before modifying
+// unused makro slot: no name and no content, like <M2 MN=""><MSVA DAT=""></MSVA></M2>
+func (m *M1) isEmpty() bool {
+	return m.MakroName == "" && m.Varijable.DAT == "" && m.Formule == nil && m.Joint == nil && len(m.Pila) == 0 && len(m.Grupa) == 0 &&
+		len(m.Potrosni) == 0 && len(m.Pocket) == 0 && len(m.Raster) == 0 && len(m.Makro) == 0
+}
+
+func (m *M1) Copy() *M1 {
+	out := M1{
+		GenericNode: m.GenericNode.copy(),
+		MakroName:   m.MakroName,
+		Varijable:   m.Varijable.copy(),
+		Pila:        copyNodesWithDat(m.Pila),
+		Grupa:       copyNodesWithDat(m.Grupa),
+		Potrosni:    copyNodesWithDat(m.Potrosni),
+		Pocket:      copyNodesWithDat(m.Pocket),
+		Raster:      copyNodesWithDat(m.Raster),
+		layout:      m.layout,
 	}
-	for i, item := range m.Raster {
-		section := fmt.Sprintf("\n\n[RASTER%d]\n", i)
-		if _, err := w.Write([]byte(section)); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(item.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
-		}
+	if m.Formule != nil {
+		formule := m.Formule.copy()
+		out.Formule = &formule
 	}
-	for i, item := range m.Makro {
-		section := fmt.Sprintf("\n\n[MAKRO%d]\n", i)
-		if _, err := w.Write([]byte(section)); err != nil {
-			return err
-		}
-		text := strings.Join(DecodeAllCMKLines(item.DAT), "\n")
-		if _, err := w.Write([]byte(text)); err != nil {
-			return err
+	if m.Joint != nil {
+		joint := m.Joint.copy()
+		out.Joint = &joint
+	}
+	if m.Makro != nil {
+		out.Makro = make([]M1EmbeddedMakro, len(m.Makro))
+		for i, embedded := range m.Makro {
+			out.Makro[i] = M1EmbeddedMakro{
+				GenericNodeWithDat: embedded.GenericNodeWithDat.copy(),
+				EmbeddedMakroName:  embedded.EmbeddedMakroName,
+			}
+			if embedded.MAK != nil {
+				out.Makro[i].MAK = embedded.MAK.Copy()
+			}
 		}
 	}
-
-	return nil
+	return &out
 }
 
 func (ef *ElementFile) VisitElementsAndSubelements(f func(*Element)) {
@@ -654,7 +688,9 @@ func (m *M1) partialVisitSubmakros(embededParent *M1EmbeddedMakro, f func(parent
 	for i := range m.Makro {
 		submacro := m.Makro[i]
 		f(m, embededParent, &submacro)
-		submacro.MAK.partialVisitSubmakros(&submacro, f)
+		if submacro.MAK != nil {
+			submacro.MAK.partialVisitSubmakros(&submacro, f)
+		}
 	}
 }
 
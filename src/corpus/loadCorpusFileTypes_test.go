@@ -1,6 +1,13 @@
 package corpus
 
-import "testing"
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"io"
+	"path/filepath"
+	"testing"
+)
 
 func TestGenericNodeWithC6DatDecodeAndEncode(t *testing.T) {
 	gn := GenericNodeWithC6Dat{C6DAT: "eJxz9vfzc3UOsTUy1snNzEvJLC5JzEtOtdU1NNHJTayA800BB+cNZg=="}
@@ -21,8 +28,78 @@ func TestGenericNodeWithC6DatDecodeAndEncode(t *testing.T) {
 		t.Errorf("Encoding failed, got: %s", decoded)
 		t.FailNow()
 	}
-	if *encoded != gn.C6DAT {
-		t.Errorf("Encoding produced different output fomr original: \n%s - expected, got: \n%s", gn.C6DAT, decoded)
+	// compress/zlib does not produce the same stream as zlib used by Corpus, only decoded content can be compared
+	reencoded := GenericNodeWithC6Dat{C6DAT: *encoded}
+	if redecoded, err := reencoded.DecodeC6Dat(); err != nil || redecoded != decoded {
+		t.Errorf("Encoding produced different output from original: \n%s - expected, got: \n%s", decoded, redecoded)
 		t.FailNow()
 	}
 }
+
+func TestMakLinkSpojSlot2(t *testing.T) {
+	_, elementFile, err := NewCorpusFile(filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	spoj := elementFile.Element[0].Elinks.Spoj[0]
+	// slot 1 makro copied to slot 2, empty slot is converted too
+	for _, makro2 := range []M1{spoj.Makro1, spoj.Makro2} {
+		spoj.Makro2 = makro2
+		makLink, err := NewMakLink(&spoj)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if makLink.MM2.MakroName != makro2.MakroName {
+			t.Errorf("wrong makro in MM2: '%s'", makLink.MM2.MakroName)
+		}
+		converted, err := NewSpoj(makLink)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if converted.Makro2.MakroName != makro2.MakroName || converted.Makro2.Varijable.DAT != makro2.Varijable.DAT {
+			t.Errorf("M2 changed after 16 -> 17 -> 16: '%s' %s", converted.Makro2.MakroName, converted.Makro2.Varijable.DAT)
+		}
+		if converted.Makro2.isEmpty() != makro2.isEmpty() {
+			t.Errorf("empty M2 changed after 16 -> 17 -> 16: %t", converted.Makro2.isEmpty())
+		}
+	}
+}
+
+func TestC6DatPolishCharacters(t *testing.T) {
+	text := "// zażółć gęślą jaźń,Kołek_3D=1"
+	encoded, err := EncodeC6Dat(text)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// Corpus compresses Windows-1250, the same as in CMK files
+	compressed, _ := base64.StdEncoding.DecodeString(*encoded)
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	raw, _ := io.ReadAll(reader)
+	if string(raw) != "// za\xbf\xf3\xb3\xe6 g\xea\x9cl\xb9 ja\x9f\xf1,Ko\xb3ek_3D=1" {
+		t.Errorf("text should be compressed in Windows-1250: %q", raw)
+	}
+	gn := GenericNodeWithC6Dat{C6DAT: *encoded}
+	if decoded, err := gn.DecodeC6Dat(); err != nil || decoded != text {
+		t.Errorf("round trip failed: '%s' %s", decoded, err)
+	}
+}
+
+func TestC6DatUnrepresentableCharacters(t *testing.T) {
+	encoded, err := EncodeC6Dat("a→b=ż")
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	gn := GenericNodeWithC6Dat{C6DAT: *encoded}
+	if decoded, err := gn.DecodeC6Dat(); err != nil || decoded != "a?b=ż" {
+		t.Errorf("unrepresentable characters should be replaced like in CMK: '%s' %s", decoded, err)
+	}
+}
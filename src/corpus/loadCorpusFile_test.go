@@ -7,8 +7,8 @@ import (
 	"testing"
 )
 
-var pathToE3DTestDataVertsion16 = filepath.Join("..", "tests", "testData", "E3D-version-16")
-var pathToE3DTestDataVertsion17 = filepath.Join("..", "tests", "testData", "E3D-version-17")
+var pathToE3DTestDataVertsion16 = filepath.Join("..", "..", "tests", "testData", "E3D-version-16")
+var pathToE3DTestDataVertsion17 = filepath.Join("..", "..", "tests", "testData", "E3D-version-17")
 var testFilesE3D = []string{
 	"simple.E3D",
 	"simple_macro_in_macro.E3D",
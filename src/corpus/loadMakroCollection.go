@@ -10,19 +10,33 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
-var MakroCollectionCache MakroCollection = MakroCollection{}
+// read by many goroutines when processing files in parallel, use Get/SetMakroCollectionCache
+var makroCollectionCache MakroCollection = MakroCollection{}
+var makroCollectionCacheMutex sync.RWMutex
 
 type MakroCollection []MakroCollectionItem
 
+// returned collection must not be modified, call SetMakroCollectionCache instead
+func GetMakroCollectionCache() MakroCollection {
+	makroCollectionCacheMutex.RLock()
+	defer makroCollectionCacheMutex.RUnlock()
+	return makroCollectionCache
+}
+
+func SetMakroCollectionCache(collection MakroCollection) {
+	makroCollectionCacheMutex.Lock()
+	defer makroCollectionCacheMutex.Unlock()
+	makroCollectionCache = collection
+}
+
 // best effort, returned path might not exist
-func GetMacroNameByFileName(makroSearchPath string, path string, cache *MakroCollection) string {
-	if cache != nil {
-		if out := cache.GetMacroNameByFileName(path); out != nil {
-			return *out
-		}
+func GetMacroNameByFileName(makroSearchPath string, path string, cache MakroCollection) string {
+	if out := cache.GetMacroNameByFileName(path); out != nil {
+		return *out
 	}
 	relPath, err := filepath.Rel(makroSearchPath, path)
 	if err != nil {
@@ -35,22 +49,19 @@ func GetMacroNameByFileName(makroSearchPath string, path string, cache *MakroCol
 	return filepath.Base(path)
 }
 
-func (mc *MakroCollection) GetMacroNameByFileName(path string) *string {
-	// if MakroCollectionCache == nil {
-	// 	return nil
-	// }
-	for _, mcc := range MakroCollectionCache {
+func (mc MakroCollection) GetMacroNameByFileName(path string) *string {
+	for _, mcc := range mc {
 		absCorpusPath, err1 := filepath.Abs(string(mcc.FileName))
 		absMakroPath, err2 := filepath.Abs(path)
-		if err1 != nil && err2 != nil && absCorpusPath == absMakroPath {
+		if err1 == nil && err2 == nil && absCorpusPath == absMakroPath {
 			tmp := string(mcc.Name)
 			return &tmp
 		}
 	}
 	return nil
 }
-func (mc *MakroCollection) GetMacroFileNameByName(name string) *string {
-	for _, mcc := range *mc {
+func (mc MakroCollection) GetMacroFileNameByName(name string) *string {
+	for _, mcc := range mc {
 		if mcc.Name == name {
 			tmp := string(mcc.FileName)
 			return &tmp
@@ -58,9 +69,9 @@ func (mc *MakroCollection) GetMacroFileNameByName(name string) *string {
 	}
 	return nil
 }
-func (mc *MakroCollection) GetMakroMappings() MakroMappings {
+func (mc MakroCollection) GetMakroMappings() MakroMappings {
 	out := MakroMappings{}
-	for _, mcc := range *mc {
+	for _, mcc := range mc {
 		out[mcc.Name] = mcc.FileName
 	}
 	return out
@@ -6,7 +6,7 @@ import (
 	"testing"
 )
 
-var pathToTestMakroCollection = filepath.Join("..", "tests", "makroCollection")
+var pathToTestMakroCollection = filepath.Join("..", "..", "tests", "makroCollection")
 var testFilesMakroCollection = []string{
 	"MakroCollectionMinimal.dat",
 	"MakroCollection2Items.dat",
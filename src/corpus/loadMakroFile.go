@@ -3,14 +3,12 @@ package corpus
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
-
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
 )
 
 type CMKUnknownMakroError struct {
@@ -25,7 +23,15 @@ const CMKLineSeparator = `,`
 
 var SectionRegex = regexp.MustCompile(`\[((\w+?)\d*)\]`)
 
-func appendM1Section(m *M1, currentSection string, currentSectionTextBuilder strings.Builder) {
+type CMKUnknownSectionError struct {
+	Name string
+}
+
+func (e *CMKUnknownSectionError) Error() string {
+	return fmt.Sprintf("unknown section name: '%s'", e.Name)
+}
+
+func appendM1Section(m *M1, currentSection string, currentSectionTextBuilder strings.Builder) error {
 	currentSectionText, _ := strings.CutSuffix(currentSectionTextBuilder.String(), CMKLineSeparator)
 	switch strings.ToLower(currentSection) {
 	case "":
@@ -52,8 +58,9 @@ func appendM1Section(m *M1, currentSection string, currentSectionTextBuilder str
 		embeddedMakro.EmbeddedMakroName = embeddedMakro.CalledWith()
 		m.Makro = append(m.Makro, embeddedMakro)
 	default:
-		log.Printf("ERROR: unknown section name: '%s', sectionText: %s\n", currentSection, currentSectionText)
+		return &CMKUnknownSectionError{Name: currentSection}
 	}
+	return nil
 }
 
 // dict["<makro name>"] = "<path to file>"
@@ -77,7 +84,7 @@ func NewMakroFromCMKFile(makroName *string, makroFile string, makroRootPath *str
 	}
 	makroFile, _ = filepath.Abs(makroFile)
 	if makroName == nil {
-		tmp := GetMacroNameByFileName(makroFile, makroFile, &MakroCollectionCache) // ugh refering to global var
+		tmp := GetMacroNameByFileName(makroFile, makroFile, GetMakroCollectionCache()) // ugh refering to global var
 		makroName = &tmp
 	}
 	initialMakro, err := partialNewMakroFromCMKFile(*makroName, makroFile)
@@ -176,36 +183,76 @@ func NewMakroFromCMKFile(makroName *string, makroFile string, makroRootPath *str
 // same as MakroFromFile but might have unresolved data in m.makro
 // makroMapping can come from Corpus settings (MakroCollection.dat)
 func partialNewMakroFromCMKFile(makroName string, makroFile string) (*M1, error) {
+	m, _, err := loadCMKFile(makroName, makroFile)
+	return m, err
+}
+
+// line of CMK file, Number starts from 1
+type CMKFileLine struct {
+	Number int
+	Text   string
+}
+
+// section of CMK file as it is written in file, used to report line numbers
+type CMKFileSection struct {
+	// "PILA1"
+	FullName string
+	// "PILA", empty for lines before first section
+	Name string
+	// line of "[PILA1]"
+	Line int
+	// empty lines are skipped
+	Lines []CMKFileLine
+	// for example CMKUnknownSectionError
+	Err error
+}
+
+// parser of partialNewMakroFromCMKFile, also returns sections of file with line numbers (M1 does not keep them), used by lint
+func loadCMKFile(makroName string, makroFile string) (*M1, []CMKFileSection, error) {
 	log.Printf("Reading makro: '%s'", makroFile)
 	file, err := os.Open(makroFile)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	stat, err := file.Stat()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if !stat.Mode().IsRegular() {
 		abs, err := filepath.Abs(makroFile)
 		if err == nil {
-			return nil, fmt.Errorf("path is not a file %s (%s)", abs, makroFile)
+			return nil, nil, fmt.Errorf("path is not a file %s (%s)", abs, makroFile)
 		} else {
-			return nil, fmt.Errorf("path is not a file %s", makroFile)
+			return nil, nil, fmt.Errorf("path is not a file %s", makroFile)
 		}
 	}
 	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return readCMK(makroName, data, EncodingAuto)
+}
 
+// reads content of CMK file, EncodingAuto detects encoding (see TextEncoding)
+func readCMK(makroName string, data []byte, encoding TextEncoding) (*M1, []CMKFileSection, error) {
 	m := new(M1)
 	m.MakroName = makroName
 
-	dec := transform.NewReader(file, charmap.Windows1250.NewDecoder())
-	scanner := bufio.NewScanner(dec)
+	text, detected, err := DecodeText(data, encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+	if encoding == EncodingAuto && detected != EncodingWindows1250 {
+		log.Printf("Makro '%s' is not encoded with Windows-1250, reading as %s", makroName, detected)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(text))
 	scanner.Split(bufio.ScanLines)
-	var currentSection string
-	var allSections map[string]int = make(map[string]int)
-	var currentSectionText strings.Builder
+	sections := []CMKFileSection{{}}
+	lineNumber := 0
 
 	for scanner.Scan() {
+		lineNumber++
 		text := scanner.Text()
 		if text == "" {
 			continue
@@ -213,42 +260,34 @@ func partialNewMakroFromCMKFile(makroName string, makroFile string) (*M1, error)
 		if strings.HasPrefix(text, "[") {
 			matched := SectionRegex.FindStringSubmatch(text)
 			if matched == nil {
-				return nil, fmt.Errorf("%s was parsed badly, was looking start of section name, got nil", text)
+				return nil, nil, fmt.Errorf("%s was parsed badly, was looking start of section name, got nil", text)
 			}
-			fullSectionName, sectionName := matched[1], matched[2]
-			allSections[fullSectionName]++
-			appendM1Section(m, currentSection, currentSectionText)
-			currentSectionText.Reset()
-			currentSection = sectionName
+			sections = append(sections, CMKFileSection{FullName: matched[1], Name: matched[2], Line: lineNumber})
 		} else {
-			currentSectionText.WriteString(encodeCMKLine(text))
+			current := &sections[len(sections)-1]
+			current.Lines = append(current.Lines, CMKFileLine{Number: lineNumber, Text: text})
 		}
 	}
-	if currentSectionText.Len() != 0 {
-		appendM1Section(m, currentSection, currentSectionText)
+	for i := range sections {
+		// last section can be empty too, for example [FORMULE] with no lines
+		var sectionText strings.Builder
+		for _, line := range sections[i].Lines {
+			sectionText.WriteString(encodeCMKLine(line.Text))
+		}
+		if err := appendM1Section(m, sections[i].Name, sectionText); err != nil {
+			log.Printf("ERROR: %s, sectionText: %s\n", err, sectionText.String())
+			sections[i].Err = err
+		}
 	}
-	// m.Varijable = append(m.MSFO)
-	return m, nil
+	return m, sections, nil
 }
 
+// line of DAT with separator, see EncodeDAT
 func encodeCMKLine(text string) string {
-	if strings.Contains(text, " ") || strings.Contains(text, "\t") {
-		return `"` + text + `"` + CMKLineSeparator
-	}
-	return text + CMKLineSeparator
-}
-
-func decodeCMKLine(line string) string {
-	lineTrimmed := strings.TrimSpace(line)
-	lineTrimmed, _ = strings.CutPrefix(lineTrimmed, `"`)
-	lineTrimmed, _ = strings.CutSuffix(lineTrimmed, `"`)
-	return lineTrimmed
+	return quoteDATLine(text) + CMKLineSeparator
 }
 
+// see DecodeDAT
 func DecodeAllCMKLines(DAT string) []string {
-	lines := []string{}
-	for _, line := range strings.Split(DAT, CMKLineSeparator) {
-		lines = append(lines, decodeCMKLine(line))
-	}
-	return lines
+	return DecodeDAT(DAT)
 }
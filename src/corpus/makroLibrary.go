@@ -0,0 +1,72 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// current versions of makros from Makro dir, every makro is read once when it is needed
+type MakroLibrary struct {
+	// usually "C:\Tri D Corpus\Corpus 5.0\Makro"
+	RootPath string
+	// from MakroCollection.dat, can be nil
+	Mappings MakroMappings
+	// Get can be called from many goroutines
+	mutex  sync.Mutex
+	makros map[string]*M1
+	errors map[string]error
+}
+
+func NewMakroLibrary(rootPath string, collection MakroCollection) *MakroLibrary {
+	var mappings MakroMappings
+	if collection != nil {
+		mappings = collection.GetMakroMappings()
+	}
+	return &MakroLibrary{RootPath: rootPath, Mappings: mappings, makros: map[string]*M1{}, errors: map[string]error{}}
+}
+
+// path of CMK file: from MakroCollection.dat, <root>/<name>.CMK or searched in root. Name can contain folder: "folder/makro"
+func (l *MakroLibrary) MakroFile(name string) (string, error) {
+	if relative, found := l.Mappings[name]; found {
+		return filepath.Join(l.RootPath, relative), nil
+	}
+	makroFile, err := MakroFilePath(l.RootPath, name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(makroFile); err == nil {
+		return makroFile, nil
+	}
+	foundFile, err := FindFile(l.RootPath, filepath.Base(strings.ReplaceAll(name, `\`, "/"))+".CMK")
+	if err != nil {
+		return "", &CMKUnknownMakroError{Name: name}
+	}
+	return foundFile, nil
+}
+
+// makro with resolved submakros. Returned makro is shared, use Copy before modifying it
+func (l *MakroLibrary) Get(name string) (*M1, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if makro, found := l.makros[name]; found {
+		return makro, nil
+	}
+	if err, found := l.errors[name]; found {
+		return nil, err
+	}
+	makro, err := func() (*M1, error) {
+		makroFile, err := l.MakroFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return NewMakroFromCMKFile(&name, makroFile, &l.RootPath, l.Mappings)
+	}()
+	if err != nil {
+		l.errors[name] = err
+		return nil, err
+	}
+	l.makros[name] = makro
+	return makro, nil
+}
@@ -0,0 +1,288 @@
+package corpus
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// old versions of Makro dir, every subdirectory is one version: snapshots/2024-01/, snapshots/2024-06/
+type MakroSnapshots struct {
+	// names of versions, newest (last in alphabetical order) first
+	Versions  []string
+	libraries []*MakroLibrary
+}
+
+// every subdirectory of dir is a version, if there are no subdirectories dir itself is the only version
+func NewMakroSnapshots(dir string) (*MakroSnapshots, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := &MakroSnapshots{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			snapshots.Versions = append(snapshots.Versions, entry.Name())
+		}
+	}
+	slices.Sort(snapshots.Versions)
+	slices.Reverse(snapshots.Versions)
+	for _, version := range snapshots.Versions {
+		snapshots.libraries = append(snapshots.libraries, NewMakroLibrary(filepath.Join(dir, version), nil))
+	}
+	if len(snapshots.Versions) == 0 {
+		snapshots.Versions = []string{filepath.Base(dir)}
+		snapshots.libraries = []*MakroLibrary{NewMakroLibrary(dir, nil)}
+	}
+	return snapshots, nil
+}
+
+/*
+Version of library makro that embedded makro was created from. Candidates are versions with the same sections as embedded makro
+(see DiffMakroSections), versions that differ only in default values are told apart by number of [VARIJABLE] and [JOINT] values
+that are the same as in embedded makro (customized values match no version). The newest of the best candidates is returned.
+Returns nil if no version matches. Returned makro is shared, do not modify it.
+*/
+func (s *MakroSnapshots) FindBase(name string, embedded *M1) (*M1, string) {
+	if s == nil {
+		return nil, ""
+	}
+	var found *M1
+	foundVersion, foundMatching := "", -1
+	for i, library := range s.libraries {
+		base, err := library.Get(name)
+		if err != nil {
+			continue
+		}
+		if len(DiffMakroSections(embedded, base)) != 0 {
+			continue
+		}
+		matching := matchingValues(embedded.Varijable.DAT, base.Varijable.DAT)
+		if embedded.Joint != nil && base.Joint != nil {
+			matching += matchingValues(embedded.Joint.DAT, base.Joint.DAT)
+		}
+		if matching > foundMatching {
+			found, foundVersion, foundMatching = base, s.Versions[i], matching
+		}
+	}
+	return found, foundVersion
+}
+
+// number of keys of embeddedDAT with the same value in baseDAT
+func matchingValues(embeddedDAT string, baseDAT string) int {
+	embeddedKeys, embeddedValues, _ := loadValuesFromSection(embeddedDAT)
+	baseKeys, baseValues, _ := loadValuesFromSection(baseDAT)
+	matching := 0
+	for _, key := range embeddedKeys {
+		if baseKey, found := CMKFindName(baseKeys, key, nil); found && baseValues[baseKey] == embeddedValues[key] {
+			matching++
+		}
+	}
+	return matching
+}
+
+/*
+Three-way merge of section with "key=value" lines, used for [JOINT]. Key is customized if old value is different from base:
+  - not customized: value from new is taken (also deleted if it is not in new)
+  - customized, not changed in new: old value is kept
+  - customized and changed in new: conflict, old value is kept
+
+Keys only in old are kept. Rules of options.Policy for section override it. Returns DAT in order of new, kept keys are appended (see options.Order).
+*/
+func mergeSection(logger *log.Logger, makroName string, section string, baseDAT string, oldDAT string, newDAT string, options MergeOptions) (string, []Change) {
+	baseKeys, baseValues, _ := loadValuesFromSection(baseDAT)
+	oldKeys, oldValues, oldComments := loadValuesFromSection(oldDAT)
+	newKeys, newValues, newComments := loadValuesFromSection(newDAT)
+	changes := []Change{}
+	var output strings.Builder
+	write := func(name string, value string, comments []string) {
+		output.WriteString(encodeCMKLine(name + "=" + value))
+		for _, comment := range comments {
+			output.WriteString(encodeCMKLine(comment))
+		}
+	}
+	for _, line := range newComments[InitialMacroKey] {
+		output.WriteString(encodeCMKLine(line))
+	}
+	for _, newName := range newKeys {
+		oldName, oldExists := CMKFindName(oldKeys, newName, nil)
+		baseName, baseExists := CMKFindName(baseKeys, newName, nil)
+		oldValue, newValue, baseValue := oldValues[oldName], newValues[newName], baseValues[baseName]
+		change := Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Section: section}
+		switch rule := options.Policy.Find(makroName, section, newName); {
+		case rule != nil:
+			var name, value string
+			var keep bool
+			name, value, keep, change = applyPolicyRule(logger, rule, oldName, newName, oldValue, newValue, oldExists, options)
+			change.Section = section
+			if keep && name == oldName {
+				write(name, value, oldComments[oldName])
+			} else if keep {
+				write(name, value, newComments[newName])
+			}
+		case !oldExists && baseExists:
+			// removed from old on purpose
+			change.Result = ValueDeleted
+			logger.Printf("  [%s] Not added value deleted from old: '%s=%s'\n", section, newName, newValue)
+		case !oldExists:
+			change.Result = ValueAdded
+			write(newName, newValue, newComments[newName])
+			logger.Printf("  [%s] Added value: '%s=%s'\n", section, newName, newValue)
+		case oldValue == newValue:
+			change.Result = ValueSame
+			write(newName, newValue, newComments[newName])
+		case baseExists && oldValue == baseValue:
+			change.Result = ValueUpdatedFromNew
+			write(newName, newValue, newComments[newName])
+			logger.Printf("  [%s] Updated value: '%s=%s' (old value was not customized: '%s')\n", section, newName, newValue, oldValue)
+		case baseExists && newValue != baseValue:
+			change.Result = ValueConflict
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Conflict, copied old value: '%s=%s' (base value: '%s', new value: '%s')\n", section, oldName, oldValue, baseValue, newValue)
+		default:
+			change.Result = ValueChanged
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Copied old value: '%s=%s'\n", section, oldName, oldValue)
+		}
+		changes = append(changes, change)
+	}
+	for _, oldName := range oldKeys {
+		if _, found := CMKFindName(newKeys, oldName, nil); found {
+			continue
+		}
+		baseName, baseExists := CMKFindName(baseKeys, oldName, nil)
+		oldValue, baseValue := oldValues[oldName], baseValues[baseName]
+		rule := options.Policy.Find(makroName, section, oldName)
+		change := Change{OldName: &oldName, NewName: &oldName, OldValue: oldValue, Section: section, Rule: rule}
+		switch {
+		case rule != nil && (rule.Action == PolicyDrop || rule.Action == PolicyTakeNew):
+			change.Result = ValueDeleted
+			logger.Printf("  [%s] Deleted value: '%s=%s' (rule %s)\n", section, oldName, oldValue, rule)
+		case rule != nil:
+			change.Result = ValueKept
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Kept value that is not in new: '%s=%s' (rule %s)\n", section, oldName, oldValue, rule)
+		case baseExists && oldValue == baseValue:
+			change.Result = ValueDeleted
+			logger.Printf("  [%s] Deleted value: '%s=%s'\n", section, oldName, oldValue)
+		case baseExists:
+			change.Result = ValueConflict
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Conflict, copied old value deleted from new: '%s=%s' (base value: '%s')\n", section, oldName, oldValue, baseValue)
+		default:
+			change.Result = ValueKept
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Copied old value that is not in new: '%s=%s'\n", section, oldName, oldValue)
+		}
+		changes = append(changes, change)
+	}
+	dat, _ := strings.CutSuffix(output.String(), CMKLineSeparator)
+	if options.Order == OrderOld {
+		dat = orderLikeOld(oldDAT, dat, nil)
+	}
+	return dat, changes
+}
+
+func sectionDAT(section *GenericNodeWithDat) string {
+	if section == nil {
+		return ""
+	}
+	return section.DAT
+}
+
+/*
+Three-way merge of old makro with new makro, base is version of new makro that old makro was created from. Modifies macroToBeChanged in place.
+
+  - [VARIJABLE]: variable that was not customized (old value == base value) takes new value and name,
+    customized variable that was also changed in new makro is a conflict and keeps old value (see GlobalStrategy for "_" prefix),
+    variable that is not in base is merged like in UpdateMakro, variable matching rule of options.Policy is merged according to rule
+  - [JOINT]: merged per key, see mergeSection
+  - [MAKRO]: call parameters are merged two-way like in UpdateMakro, see updateSubmakroCalls
+
+If base is nil it is the same as UpdateMakro.
+*/
+func MergeMakro(base *M1, oldMacro *M1, macroToBeChanged *M1, renameTo *string, options MergeOptions) []Change {
+	return mergeMakro(log.Default(), base, oldMacro, macroToBeChanged, renameTo, options)
+}
+
+func mergeMakro(logger *log.Logger, base *M1, oldMacro *M1, macroToBeChanged *M1, renameTo *string, options MergeOptions) []Change {
+	if base == nil {
+		return updateMakro(logger, oldMacro, macroToBeChanged, renameTo, options)
+	}
+	baseKeys, baseValues, _ := loadValuesFromSection(base.Varijable.DAT)
+	oldKeys, oldValues, _ := loadValuesFromSection(oldMacro.Varijable.DAT)
+	newKeys, newValues, newComments := loadValuesFromSection(macroToBeChanged.Varijable.DAT)
+	renames := options.VariableRenames.ForMakro(oldMacro.MakroName)
+	reversedRenames := renames.Reverse()
+
+	var outputVarijable strings.Builder
+	for _, line := range newComments[InitialMacroKey] {
+		outputVarijable.WriteString(encodeCMKLine(line))
+	}
+	changes := []Change{}
+	logger.Println("Merging [VARIJABLE] (three-way)")
+	for _, newName := range newKeys {
+		oldName, oldExists := CMKFindName(oldKeys, newName, reversedRenames)
+		baseName, baseExists := CMKFindName(baseKeys, newName, reversedRenames)
+		oldValue, newValue, baseValue := oldValues[oldName], newValues[newName], baseValues[baseName]
+		name, value, write := newName, newValue, true
+		var change Change
+		switch rule := options.Policy.Find(oldMacro.MakroName, "VARIJABLE", newName); {
+		case rule != nil:
+			name, value, write, change = applyPolicyRule(logger, rule, oldName, newName, oldValue, newValue, oldExists, options)
+		case !oldExists:
+			logger.Printf("  Added value: '%s=%s'\n", newName, newValue)
+			change = Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueAdded}
+		case baseExists && oldValue == baseValue && oldName == baseName:
+			change = Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueSame}
+			if oldValue != newValue || oldName != newName {
+				change.Result = ValueUpdatedFromNew
+				logger.Printf("  Updated value: '%s=%s' (old value was not customized: '%s=%s')\n", newName, newValue, oldName, oldValue)
+			}
+		default:
+			name, change = keepOldValue(logger, oldName, newName, oldValue, newValue, options)
+			value = oldValue
+			if baseExists && oldValue != newValue && newValue != baseValue {
+				change.Result = ValueConflict
+				logger.Printf("  Conflict, copied old value: '%s=%s' (base value: '%s', new value: '%s')\n", name, oldValue, baseValue, newValue)
+			}
+		}
+		changes = append(changes, change)
+		if !write {
+			continue
+		}
+		outputVarijable.WriteString(encodeCMKLine(name + "=" + value))
+		for _, comment := range newComments[newName] {
+			outputVarijable.WriteString(encodeCMKLine(comment))
+		}
+	}
+	for _, oldName := range oldKeys {
+		if _, found := CMKFindName(newKeys, oldName, renames); found {
+			continue
+		}
+		write, change := applyPolicyRuleToDeleted(logger, options.Policy.Find(oldMacro.MakroName, "VARIJABLE", oldName), oldName, oldValues[oldName])
+		changes = append(changes, change)
+		if write {
+			outputVarijable.WriteString(encodeCMKLine(oldName + "=" + oldValues[oldName]))
+		}
+	}
+	for i := range changes {
+		changes[i].Section = "VARIJABLE"
+	}
+
+	logger.Println("Merging [JOINT] (three-way)")
+	jointDAT, jointChanges := mergeSection(logger, oldMacro.MakroName, "JOINT", sectionDAT(base.Joint), sectionDAT(oldMacro.Joint), sectionDAT(macroToBeChanged.Joint), options)
+	changes = append(changes, jointChanges...)
+
+	if renameTo != nil {
+		macroToBeChanged.MakroName = *renameTo
+	}
+	macroToBeChanged.Varijable.DAT, _ = strings.CutSuffix(outputVarijable.String(), CMKLineSeparator)
+	if options.Order == OrderOld {
+		macroToBeChanged.Varijable.DAT = orderLikeOld(oldMacro.Varijable.DAT, macroToBeChanged.Varijable.DAT, renames)
+	}
+	setJointDAT(oldMacro, macroToBeChanged, jointDAT)
+	return append(changes, updateSubmakroCalls(logger, oldMacro, macroToBeChanged, options)...)
+}
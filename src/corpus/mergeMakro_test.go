@@ -0,0 +1,181 @@
+package corpus
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeMakroVarijable(t *testing.T) {
+	base := &M1{Varijable: GenericNodeWithDat{DAT: "default=1,customized=1,both=1,same=1,deleted=1,grubosc=18,lokalna=18"}}
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "default=1,customized=5,both=5,same=1,deleted=1,grubosc=18,lokalna=32"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "default=2,customized=1,both=3,same=1,added=0,_grubosc=18,_lokalna=18"}}
+	changes := mergeMakro(log.New(io.Discard, "", 0), base, oldMakro, newMakro, nil, MergeOptions{GlobalStrategy: KeepLocal})
+
+	expected := "default=2,customized=5,both=5,same=1,added=0,_grubosc=18,lokalna=32"
+	if newMakro.Varijable.DAT != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, newMakro.Varijable.DAT)
+	}
+	results := map[string]UpdateResult{}
+	for _, change := range changes {
+		if change.Section != "VARIJABLE" {
+			t.Errorf("wrong section: %s", change.Section)
+		}
+		results[*change.OldName] = change.Result
+	}
+	expectedResults := map[string]UpdateResult{
+		"default":    ValueUpdatedFromNew,
+		"customized": ValueChanged,
+		"both":       ValueConflict,
+		"same":       ValueSame,
+		"deleted":    ValueDeleted,
+		"grubosc":    ValueUpdatedFromNew,
+		"lokalna":    ValueChangedRemainedToLocal,
+	}
+	for name, result := range expectedResults {
+		if results[name] != result {
+			t.Errorf("'%s': expected %s, got %s", name, result, results[name])
+		}
+	}
+}
+
+func TestMergeMakroJoint(t *testing.T) {
+	base := &M1{Joint: &GenericNodeWithDat{DAT: "CONNECT=23,mindistance=-14,maxdistance=10,removed=1,customizedRemoved=1"}}
+	oldMakro := &M1{Joint: &GenericNodeWithDat{DAT: "CONNECT=23,mindistance=-20,maxdistance=5,removed=1,customizedRemoved=2,user=7"}}
+	newMakro := &M1{Joint: &GenericNodeWithDat{DAT: "CONNECT=24,mindistance=-14,maxdistance=8,added=3"}}
+	changes := mergeMakro(log.New(io.Discard, "", 0), base, oldMakro, newMakro, nil, MergeOptions{})
+
+	expected := "CONNECT=24,mindistance=-20,maxdistance=5,added=3,customizedRemoved=2,user=7"
+	if newMakro.Joint == nil || newMakro.Joint.DAT != expected {
+		t.Errorf("expected:\n%s\ngot:\n%+v", expected, newMakro.Joint)
+	}
+	results := map[string]UpdateResult{}
+	for _, change := range changes {
+		if change.Section == "JOINT" {
+			results[*change.NewName] = change.Result
+		}
+	}
+	expectedResults := map[string]UpdateResult{
+		"CONNECT":           ValueUpdatedFromNew,
+		"mindistance":       ValueChanged,
+		"maxdistance":       ValueConflict,
+		"added":             ValueAdded,
+		"removed":           ValueDeleted,
+		"customizedRemoved": ValueConflict,
+		"user":              ValueKept,
+	}
+	for name, result := range expectedResults {
+		if results[name] != result {
+			t.Errorf("'%s': expected %s, got %s", name, result, results[name])
+		}
+	}
+}
+
+func TestMergeMakroWithoutBase(t *testing.T) {
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=5"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=1"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=2"}}
+	changes := mergeMakro(log.New(io.Discard, "", 0), nil, oldMakro, newMakro, nil, MergeOptions{})
+	if newMakro.Varijable.DAT != "a=5" || newMakro.Joint.DAT != "CONNECT=1" {
+		t.Errorf("without base old values should be kept: %s, %s", newMakro.Varijable.DAT, newMakro.Joint.DAT)
+	}
+	if len(changes) != 2 || changes[0].Result != ValueChanged || changes[1].Result != ValueChanged || changes[1].Section != "JOINT" {
+		t.Errorf("wrong changes: %+v", changes)
+	}
+}
+
+func TestMakroSnapshotsFindBase(t *testing.T) {
+	dir := t.TempDir()
+	write := func(version string, formule string) {
+		os.MkdirAll(filepath.Join(dir, version), os.ModePerm)
+		makro := &M1{Varijable: GenericNodeWithDat{DAT: "a=" + version}, Formule: &GenericNodeWithDat{DAT: formule}}
+		if err := makro.SaveToFile(filepath.Join(dir, version, "gorny.CMK"), ""); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+	write("2024-01", "x=1")
+	write("2024-06", "x=2")
+	write("2025-01", "x=1")
+	snapshots, err := NewMakroSnapshots(dir)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	embedded := &M1{Varijable: GenericNodeWithDat{DAT: "a=5"}, Formule: &GenericNodeWithDat{DAT: "x=1"}}
+	base, version := snapshots.FindBase("gorny", embedded)
+	if base == nil || version != "2025-01" {
+		t.Errorf("newest matching version should be found: %s", version)
+	}
+	embedded.Formule.DAT = "x=3"
+	if base, _ := snapshots.FindBase("gorny", embedded); base != nil {
+		t.Errorf("base should not be found: %+v", base)
+	}
+	if base, _ := snapshots.FindBase("missing", embedded); base != nil {
+		t.Errorf("base should not be found: %+v", base)
+	}
+}
+
+func TestMakroSnapshotsFindBaseByValues(t *testing.T) {
+	dir := t.TempDir()
+	for version, varijable := range map[string]string{"2024-01": "A=1,B=1", "2025-01": "A=2,B=1"} {
+		os.MkdirAll(filepath.Join(dir, version), os.ModePerm)
+		makro := &M1{Varijable: GenericNodeWithDat{DAT: varijable}, Formule: &GenericNodeWithDat{DAT: "x=1"}}
+		if err := makro.SaveToFile(filepath.Join(dir, version, "gorny.CMK"), ""); err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+	}
+	snapshots, err := NewMakroSnapshots(dir)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// versions differ only in [VARIJABLE], the one with the same defaults is base
+	embedded := &M1{MakroName: "gorny", Varijable: GenericNodeWithDat{DAT: "A=1,B=5"}, Formule: &GenericNodeWithDat{DAT: "x=1"}}
+	base, version := snapshots.FindBase("gorny", embedded)
+	if base == nil || version != "2024-01" {
+		t.Errorf("version with the same values should be found: %s", version)
+		t.FailNow()
+	}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "A=3,B=1"}, Formule: &GenericNodeWithDat{DAT: "x=1"}}
+	changes := mergeMakro(log.New(io.Discard, "", 0), base, embedded, newMakro, nil, MergeOptions{})
+	if newMakro.Varijable.DAT != "A=3,B=5" || len(changes) != 2 || changes[0].Result != ValueUpdatedFromNew {
+		t.Errorf("not customized value should be updated: %s %+v", newMakro.Varijable.DAT, changes)
+	}
+	// all values customized, newest version is taken
+	embedded.Varijable.DAT = "A=7,B=7"
+	if _, version := snapshots.FindBase("gorny", embedded); version != "2025-01" {
+		t.Errorf("newest version should be found: %s", version)
+	}
+}
+
+func TestMakroSnapshotsFindBaseCustomizedCall(t *testing.T) {
+	dir := t.TempDir()
+	makro := &M1{Formule: &GenericNodeWithDat{DAT: "x=1"}, Makro: []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "J=0,NAME=zawiasy,LACZ_BLENDA="}}}}
+	os.MkdirAll(filepath.Join(dir, "2024-01"), os.ModePerm)
+	if err := makro.SaveToFile(filepath.Join(dir, "2024-01", "gorny.CMK"), ""); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	os.WriteFile(filepath.Join(dir, "2024-01", "zawiasy.CMK"), []byte("[VARIJABLE]\r\nLACZ_BLENDA=0\r\n"), 0644)
+	snapshots, err := NewMakroSnapshots(dir)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// values of call parameters are set in project
+	embedded := &M1{Formule: &GenericNodeWithDat{DAT: "x=1"}, Makro: []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "J=0,NAME=Zawiasy,LACZ_BLENDA=1"}}}}
+	if base, version := snapshots.FindBase("gorny", embedded); base == nil || version != "2024-01" {
+		t.Errorf("customized call parameter should not prevent finding base")
+	}
+	embedded.Makro[0].DAT = "J=0,NAME=zawiasy,LACZ_BLENDA=1,INNY=2"
+	if base, _ := snapshots.FindBase("gorny", embedded); base != nil {
+		t.Errorf("call with different parameters should not match")
+	}
+	embedded.Makro[0].DAT = "J=0,NAME=nogi,LACZ_BLENDA="
+	if base, _ := snapshots.FindBase("gorny", embedded); base != nil {
+		t.Errorf("call of different submakro should not match")
+	}
+}
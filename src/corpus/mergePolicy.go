@@ -0,0 +1,196 @@
+package corpus
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type PolicyAction int
+
+const (
+	// old value is kept, variable is never converted to global and is kept even if it was deleted from new makro
+	PolicyKeepOld PolicyAction = iota
+	// value from new makro is always taken
+	PolicyTakeNew
+	// variable is removed from output
+	PolicyDrop
+	// old value is kept, variable that is not in old makro is not added
+	PolicyKeepIfExists
+)
+
+func (a PolicyAction) String() string {
+	name, found := policyActionNames[a]
+	if !found {
+		return fmt.Sprintf("PolicyAction(%d)", int(a))
+	}
+	return name
+}
+
+var policyActionNames = map[PolicyAction]string{
+	PolicyKeepOld:      "keep-old",
+	PolicyTakeNew:      "take-new",
+	PolicyDrop:         "drop",
+	PolicyKeepIfExists: "keep-if-exists",
+}
+
+func (a PolicyAction) MarshalText() ([]byte, error) {
+	name, found := policyActionNames[a]
+	if !found {
+		return nil, fmt.Errorf("unknown PolicyAction: %d", int(a))
+	}
+	return []byte(name), nil
+}
+
+func (a *PolicyAction) UnmarshalText(text []byte) error {
+	for action, name := range policyActionNames {
+		if strings.EqualFold(name, string(text)) {
+			*a = action
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action: '%s', use one of: keep-old, take-new, drop, keep-if-exists", text)
+}
+
+type PolicyRule struct {
+	// makro name, glob: "Zawiasy", "Szuflad*", "*"
+	Makro string `json:"makro" yaml:"makro" toml:"makro"`
+	// "VARIJABLE" (default when empty), "JOINT" or "MAKRO" (call parameters of submakro, Makro is name of called submakro)
+	Section string `json:"section,omitempty" yaml:"section,omitempty" toml:"section,omitempty"`
+	// variable name, glob: "NUMER_NARZEDZIA*". Not case sensitive and "_" prefix is ignored like in CMKFindName
+	Variable string       `json:"variable" yaml:"variable" toml:"variable"`
+	Action   PolicyAction `json:"action" yaml:"action" toml:"action"`
+}
+
+func (r PolicyRule) String() string {
+	if r.Section != "" && !strings.EqualFold(r.Section, "VARIJABLE") {
+		return fmt.Sprintf("%s/[%s]%s: %s", r.Makro, strings.ToUpper(r.Section), r.Variable, r.Action)
+	}
+	return fmt.Sprintf("%s/%s: %s", r.Makro, r.Variable, r.Action)
+}
+
+func (r PolicyRule) Matches(makroName string, section string, variableName string) bool {
+	if !strings.EqualFold(cmp.Or(r.Section, "VARIJABLE"), section) {
+		return false
+	}
+	makroMatches, _ := path.Match(strings.ToLower(r.Makro), strings.ToLower(makroName))
+	variableMatches, _ := path.Match(normalizeVariableName(r.Variable), normalizeVariableName(variableName))
+	return makroMatches && variableMatches
+}
+
+/*
+Per-variable rules used by UpdateMakro and MergeMakro, first matching rule wins. File can be JSON, YAML or TOML:
+
+	rules:
+	  - makro: "*"
+	    variable: NUMER_NARZEDZIA*
+	    action: take-new
+	  - makro: Zawiasy
+	    variable: PODAJ_GRUBOSC_PLYTY
+	    action: keep-old
+	  - makro: "*"
+	    section: JOINT
+	    variable: CONNECT
+	    action: take-new
+*/
+type MergePolicy struct {
+	Rules []PolicyRule `json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// format is picked by extension: .json, .yaml/.yml or .toml
+func LoadMergePolicy(policyPath string) (*MergePolicy, error) {
+	data, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("can not read policy file: %w", err)
+	}
+	policy := &MergePolicy{}
+	switch strings.ToLower(filepath.Ext(policyPath)) {
+	case ".json":
+		err = json.Unmarshal(data, policy)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, policy)
+	case ".toml":
+		err = toml.Unmarshal(data, policy)
+	default:
+		return nil, fmt.Errorf("unsupported policy format: '%s', use .json, .yaml or .toml", policyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file '%s': %w", policyPath, err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Makro == "" || rule.Variable == "" {
+			return nil, fmt.Errorf("invalid policy file '%s': rule %d needs makro and variable", policyPath, i+1)
+		}
+		if section := strings.ToUpper(rule.Section); section != "" && section != "VARIJABLE" && section != "JOINT" && section != "MAKRO" {
+			return nil, fmt.Errorf("invalid policy file '%s': rule %d: unsupported section '%s', use VARIJABLE, JOINT or MAKRO", policyPath, i+1, rule.Section)
+		}
+		if _, err := path.Match(rule.Makro, ""); err != nil {
+			return nil, fmt.Errorf("invalid policy file '%s': rule %d: %w", policyPath, i+1, err)
+		}
+		if _, err := path.Match(rule.Variable, ""); err != nil {
+			return nil, fmt.Errorf("invalid policy file '%s': rule %d: %w", policyPath, i+1, err)
+		}
+	}
+	return policy, nil
+}
+
+// first rule matching variable (or key) in section of makro, nil if there is none. Policy can be nil
+func (p *MergePolicy) Find(makroName string, section string, variableName string) *PolicyRule {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Rules {
+		if p.Rules[i].Matches(makroName, section, variableName) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// variable of new makro that matches rule. Returns name and value to write, write is false if variable is dropped
+func applyPolicyRule(logger *log.Logger, rule *PolicyRule, oldName string, newName string, oldValue string, newValue string, oldExists bool, options MergeOptions) (name string, value string, write bool, change Change) {
+	change = Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Rule: rule}
+	switch {
+	case rule.Action == PolicyDrop || (rule.Action == PolicyKeepIfExists && !oldExists):
+		logger.Printf("  Dropped value: '%s=%s' (rule %s)\n", newName, newValue, rule)
+		change.Result = ValueDeleted
+		return "", "", false, change
+	case !oldExists:
+		logger.Printf("  Added value: '%s=%s' (rule %s)\n", newName, newValue, rule)
+		change.Result = ValueAdded
+		return newName, newValue, true, change
+	case rule.Action == PolicyTakeNew:
+		change.Result = ValueSame
+		if oldValue != newValue || oldName != newName {
+			change.Result = ValueUpdatedFromNew
+			logger.Printf("  Updated value: '%s=%s' (rule %s, old value: '%s=%s')\n", newName, newValue, rule, oldName, oldValue)
+		}
+		return newName, newValue, true, change
+	}
+	if rule.Action == PolicyKeepOld {
+		options.GlobalStrategy = KeepLocal
+	}
+	name, change = keepOldValue(logger, oldName, newName, oldValue, newValue, options)
+	change.Rule = rule
+	return name, oldValue, true, change
+}
+
+// variable that is only in old makro. Returns true if it should be written to output
+func applyPolicyRuleToDeleted(logger *log.Logger, rule *PolicyRule, oldName string, oldValue string) (bool, Change) {
+	change := Change{OldName: &oldName, NewName: &oldName, OldValue: oldValue, Result: ValueDeleted, Rule: rule}
+	if rule != nil && rule.Action == PolicyKeepOld {
+		logger.Printf("  Kept value deleted from new: '%s=%s' (rule %s)\n", oldName, oldValue, rule)
+		change.Result = ValueKept
+		return true, change
+	}
+	logger.Printf("  Deleted value: '%s=%s'\n", oldName, oldValue)
+	return false, change
+}
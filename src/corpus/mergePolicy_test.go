@@ -0,0 +1,81 @@
+package corpus
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateMakroPolicy(t *testing.T) {
+	policy := &MergePolicy{Rules: []PolicyRule{
+		{Makro: "*", Variable: "NUMER_NARZEDZIA*", Action: PolicyTakeNew},
+		{Makro: "Zawiasy", Variable: "PODAJ_GRUBOSC_PLYTY", Action: PolicyKeepOld},
+		{Makro: "Zawias*", Variable: "stara", Action: PolicyDrop},
+		{Makro: "*", Variable: "opcja", Action: PolicyKeepIfExists},
+		{Makro: "*", Variable: "usunieta", Action: PolicyKeepOld},
+	}}
+	oldMakro := &M1{MakroName: "Zawiasy", Varijable: GenericNodeWithDat{DAT: "numer_narzedzia_dno=5,PODAJ_GRUBOSC_PLYTY=32,stara=1,inna=7,usunieta=3"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "NUMER_NARZEDZIA_DNO=8,_PODAJ_GRUBOSC_PLYTY=18,stara=2,inna=9,opcja=1"}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{Policy: policy, GlobalStrategy: Always})
+	expected := "NUMER_NARZEDZIA_DNO=8,PODAJ_GRUBOSC_PLYTY=32,inna=7,usunieta=3"
+	if newMakro.Varijable.DAT != expected {
+		t.Errorf("expected '%s', got '%s'", expected, newMakro.Varijable.DAT)
+	}
+	expectedResults := []UpdateResult{ValueUpdatedFromNew, ValueChangedRemainedToLocal, ValueDeleted, ValueChanged, ValueDeleted, ValueKept}
+	expectedRules := []*PolicyRule{&policy.Rules[0], &policy.Rules[1], &policy.Rules[2], nil, &policy.Rules[3], &policy.Rules[4]}
+	if len(changes) != len(expectedResults) {
+		t.Fatalf("wrong number of changes: %d", len(changes))
+	}
+	for i, change := range changes {
+		if change.Result != expectedResults[i] {
+			t.Errorf("%s: expected result %s, got %s", *change.OldName, expectedResults[i], change.Result)
+		}
+		if change.Rule != expectedRules[i] {
+			t.Errorf("%s: expected rule %v, got %v", *change.OldName, expectedRules[i], change.Rule)
+		}
+	}
+}
+
+func TestLoadMergePolicy(t *testing.T) {
+	files := map[string]string{
+		"policy.json": `{"rules": [{"makro": "*", "variable": "NUMER_NARZEDZIA*", "action": "take-new"}, {"makro": "Zawiasy", "variable": "x", "action": "keep-if-exists"}]}`,
+		"policy.yaml": "rules:\n  - makro: \"*\"\n    variable: NUMER_NARZEDZIA*\n    action: take-new\n  - makro: Zawiasy\n    variable: x\n    action: keep-if-exists\n",
+		"policy.toml": "[[rules]]\nmakro = \"*\"\nvariable = \"NUMER_NARZEDZIA*\"\naction = \"take-new\"\n\n[[rules]]\nmakro = \"Zawiasy\"\nvariable = \"x\"\naction = \"keep-if-exists\"\n",
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			os.WriteFile(path, []byte(content), 0644)
+			policy, err := LoadMergePolicy(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(policy.Rules) != 2 || policy.Rules[0].Action != PolicyTakeNew || policy.Rules[1].Action != PolicyKeepIfExists {
+				t.Errorf("wrong rules: %v", policy.Rules)
+			}
+			if rule := policy.Find("zawiasy", "VARIJABLE", "_X"); rule != &policy.Rules[1] {
+				t.Errorf("rule should match case insensitive name: %v", rule)
+			}
+			if rule := policy.Find("Szuflady", "VARIJABLE", "x"); rule != nil {
+				t.Errorf("rule of other makro should not match: %v", rule)
+			}
+		})
+	}
+	invalid := map[string]string{
+		"action.json":  `{"rules": [{"makro": "*", "variable": "x", "action": "keep"}]}`,
+		"empty.json":   `{"rules": [{"makro": "*", "action": "drop"}]}`,
+		"glob.json":    `{"rules": [{"makro": "*", "variable": "[x", "action": "drop"}]}`,
+		"policy.ini":   ``,
+		"notyaml.yaml": "rules: [",
+	}
+	for name, content := range invalid {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadMergePolicy(path); err == nil {
+			t.Errorf("%s should be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package corpus
+
+import (
+	"bytes"
+	"log"
+	"sync"
+)
+
+// runs work(i) for every i in [0, count), at most jobs at the same time.
+// jobs < 1 is treated as 1
+func RunParallel(jobs int, count int, work func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, count) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				work(i)
+			}
+		}()
+	}
+	for i := range count {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+var groupedLoggerMutex sync.Mutex
+
+// logger that keeps lines in memory, flush writes all of them at once to log.Writer().
+// Keeps log lines of one file together when files are processed in parallel
+func NewGroupedLogger() (*log.Logger, func()) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, log.Prefix(), log.Flags())
+	flush := func() {
+		groupedLoggerMutex.Lock()
+		defer groupedLoggerMutex.Unlock()
+		log.Writer().Write(buf.Bytes())
+		buf.Reset()
+	}
+	return logger, flush
+}
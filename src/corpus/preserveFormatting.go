@@ -0,0 +1,207 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+Preserve formatting mode: instead of encoding the whole file again only changed makros are encoded,
+everything else is copied byte for byte from original file.
+
+Makros are identified by path of element names with index among siblings of the same name, relative to root:
+
+	ELEMENT[0]/ELMLIST[0]/ELM[1]/ELINKS[0]/SPOJ[0]/M1[0]
+	ELEMENT[0]/ELINKS[0]/MAKLINK[1]/MM1[0]
+	ELEMENT[0]/ELINKS[0]/MAKLINK[1]/MM2[0]
+*/
+
+// path of element relative to root, see MakroPath
+func (ef *ElementFile) visitElementsWithPath(f func(path string, e *Element)) {
+	for i := range ef.Element {
+		ef.Element[i].visitElementsWithPath(fmt.Sprintf("ELEMENT[%d]", i), f)
+	}
+}
+
+func (e *Element) visitElementsWithPath(path string, f func(path string, e *Element)) {
+	f(path, e)
+	for i := range e.ElmList.Elm {
+		e.ElmList.Elm[i].visitElementsWithPath(fmt.Sprintf("%s/ELMLIST[0]/ELM[%d]", path, i), f)
+	}
+}
+
+// path of makro in slot (1 or 2) of SPOJ with given index in ELINKS of element, version 17 uses MAKLINK/MM1
+func MakroPath(elementPath string, version17 bool, index int, slot int) string {
+	if version17 {
+		return fmt.Sprintf("%s/ELINKS[0]/MAKLINK[%d]/MM%d[0]", elementPath, index, slot)
+	}
+	return fmt.Sprintf("%s/ELINKS[0]/SPOJ[%d]/M%d[0]", elementPath, index, slot)
+}
+
+// byte range of single M1, M2, MM1 or MM2 in original file
+type makroLocation struct {
+	Start int64
+	End   int64
+	// whitespace before start tag, used to indent encoded makro
+	Indent string
+}
+
+var makroPathRegex = regexp.MustCompile(`/(SPOJ\[\d+\]/M[12]|MAKLINK\[\d+\]/MM[12])\[0\]$`)
+
+// finds all M1, M2 (in SPOJ) and MM1, MM2 (in MAKLINK) in raw file
+func scanMakroLocations(data []byte) (map[string]makroLocation, error) {
+	type frame struct {
+		path     string
+		start    int64
+		children map[string]int
+	}
+	locations := map[string]makroLocation{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	stack := []frame{}
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error decoding XML: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToUpper(t.Name.Local)
+			path := ""
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				index := parent.children[name]
+				parent.children[name]++
+				path = fmt.Sprintf("%s[%d]", name, index)
+				if parent.path != "" {
+					path = parent.path + "/" + path
+				}
+			}
+			stack = append(stack, frame{path: path, start: offset, children: map[string]int{}})
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected end element: %s", t.Name.Local)
+			}
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if makroPathRegex.MatchString(current.path) {
+				lineStart := bytes.LastIndexByte(data[:current.start], '\n') + 1
+				indent := string(data[lineStart:current.start])
+				if strings.TrimSpace(indent) != "" {
+					indent = ""
+				}
+				locations[current.path] = makroLocation{Start: current.start, End: decoder.InputOffset(), Indent: indent}
+			}
+		}
+	}
+	return locations, nil
+}
+
+var emptyElementRegex = regexp.MustCompile(`<([A-Za-z0-9_]+)([^<>]*)></([A-Za-z0-9_]+)>`)
+
+// how makro was written in original file, files saved by different versions of Corpus differ
+type makroStyle struct {
+	// &#34; instead of &quot;
+	NumericQuotes bool
+	// <MSVA DAT="x=1"></MSVA> instead of <MSVA DAT="x=1"/>
+	ExplicitEndTags bool
+}
+
+// style of makro in original bytes, Corpus style (&quot; and self closing empty elements) if it can not be told
+func detectMakroStyle(original []byte) makroStyle {
+	return makroStyle{
+		NumericQuotes:   bytes.Contains(original, []byte("&#34;")) && !bytes.Contains(original, []byte("&quot;")),
+		ExplicitEndTags: emptyElementRegex.Match(original) && !bytes.Contains(original, []byte("/>")),
+	}
+}
+
+// encodes makro (M1, M2, MM1 or MM2) with style of original makro
+func encodeMakroCorpusStyle(makro any, name string, indent string, style makroStyle) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := xml.NewEncoder(&out)
+	encoder.Indent(indent, "  ")
+	if err := encoder.EncodeElement(makro, xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	encoded := out.Bytes()
+	// literal '&' is always escaped as &amp; so it is safe
+	if !style.NumericQuotes {
+		encoded = bytes.ReplaceAll(encoded, []byte("&#34;"), []byte("&quot;"))
+		encoded = bytes.ReplaceAll(encoded, []byte("&#39;"), []byte("&apos;"))
+	}
+	if !style.ExplicitEndTags {
+		encoded = emptyElementRegex.ReplaceAllFunc(encoded, func(match []byte) []byte {
+			groups := emptyElementRegex.FindSubmatch(match)
+			if !bytes.Equal(groups[1], groups[3]) {
+				return match
+			}
+			return []byte("<" + string(groups[1]) + string(groups[2]) + "/>")
+		})
+	}
+	// start tag is placed after original indentation
+	return bytes.TrimPrefix(encoded, []byte(indent)), nil
+}
+
+// replaces makros in original file with encoded versions. Keys come from MakroPath, values are *M1 or *MM1, also for second slot
+func spliceMakros(original []byte, changedMakros map[string]any) ([]byte, error) {
+	if len(changedMakros) == 0 {
+		return original, nil
+	}
+	locations, err := scanMakroLocations(original)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(changedMakros))
+	for path := range changedMakros {
+		if _, found := locations[path]; !found {
+			return nil, fmt.Errorf("makro not found in original file: %s", path)
+		}
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return locations[paths[i]].Start < locations[paths[j]].Start })
+
+	var out bytes.Buffer
+	var copied int64
+	for _, path := range paths {
+		location := locations[path]
+		// M1, M2, MM1 or MM2, see MakroPath
+		name := strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], "[0]")
+		encoded, err := encodeMakroCorpusStyle(changedMakros[path], name, location.Indent, detectMakroStyle(original[location.Start:location.End]))
+		if err != nil {
+			return nil, fmt.Errorf("can not encode makro %s: %w", path, err)
+		}
+		out.Write(original[copied:location.Start])
+		out.Write(encoded)
+		copied = location.End
+	}
+	out.Write(original[copied:])
+	return out.Bytes(), nil
+}
+
+// like readWriteCorpusFile, but only makros in changedMakros are encoded, the rest of file is copied byte for byte.
+// changedMakros is filled by handlers
+func readWriteCorpusFilePreservingFormatting(logger *log.Logger, inputFile string, outputFile string, dryRun bool,
+	handleE3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+	handleS3DFile func(decoder *xml.Decoder, start xml.StartElement) xml.Token,
+	changedMakros map[string]any,
+) error {
+	return transformCorpusFile(logger, inputFile, outputFile, dryRun, func(input []byte) ([]byte, error) {
+		// handlers are run only to fill changedMakros
+		if _, err := encodeCorpusFile(logger, input, false, handleE3DFile, handleS3DFile); err != nil {
+			return nil, err
+		}
+		return spliceMakros(input, changedMakros)
+	})
+}
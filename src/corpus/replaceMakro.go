@@ -2,6 +2,7 @@ package corpus
 
 import (
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,25 +10,63 @@ import (
 	"strconv"
 )
 
-func ReplaceMakroInCorpusFile(inputFile string, outputFile string, makrosToReplace map[string]*M1, makroRename map[string]string, alwaysConvertLocalToGlobal bool, verbose bool, minify bool) error {
+type ReplaceOptions struct {
+	MergeOptions
+	Verbose bool
+	// Reduce file size by deleting spaces
+	Minify bool
+	// do the full decode and UpdateMakro merge, but do not write anything
+	DryRun bool
+	// number of files processed at the same time by ReplaceMakroInCorpusFolder, < 1 means 1
+	Jobs int
+	// nil means log.Default()
+	Logger *log.Logger
+	// overwrite input files instead of writing to output, original is saved first, see BackupPath
+	InPlace bool
+	// empty means backup is saved next to original file
+	BackupDir string
+	// backups in BackupDir mirror directory structure relative to BackupRoot
+	BackupRoot string
+	// shared by all files of single run, see NewRunID
+	RunID string
+	// every backup is added when it is saved, nil means no manifest
+	Manifest *ManifestWriter
+	// only updated makros are encoded, the rest of file is copied byte for byte. Minify is ignored
+	PreserveFormatting bool
+}
+
+// outputFile is not used when options.InPlace is set
+func ReplaceMakroInCorpusFile(inputFile string, outputFile string, makrosToReplace map[string]*M1, makroRename map[string]string, options ReplaceOptions) (*FileReport, error) {
+	if options.InPlace {
+		outputFile = inputFile
+	}
 	macrosUpdated := 0
 	macrosSkipped := 0
+	report := &FileReport{InputFile: inputFile, OutputFile: outputFile, DryRun: options.DryRun}
+	logger := options.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
 
-	handleVisitElement := func(element *Element) {
+	var encodeErrors []error
+	// filled only in PreserveFormatting mode, see MakroPath
+	changedMakros := map[string]any{}
+	handleVisitElement := func(elementPath string, element *Element) {
 		visitedDaske := []string{}
 		updatedDaske := map[string]int{}
 		skippedDaske := map[string]int{}
-		for i, spoj := range element.Elinks.Spoj {
-			adIndex, _ := strconv.Atoi(spoj.O1.Value)
-			daske := element.Daske.AD[adIndex]
-			daskeName := daske.DName.Value
+		// returns nil if makro should not be updated
+		updateMakroInPlate := func(adIndexValue string, slot int, oldMakro *M1) *M1 {
+			daskeName := ""
+			if adIndex, err := strconv.Atoi(adIndexValue); err == nil && adIndex >= 0 && adIndex < len(element.Daske.AD) {
+				daskeName = element.Daske.AD[adIndex].DName.Value
+			}
 			visitedDaske = append(visitedDaske, daskeName)
-			oldMakro := spoj.Makro1
 			newMakro, newMakroExists := makrosToReplace[oldMakro.MakroName]
 			if !newMakroExists {
 				macrosSkipped++
 				skippedDaske[daskeName]++
-				continue
+				return nil
 			}
 			renameMakro, found := makroRename[oldMakro.MakroName]
 			var renameTo *string
@@ -36,21 +75,77 @@ func ReplaceMakroInCorpusFile(inputFile string, outputFile string, makrosToRepla
 			} else {
 				renameTo = &renameMakro
 			}
-			newMakroCopyUntilIFixTheUpdateMakro := *newMakro
-			UpdateMakro(&oldMakro, &newMakroCopyUntilIFixTheUpdateMakro, renameTo, alwaysConvertLocalToGlobal)
-			element.Elinks.Spoj[i].Makro1 = newMakroCopyUntilIFixTheUpdateMakro
-			// spoj.Makro1 = *newMakro
+			// newMakro is shared between all files
+			newMakroCopy := newMakro.Copy()
+			base, baseVersion := options.Bases.FindBase(oldMakro.MakroName, oldMakro)
+			if options.Bases != nil && base == nil {
+				logger.Printf("  Base version of makro '%s' not found, using two-way merge\n", oldMakro.MakroName)
+			}
+			changes := mergeMakro(logger, base, oldMakro, newMakroCopy, renameTo, options.MergeOptions)
+			report.Makros = append(report.Makros, MakroReport{
+				Element:     element.EName.Value,
+				Plate:       daskeName,
+				Slot:        slot,
+				MakroName:   oldMakro.MakroName,
+				RenamedTo:   renameMakro,
+				BaseVersion: baseVersion,
+				Changes:     changes,
+			})
 
 			// todo reorder variables so that ones with the same name are next to each other
 			// oldVariablesKeys, oldValues, _ := loadValuesFromSection(oldMacro.Varijable.DAT)
 			// newVariablesKeys, newValues, newVariablesComments := loadValuesFromSection(newMacro.Varijable.DAT)
 			macrosUpdated++
 			updatedDaske[daskeName]++
+			return newMakroCopy
+		}
+		// version 16, both slots use plate from O1
+		for i := range element.Elinks.Spoj {
+			spoj := &element.Elinks.Spoj[i]
+			for slot, makro := range spoj.Makros() {
+				if makro.isEmpty() {
+					continue
+				}
+				oldMakro := *makro
+				if updated := updateMakroInPlate(spoj.O1.Value, slot+1, &oldMakro); updated != nil {
+					*makro = *updated
+					changedMakros[MakroPath(elementPath, false, i, slot+1)] = makro
+				}
+			}
 		}
-		if verbose {
-			log.Printf("  Cabinet '%s'\n", element.EName.Value)
+		// version 17, makro is decoded from C6DAT, updated and encoded back. Makros that are not updated are left untouched
+		for i := range element.Elinks.MakLink {
+			makLink := &element.Elinks.MakLink[i]
+			for slot, mm := range makLink.Makros() {
+				oldMakro, err := NewM1(mm)
+				if err != nil {
+					err = fmt.Errorf("cabinet '%s': can not decode makro '%s': %w", element.EName.Value, mm.MakroName, err)
+					logger.Println(err)
+					encodeErrors = append(encodeErrors, err)
+					continue
+				}
+				if oldMakro.isEmpty() {
+					continue
+				}
+				updated := updateMakroInPlate(makLink.OB1.Value, slot+1, oldMakro)
+				if updated == nil {
+					continue
+				}
+				encoded, err := NewMM1(updated)
+				if err != nil {
+					err = fmt.Errorf("cabinet '%s': can not encode makro '%s': %w", element.EName.Value, updated.MakroName, err)
+					logger.Println(err)
+					encodeErrors = append(encodeErrors, err)
+					continue
+				}
+				*mm = *encoded
+				changedMakros[MakroPath(elementPath, true, i, slot+1)] = mm
+			}
+		}
+		if options.Verbose {
+			logger.Printf("  Cabinet '%s'\n", element.EName.Value)
 			for _, name := range visitedDaske {
-				log.Printf("    Updated %d macros, %d skipped in plate '%s'\n", updatedDaske[name], skippedDaske[name], name)
+				logger.Printf("    Updated %d macros, %d skipped in plate '%s'\n", updatedDaske[name], skippedDaske[name], name)
 			}
 		}
 	}
@@ -62,11 +157,11 @@ func ReplaceMakroInCorpusFile(inputFile string, outputFile string, makrosToRepla
 		err := decoder.DecodeElement(&rootCorpusFile, &start)
 		decoder.Strict = false
 		if err != nil {
-			log.Printf("%s: %s", inputFile, err)
+			logger.Printf("%s: %s", inputFile, err)
 		}
 		// todo visit all elements including groups
-		rootCorpusFile.VisitElementsAndSubelements(handleVisitElement)
-		log.Printf("  Summary: updated %d macros, %d skipped\n", macrosUpdated, macrosSkipped)
+		rootCorpusFile.visitElementsWithPath(handleVisitElement)
+		logger.Printf("  Summary: updated %d macros, %d skipped\n", macrosUpdated, macrosSkipped)
 
 		return rootCorpusFile
 	}
@@ -77,32 +172,85 @@ func ReplaceMakroInCorpusFile(inputFile string, outputFile string, makrosToRepla
 		err := decoder.DecodeElement(&rootCorpusFile, &start)
 		decoder.Strict = false
 		if err != nil {
-			log.Printf("%s: %s", inputFile, err)
+			logger.Printf("%s: %s", inputFile, err)
 		}
-		rootCorpusFile.VisitElementsAndSubelements(handleVisitElement)
-		log.Printf("  Summary: updated %d macros, %d skipped\n", macrosUpdated, macrosSkipped)
+		rootCorpusFile.visitElementsWithPath(handleVisitElement)
+		logger.Printf("  Summary: updated %d macros, %d skipped\n", macrosUpdated, macrosSkipped)
 
 		return rootCorpusFile
 	}
 
-	err := ReadWriteCorpusFile(inputFile, outputFile, minify, visitCorpusE3DFile, visitCorpusS3DFile)
+	if options.InPlace && !options.DryRun {
+		if options.RunID == "" {
+			options.RunID = NewRunID()
+		}
+		backupRoot := options.BackupRoot
+		if backupRoot == "" {
+			backupRoot = filepath.Dir(inputFile)
+		}
+		backup := BackupPath(inputFile, backupRoot, options.BackupDir, options.RunID)
+		if err := BackupFile(inputFile, backup); err != nil {
+			return report, err
+		}
+		logger.Printf("Saved backup: '%s'", backup)
+		report.Backup = backup
+		if options.Manifest != nil {
+			if err := options.Manifest.Add(outputFile, backup); err != nil {
+				// file is not modified without record of its backup
+				os.Remove(backup)
+				report.Backup = ""
+				return report, fmt.Errorf("can not write run manifest: %w", err)
+			}
+		}
+	}
+
+	var err error
+	if options.PreserveFormatting {
+		err = readWriteCorpusFilePreservingFormatting(logger, inputFile, outputFile, options.DryRun, visitCorpusE3DFile, visitCorpusS3DFile, changedMakros)
+	} else {
+		err = readWriteCorpusFile(logger, inputFile, outputFile, options.Minify, options.DryRun, visitCorpusE3DFile, visitCorpusS3DFile)
+	}
 	if err != nil {
-		log.Printf("error when operating on corpus file: %s", err)
+		logger.Printf("error when operating on corpus file: %s", err)
+		if report.Backup != "" {
+			// input file was not modified
+			os.Remove(report.Backup)
+			report.Backup = ""
+			if options.Manifest != nil {
+				if errManifest := options.Manifest.Remove(outputFile); errManifest != nil {
+					logger.Printf("can not write run manifest: %s", errManifest)
+				}
+			}
+		}
 	}
-	return err
+	report.Updated = macrosUpdated
+	report.Skipped = macrosSkipped
+	if err == nil && len(encodeErrors) > 0 {
+		err = fmt.Errorf("%s: %w", inputFile, errors.Join(encodeErrors...))
+	}
+	return report, err
 }
 
-func ReplaceMakroInCorpusFolder(inputFolder string, outputFolder string, makroFiles []string, macroNamesOverrides []*string, alwaysConvertLocalToGlobal bool, verbose bool, minify bool) error {
+// outputFolder is not used when options.DryRun or options.InPlace is set
+func ReplaceMakroInCorpusFolder(inputFolder string, outputFolder string, makroFiles []string, macroNamesOverrides []*string, options ReplaceOptions) ([]FileReport, error) {
 	inputFolderStat, err := os.Stat(inputFolder)
 	if err != nil {
-		return fmt.Errorf("error reading input folder: %w", err)
+		return nil, fmt.Errorf("error reading input folder: %w", err)
 	}
 	if !inputFolderStat.IsDir() {
-		return fmt.Errorf("%s must be a directory", inputFolder)
+		return nil, fmt.Errorf("%s must be a directory", inputFolder)
 	}
-	err = os.MkdirAll(outputFolder, os.ModePerm)
-	if err != nil {
-		return fmt.Errorf("can not create dir: %w", err)
+	if options.InPlace && options.BackupRoot == "" {
+		options.BackupRoot = inputFolder
+	}
+	if options.InPlace && options.RunID == "" {
+		options.RunID = NewRunID()
+	}
+	if !options.DryRun && !options.InPlace {
+		err = os.MkdirAll(outputFolder, os.ModePerm)
+		if err != nil {
+			return nil, fmt.Errorf("can not create dir: %w", err)
+		}
 	}
 
 	foundCorpusFiles := FindCorpusFiles(inputFolder)
@@ -112,23 +260,25 @@ func ReplaceMakroInCorpusFolder(inputFolder string, outputFolder string, makroFi
 	// todo support the rest of parameters
 	makrosToReplace, err := ReadMakrosFromCMK(makroFiles, macroNamesOverrides, nil, nil)
 	if err != nil {
-		return fmt.Errorf("error reading CMK macros: %w", err)
+		return nil, fmt.Errorf("error reading CMK macros: %w", err)
 	}
-	var errOut error
-	for _, inputFile := range foundCorpusFiles {
+	// results are stored by index so the output does not depend on the order in which files finish
+	reports := make([]FileReport, len(foundCorpusFiles))
+	errs := make([]error, len(foundCorpusFiles))
+	RunParallel(options.Jobs, len(foundCorpusFiles), func(i int) {
+		inputFile := foundCorpusFiles[i]
 		relInputFile, _ := filepath.Rel(inputFolder, inputFile)
 		outputFile := filepath.Join(outputFolder, relInputFile)
-		err := ReplaceMakroInCorpusFile(inputFile, outputFile, makrosToReplace, map[string]string{}, alwaysConvertLocalToGlobal, verbose, minify)
-		if err != nil {
-			if errOut != nil {
-				errOut = fmt.Errorf("%w\n%w", errOut, err)
-			} else {
-				errOut = fmt.Errorf("%w", err)
-			}
+		fileOptions := options
+		if options.Jobs > 1 {
+			var flush func()
+			fileOptions.Logger, flush = NewGroupedLogger()
+			defer flush()
 		}
-	}
-	if errOut != nil {
-		return errOut
-	}
-	return nil
+		report, err := ReplaceMakroInCorpusFile(inputFile, outputFile, makrosToReplace, map[string]string{}, fileOptions)
+		reports[i] = *report
+		errs[i] = err
+	})
+	// nil errors of files without problem are skipped
+	return reports, errors.Join(errs...)
 }
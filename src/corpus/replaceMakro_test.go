@@ -0,0 +1,321 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplaceMakroInCorpusFileDryRun(t *testing.T) {
+	makro, err := NewMakroFromCMKFile(nil, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	outputFile := filepath.Join(t.TempDir(), "output", "simple.E3D")
+	report, err := ReplaceMakroInCorpusFile(
+		filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"),
+		outputFile,
+		map[string]*M1{"gorny": makro},
+		map[string]string{},
+		ReplaceOptions{DryRun: true},
+	)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if _, err := os.Stat(outputFile); err == nil {
+		t.Errorf("dry run should not create output file: %s", outputFile)
+	}
+	if _, err := os.Stat(filepath.Dir(outputFile)); err == nil {
+		t.Errorf("dry run should not create output directory: %s", filepath.Dir(outputFile))
+	}
+	if !report.DryRun || report.Updated != 1 || report.Skipped != 1 {
+		t.Errorf("wrong summary: dry run %t, updated %d, skipped %d", report.DryRun, report.Updated, report.Skipped)
+	}
+	if len(report.Makros) != 1 {
+		t.Errorf("wrong number of makros in report: %d", len(report.Makros))
+		t.FailNow()
+	}
+	makroReport := report.Makros[0]
+	if makroReport.Element != "simple_original_custom" || makroReport.Plate != "Wieniec_Gorny" || makroReport.MakroName != "gorny" {
+		t.Errorf("wrong makro location: %+v", makroReport)
+	}
+	results := map[string]UpdateResult{}
+	for _, change := range makroReport.Changes {
+		results[change.Section+"."+*change.NewName] = change.Result
+	}
+	if results["VARIJABLE.x"] != ValueAdded {
+		t.Errorf("'x' should be added: %s", results["VARIJABLE.x"])
+	}
+	if len(makroReport.Changes) != 6 {
+		t.Errorf("wrong number of changes: %d", len(makroReport.Changes))
+	}
+	// [JOINT] is merged per key, maxdistance is commented out in new makro
+	if results["JOINT.CONNECT"] != ValueSame || results["JOINT.maxdistance"] != ValueKept {
+		t.Errorf("wrong [JOINT] changes: %v", results)
+	}
+}
+
+func TestReplaceMakroInCorpusFolderJobs(t *testing.T) {
+	cmk, err := os.ReadFile(filepath.Join(pathToCMKTestData, "simple.CMK"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	e3d, err := os.ReadFile(filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	dir := t.TempDir()
+	makroFile := filepath.Join(dir, "gorny.CMK")
+	os.WriteFile(makroFile, cmk, 0644)
+	inputFolder := filepath.Join(dir, "input")
+	os.MkdirAll(filepath.Join(inputFolder, "sub"), os.ModePerm)
+	for _, name := range []string{"a.E3D", "b.E3D", "c.E3D", filepath.Join("sub", "d.E3D"), filepath.Join("sub", "e.E3D")} {
+		os.WriteFile(filepath.Join(inputFolder, name), e3d, 0644)
+	}
+
+	sequential, err := ReplaceMakroInCorpusFolder(inputFolder, filepath.Join(dir, "sequential"), []string{makroFile}, nil, ReplaceOptions{Jobs: 1})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	parallel, err := ReplaceMakroInCorpusFolder(inputFolder, filepath.Join(dir, "parallel"), []string{makroFile}, nil, ReplaceOptions{Jobs: 4})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(sequential) != 5 || len(parallel) != len(sequential) {
+		t.Errorf("wrong number of reports: sequential %d, parallel %d", len(sequential), len(parallel))
+		t.FailNow()
+	}
+	for i := range sequential {
+		if sequential[i].InputFile != parallel[i].InputFile {
+			t.Errorf("reports are in different order: '%s' != '%s'", sequential[i].InputFile, parallel[i].InputFile)
+		}
+		if sequential[i].Updated != 1 || parallel[i].Updated != 1 {
+			t.Errorf("'%s' should have 1 makro updated: sequential %d, parallel %d", sequential[i].InputFile, sequential[i].Updated, parallel[i].Updated)
+		}
+		sequentialOutput, _ := os.ReadFile(sequential[i].OutputFile)
+		parallelOutput, _ := os.ReadFile(parallel[i].OutputFile)
+		if len(sequentialOutput) == 0 || string(sequentialOutput) != string(parallelOutput) {
+			t.Errorf("output differs for '%s'", sequential[i].InputFile)
+		}
+	}
+}
+
+func TestM1Copy(t *testing.T) {
+	makro, err := NewMakroFromCMKFile(nil, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	makroCopy := makro.Copy()
+	makroCopy.Varijable.DAT = "changed"
+	makroCopy.Joint.DAT = "changed"
+	makroCopy.Pila[0].DAT = "changed"
+	if makro.Varijable.DAT == "changed" || makro.Joint.DAT == "changed" || makro.Pila[0].DAT == "changed" {
+		t.Errorf("modifying copy changed original makro")
+	}
+}
+
+func TestReplaceMakroInCorpusFileVersion17(t *testing.T) {
+	makroName := "gorny"
+	makro, err := NewMakroFromCMKFile(&makroName, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	inputFile := filepath.Join(pathToE3DTestDataVertsion17, "simple.E3D")
+	outputFile := filepath.Join(t.TempDir(), "simple.E3D")
+	report, err := ReplaceMakroInCorpusFile(inputFile, outputFile, map[string]*M1{"gorny": makro}, map[string]string{}, ReplaceOptions{})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if report.Updated != 1 || report.Skipped != 1 {
+		t.Errorf("wrong summary: updated %d, skipped %d", report.Updated, report.Skipped)
+	}
+
+	_, original, err := NewCorpusFile(inputFile)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	_, output, err := NewCorpusFile(outputFile)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if output.VER.Value != "17" {
+		t.Errorf("file should stay in version 17, got: %s", output.VER.Value)
+	}
+	elinks := output.Element[0].Elinks
+	if len(elinks.Spoj) != 0 || len(elinks.MakLink) != 2 {
+		t.Errorf("version 17 should use only MAKLINK: spoj %d, maklink %d", len(elinks.Spoj), len(elinks.MakLink))
+		t.FailNow()
+	}
+	gorny, err := NewM1(&elinks.MakLink[0].MM1)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if gorny.MakroName != "gorny" {
+		t.Errorf("wrong makro: %s", gorny.MakroName)
+	}
+	if gorny.Joint.DAT != "CONNECT=23,mindistance=-14,maxdistance=5" {
+		t.Errorf("joint should be taken from old makro: %s", gorny.Joint.DAT)
+	}
+	if gorny.Varijable.DAT != makroVarijableAfterUpdate(t, "gorny") {
+		t.Errorf("version 17 update differs from version 16: %s", gorny.Varijable.DAT)
+	}
+	if elinks.MakLink[1].MM1.Varijable.C6DAT != original.Element[0].Elinks.MakLink[1].MM1.Varijable.C6DAT {
+		t.Errorf("makro that is not updated should not be reencoded")
+	}
+}
+
+// copy of version 16 simple.E3D with makro 'gorny' moved from M1 to M2 of its SPOJ
+func simpleE3DWithGornyInSlot2(t *testing.T) string {
+	data, err := os.ReadFile(filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	text := string(data)
+	gornyStart := strings.Index(text, `<M1 MN="gorny">`)
+	gornyEnd := gornyStart + strings.Index(text[gornyStart:], "</M1>")
+	emptyStart := gornyEnd + strings.Index(text[gornyEnd:], `<M2 MN="">`)
+	emptyEnd := emptyStart + strings.Index(text[emptyStart:], "</M2>")
+	gorny := text[gornyStart+len(`<M1 MN="gorny">`) : gornyEnd]
+	empty := text[emptyStart+len(`<M2 MN="">`) : emptyEnd]
+	text = text[:gornyStart] + `<M1 MN="">` + empty + "</M1>" + text[gornyEnd+len("</M1>"):emptyStart] + `<M2 MN="gorny">` + gorny + "</M2>" + text[emptyEnd+len("</M2>"):]
+	inputFile := filepath.Join(t.TempDir(), "simple.E3D")
+	if err := os.WriteFile(inputFile, []byte(text), 0666); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	return inputFile
+}
+
+func TestReplaceMakroInCorpusFileSlot2(t *testing.T) {
+	makroName := "gorny"
+	makro, err := NewMakroFromCMKFile(&makroName, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	inputFile := simpleE3DWithGornyInSlot2(t)
+	for _, options := range []ReplaceOptions{{}, {PreserveFormatting: true}} {
+		outputFile := filepath.Join(t.TempDir(), "output.E3D")
+		report, err := ReplaceMakroInCorpusFile(inputFile, outputFile, map[string]*M1{"gorny": makro}, map[string]string{}, options)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		// empty M1 next to gorny is not counted as skipped
+		if report.Updated != 1 || report.Skipped != 1 || len(report.Makros) != 1 {
+			t.Errorf("wrong summary: updated %d, skipped %d, makros %d", report.Updated, report.Skipped, len(report.Makros))
+			t.FailNow()
+		}
+		if report.Makros[0].Slot != 2 || report.Makros[0].Plate != "Wieniec_Gorny" {
+			t.Errorf("wrong makro location: %+v", report.Makros[0])
+		}
+		_, output, err := NewCorpusFile(outputFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		spoj := output.Element[0].Elinks.Spoj[0]
+		if spoj.Makro1.MakroName != "" || spoj.Makro2.MakroName != "gorny" {
+			t.Errorf("makro should stay in slot 2: M1 '%s', M2 '%s'", spoj.Makro1.MakroName, spoj.Makro2.MakroName)
+		}
+		if spoj.Makro2.Varijable.DAT != makroVarijableAfterUpdate(t, "gorny") {
+			t.Errorf("slot 2 update differs from slot 1 (preserve formatting %t): %s", options.PreserveFormatting, spoj.Makro2.Varijable.DAT)
+		}
+	}
+}
+
+// result of the same update on version 16 file
+func makroVarijableAfterUpdate(t *testing.T, makroName string) string {
+	makro, err := NewMakroFromCMKFile(&makroName, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	outputFile := filepath.Join(t.TempDir(), "simple.E3D")
+	_, err = ReplaceMakroInCorpusFile(filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D"), outputFile, map[string]*M1{makroName: makro}, map[string]string{}, ReplaceOptions{})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	_, output, err := NewCorpusFile(outputFile)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	for _, spoj := range output.Element[0].Elinks.Spoj {
+		if spoj.Makro1.MakroName == makroName {
+			return spoj.Makro1.Varijable.DAT
+		}
+	}
+	t.Errorf("makro not found: %s", makroName)
+	return ""
+}
+
+func TestRoundTripVersion17(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(pathToE3DTestDataVertsion17, "*.E3D"))
+	if err != nil || len(files) == 0 {
+		t.Errorf("no test files: %s", err)
+		t.FailNow()
+	}
+	for _, inputFile := range files {
+		outputFile := filepath.Join(t.TempDir(), filepath.Base(inputFile))
+		_, err := ReplaceMakroInCorpusFile(inputFile, outputFile, map[string]*M1{}, map[string]string{}, ReplaceOptions{})
+		if err != nil {
+			t.Errorf("%s: %s", inputFile, err)
+			continue
+		}
+		_, original, err := NewCorpusFile(inputFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		_, output, err := NewCorpusFile(outputFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		if output.VER.Value != "17" {
+			t.Errorf("%s: file should stay in version 17, got: %s", inputFile, output.VER.Value)
+		}
+		originalMakLinks := []MakLink{}
+		original.VisitElementsAndSubelements(func(e *Element) {
+			originalMakLinks = append(originalMakLinks, e.Elinks.MakLink...)
+		})
+		outputMakLinks := []MakLink{}
+		output.VisitElementsAndSubelements(func(e *Element) {
+			if len(e.Elinks.Spoj) != 0 {
+				t.Errorf("%s: cabinet '%s' should not have SPOJ", inputFile, e.EName.Value)
+			}
+			outputMakLinks = append(outputMakLinks, e.Elinks.MakLink...)
+		})
+		if len(originalMakLinks) == 0 || len(originalMakLinks) != len(outputMakLinks) {
+			t.Errorf("%s: wrong number of makros: %d != %d", inputFile, len(originalMakLinks), len(outputMakLinks))
+			continue
+		}
+		for i := range originalMakLinks {
+			originalM1, err1 := NewM1(&originalMakLinks[i].MM1)
+			outputM1, err2 := NewM1(&outputMakLinks[i].MM1)
+			if err1 != nil || err2 != nil {
+				t.Errorf("%s: can not decode: %s, %s", inputFile, err1, err2)
+				continue
+			}
+			if originalM1.MakroName != outputM1.MakroName || originalM1.Varijable.DAT != outputM1.Varijable.DAT || len(originalM1.Makro) != len(outputM1.Makro) {
+				t.Errorf("%s: makro '%s' changed after round trip", inputFile, originalM1.MakroName)
+			}
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package corpus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// single makro (Spoj) that was updated, or would be updated in dry run
+type MakroReport struct {
+	Element string // Element.EName
+	Plate   string // AD.DName
+	// 1 for M1/MM1, 2 for M2/MM2
+	Slot      int
+	MakroName string
+	// empty if makro was not renamed
+	RenamedTo string
+	// version from MakroSnapshots used for three-way merge, empty for two-way merge
+	BaseVersion string
+	Changes     []Change
+}
+
+// everything that happened to one corpus file
+type FileReport struct {
+	InputFile  string
+	OutputFile string
+	DryRun     bool
+	Updated    int
+	Skipped    int
+	Makros     []MakroReport
+	// copy of original file, only for in place update
+	Backup string
+}
+
+func (r UpdateResult) String() string {
+	switch r {
+	case ValueDeleted:
+		return "deleted"
+	case ValueAdded:
+		return "added"
+	case ValueSame:
+		return "same"
+	case ValueChanged:
+		return "changed"
+	case ValueChangedConvertedToGlobal:
+		return "changed, converted to global"
+	case ValueChangedRemainedToLocal:
+		return "changed, remained local"
+	case ValueUpdatedFromNew:
+		return "updated from new"
+	case ValueConflict:
+		return "conflict, old value kept"
+	case ValueRenamed:
+		return "renamed"
+	case ValueKept:
+		return "kept, deleted from new"
+	}
+	return fmt.Sprintf("UpdateResult(%d)", int(r))
+}
+
+var updateResultNames = map[UpdateResult]string{
+	ValueDeleted:                  "ValueDeleted",
+	ValueAdded:                    "ValueAdded",
+	ValueSame:                     "ValueSame",
+	ValueChanged:                  "ValueChanged",
+	ValueChangedConvertedToGlobal: "ValueChangedConvertedToGlobal",
+	ValueChangedRemainedToLocal:   "ValueChangedRemainedToLocal",
+	ValueUpdatedFromNew:           "ValueUpdatedFromNew",
+	ValueConflict:                 "ValueConflict",
+	ValueRenamed:                  "ValueRenamed",
+	ValueKept:                     "ValueKept",
+}
+
+// machine readable name, used in JSON and CSV reports
+func (r UpdateResult) MarshalText() ([]byte, error) {
+	name, found := updateResultNames[r]
+	if !found {
+		return nil, fmt.Errorf("unknown UpdateResult: %d", int(r))
+	}
+	return []byte(name), nil
+}
+
+func (r *UpdateResult) UnmarshalText(text []byte) error {
+	for result, name := range updateResultNames {
+		if name == string(text) {
+			*r = result
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown UpdateResult: %s", text)
+}
+
+func derefOr[T any](s *T, fallback T) T {
+	if s == nil {
+		return fallback
+	}
+	return *s
+}
+
+// human readable report, variables with the same value are omitted
+func WriteReportText(w io.Writer, reports []FileReport) error {
+	for _, fileReport := range reports {
+		if fileReport.DryRun {
+			fmt.Fprintf(w, "File: '%s' (dry run, would be written to '%s')\n", fileReport.InputFile, fileReport.OutputFile)
+		} else if fileReport.Backup != "" {
+			fmt.Fprintf(w, "File: '%s' (updated in place, backup '%s')\n", fileReport.InputFile, fileReport.Backup)
+		} else {
+			fmt.Fprintf(w, "File: '%s' -> '%s'\n", fileReport.InputFile, fileReport.OutputFile)
+		}
+		fmt.Fprintf(w, "  Summary: updated %d macros, %d skipped\n", fileReport.Updated, fileReport.Skipped)
+		for _, makro := range fileReport.Makros {
+			if makro.RenamedTo != "" {
+				fmt.Fprintf(w, "  Cabinet '%s', plate '%s', makro '%s' -> '%s'", makro.Element, makro.Plate, makro.MakroName, makro.RenamedTo)
+			} else {
+				fmt.Fprintf(w, "  Cabinet '%s', plate '%s', makro '%s'", makro.Element, makro.Plate, makro.MakroName)
+			}
+			if makro.Slot > 1 {
+				fmt.Fprintf(w, " (slot M%d)", makro.Slot)
+			}
+			if makro.BaseVersion != "" {
+				fmt.Fprintf(w, " (base version '%s')", makro.BaseVersion)
+			}
+			fmt.Fprintln(w)
+			for _, change := range makro.Changes {
+				// [VARIJABLE] is not printed
+				section := ""
+				if change.Section != "" && change.Section != "VARIJABLE" {
+					section = "[" + change.Section + "] "
+				}
+				rule := ""
+				if change.Rule != nil {
+					rule = fmt.Sprintf(" [rule %s]", change.Rule)
+				}
+				switch change.Result {
+				case ValueSame:
+				case ValueAdded:
+					fmt.Fprintf(w, "    %s%s: %s=%s%s\n", section, change.Result, derefOr(change.NewName, ""), change.NewValue, rule)
+				case ValueDeleted, ValueKept:
+					if change.Rule != nil && change.NewValue != "" {
+						// new variable dropped by rule
+						fmt.Fprintf(w, "    %s%s: %s=%s (new value: %s=%s)%s\n", section, change.Result, derefOr(change.OldName, ""), change.OldValue, derefOr(change.NewName, ""), change.NewValue, rule)
+					} else {
+						fmt.Fprintf(w, "    %s%s: %s=%s%s\n", section, change.Result, derefOr(change.OldName, ""), change.OldValue, rule)
+					}
+				case ValueChangedConvertedToGlobal, ValueChangedRemainedToLocal:
+					fmt.Fprintf(w, "    %s%s (%s): %s=%s (new value: %s=%s)%s\n", section, change.Result, derefOr(change.Strategy, OnlyIfValueIsNumber), derefOr(change.OldName, ""), change.OldValue, derefOr(change.NewName, ""), change.NewValue, rule)
+				default:
+					fmt.Fprintf(w, "    %s%s: %s=%s (new value: %s=%s)%s\n", section, change.Result, derefOr(change.OldName, ""), change.OldValue, derefOr(change.NewName, ""), change.NewValue, rule)
+				}
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "Files: %d\n", len(reports))
+	return err
+}
+
+// one row of machine readable report: single variable of single makro
+type ReportRecord struct {
+	InputFile  string       `json:"inputFile"`
+	OutputFile string       `json:"outputFile"`
+	Element    string       `json:"element"`
+	Plate      string       `json:"plate"`
+	Slot       int          `json:"slot"`
+	MakroName  string       `json:"makro"`
+	RenamedTo  string       `json:"renamedTo"`
+	Section    string       `json:"section"`
+	OldName    string       `json:"oldName"`
+	NewName    string       `json:"newName"`
+	OldValue   string       `json:"oldValue"`
+	NewValue   string       `json:"newValue"`
+	Result     UpdateResult `json:"result"`
+	// only for variables that got "_" prefix in new makro
+	Strategy *GlobalStrategy `json:"strategy,omitempty"`
+	// rule of merge policy that decided about variable, see PolicyRule.String
+	Rule string `json:"rule,omitempty"`
+}
+
+// flatten reports, one record per file/element/plate/makro/variable
+func NewReportRecords(reports []FileReport) []ReportRecord {
+	records := []ReportRecord{}
+	for _, fileReport := range reports {
+		for _, makro := range fileReport.Makros {
+			for _, change := range makro.Changes {
+				record := ReportRecord{
+					InputFile:  fileReport.InputFile,
+					OutputFile: fileReport.OutputFile,
+					Element:    makro.Element,
+					Plate:      makro.Plate,
+					Slot:       makro.Slot,
+					MakroName:  makro.MakroName,
+					RenamedTo:  makro.RenamedTo,
+					Section:    change.Section,
+					OldName:    derefOr(change.OldName, ""),
+					NewName:    derefOr(change.NewName, ""),
+					OldValue:   change.OldValue,
+					NewValue:   change.NewValue,
+					Result:     change.Result,
+					Strategy:   change.Strategy,
+				}
+				if change.Rule != nil {
+					record.Rule = change.Rule.String()
+				}
+				// CMKFindName falls back to searched name when there is no match, do not report it
+				switch change.Result {
+				case ValueAdded:
+					record.OldName = ""
+					record.OldValue = ""
+				case ValueDeleted, ValueKept:
+					// new variable dropped by rule keeps its new value
+					if change.Rule == nil || change.NewValue == "" {
+						record.NewName = ""
+						record.NewValue = ""
+					}
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records
+}
+
+func WriteReportJSON(w io.Writer, reports []FileReport) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewReportRecords(reports))
+}
+
+var reportCSVHeader = []string{"inputFile", "outputFile", "element", "plate", "slot", "makro", "renamedTo", "section", "oldName", "newName", "oldValue", "newValue", "result", "strategy", "rule"}
+
+func WriteReportCSV(w io.Writer, reports []FileReport) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range NewReportRecords(reports) {
+		result, err := r.Result.MarshalText()
+		if err != nil {
+			return err
+		}
+		strategy := []byte{}
+		if r.Strategy != nil {
+			if strategy, err = r.Strategy.MarshalText(); err != nil {
+				return err
+			}
+		}
+		row := []string{r.InputFile, r.OutputFile, r.Element, r.Plate, strconv.Itoa(r.Slot), r.MakroName, r.RenamedTo, r.Section, r.OldName, r.NewName, r.OldValue, r.NewValue, string(result), string(strategy), r.Rule}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// format is picked by extension: .json or .csv
+func WriteReportFile(path string, reports []FileReport) error {
+	var write func(io.Writer, []FileReport) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		write = WriteReportJSON
+	case ".csv":
+		write = WriteReportCSV
+	default:
+		return fmt.Errorf("unsupported report format: '%s', use .json or .csv", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("can not create report: %w", err)
+	}
+	defer f.Close()
+	if err := write(f, reports); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+)
+
+func testReports() []FileReport {
+	one, two, x, global := "one", "two", "x", "_global"
+	keepLocal := KeepLocal
+	return []FileReport{{
+		InputFile:  "in.E3D",
+		OutputFile: "out.E3D",
+		Makros: []MakroReport{{
+			Element:   "simple",
+			Plate:     "Bok_Lewy",
+			Slot:      1,
+			MakroName: "gorny",
+			Changes: []Change{
+				{OldName: &x, NewName: &x, OldValue: "", NewValue: "0", Result: ValueAdded},
+				{OldName: &one, NewName: &one, OldValue: "1", NewValue: "", Result: ValueDeleted},
+				{OldName: &two, NewName: &two, OldValue: "2, with comma", NewValue: "3", Result: ValueChanged},
+				{OldName: &one, NewName: &global, OldValue: "4", NewValue: "5", Result: ValueChangedRemainedToLocal, Strategy: &keepLocal},
+			},
+		}},
+	}}
+}
+
+func TestWriteReportJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteReportJSON(&buf, testReports()); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	var records []ReportRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Errorf("report is not valid json: %s", err)
+		t.FailNow()
+	}
+	if len(records) != 4 {
+		t.Errorf("wrong number of records: %d", len(records))
+		t.FailNow()
+	}
+	if records[0].Result != ValueAdded || records[0].OldName != "" || records[0].NewName != "x" {
+		t.Errorf("wrong added record: %+v", records[0])
+	}
+	if records[1].Result != ValueDeleted || records[1].OldValue != "1" || records[1].NewName != "" {
+		t.Errorf("wrong deleted record: %+v", records[1])
+	}
+	if records[2].Plate != "Bok_Lewy" || records[2].Element != "simple" || records[2].MakroName != "gorny" || records[2].Slot != 1 {
+		t.Errorf("wrong location of record: %+v", records[2])
+	}
+	if records[2].Strategy != nil || records[3].Strategy == nil || *records[3].Strategy != KeepLocal {
+		t.Errorf("strategy should be reported only for global conversion: %+v, %+v", records[2], records[3])
+	}
+}
+
+func TestWriteReportCSV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteReportCSV(&buf, testReports()); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Errorf("report is not valid csv: %s", err)
+		t.FailNow()
+	}
+	if len(rows) != 5 {
+		t.Errorf("wrong number of rows (with header): %d", len(rows))
+		t.FailNow()
+	}
+	changed := rows[3]
+	if changed[4] != "1" || changed[10] != "2, with comma" || changed[11] != "3" || changed[12] != "ValueChanged" || changed[13] != "" {
+		t.Errorf("wrong csv row: %s", changed)
+	}
+	last := rows[4]
+	if last[12] != "ValueChangedRemainedToLocal" || last[13] != "KeepLocal" {
+		t.Errorf("wrong csv row: %s", last)
+	}
+}
@@ -0,0 +1,120 @@
+package corpus
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+const CMKNewLine = "\r\n"
+
+type cmkSection struct {
+	name  string
+	nodes []GenericNodeWithDat
+	// sections like [VARIJABLE] are not numbered
+	numbered bool
+}
+
+// sections in the same order as Corpus writes them
+func (m *M1) cmkSections() []cmkSection {
+	sections := []cmkSection{{name: "VARIJABLE", nodes: []GenericNodeWithDat{m.Varijable}}}
+	if m.Joint != nil {
+		sections = append(sections, cmkSection{name: "JOINT", nodes: []GenericNodeWithDat{*m.Joint}})
+	}
+	if m.Formule != nil {
+		sections = append(sections, cmkSection{name: "FORMULE", nodes: []GenericNodeWithDat{*m.Formule}})
+	}
+	makro := make([]GenericNodeWithDat, len(m.Makro))
+	for i := range m.Makro {
+		makro[i] = m.Makro[i].GenericNodeWithDat
+	}
+	return append(sections,
+		cmkSection{name: "PILA", nodes: m.Pila, numbered: true},
+		cmkSection{name: "POTROSNI", nodes: m.Potrosni, numbered: true},
+		cmkSection{name: "POCKET", nodes: m.Pocket, numbered: true},
+		cmkSection{name: "RASTER", nodes: m.Raster, numbered: true},
+		cmkSection{name: "GRUPA", nodes: m.Grupa, numbered: true},
+		cmkSection{name: "MAKRO", nodes: makro, numbered: true},
+	)
+}
+
+/*
+Save makro as CMK file, the same way as Corpus does: Windows-1250, CRLF, numbered sections start from 1 ([MAKRO1]). See SaveWithOptions for other encodings.
+Submakros are not saved, only the [MAKRO] section that calls them.
+NewMakroFromCMKFile of saved file gives the same makro.
+*/
+func (m *M1) Save(w io.Writer) error {
+	return m.SaveWithComment(w, "")
+}
+
+// like Save, comment is written in the first line (ignored when reading)
+func (m *M1) SaveWithComment(w io.Writer, comment string) error {
+	return m.SaveWithOptions(w, CMKSaveOptions{Comment: comment})
+}
+
+type CMKSaveOptions struct {
+	// written in the first line as "// comment", ignored when reading
+	Comment string
+	// EncodingAuto is Windows-1250, the same as Corpus
+	Encoding TextEncoding
+}
+
+// like Save. Characters that can not be saved with options.Encoding are replaced with "?" and warning is logged
+func (m *M1) SaveWithOptions(w io.Writer, options CMKSaveOptions) error {
+	var header []string
+	if options.Comment != "" {
+		header = append(header, "// "+options.Comment)
+	}
+	return m.saveWithHeader(w, header, options.Encoding)
+}
+
+// like Save, header lines are written before first section
+func (m *M1) saveWithHeader(w io.Writer, header []string, encoding TextEncoding) error {
+	lines := slices.Clone(header)
+	for _, section := range m.cmkSections() {
+		for i, node := range section.nodes {
+			if len(lines) > 0 {
+				lines = append(lines, "")
+			}
+			if section.numbered {
+				lines = append(lines, fmt.Sprintf("[%s%d]", section.name, i+1))
+			} else {
+				lines = append(lines, fmt.Sprintf("[%s]", section.name))
+			}
+			lines = append(lines, DecodeDAT(node.DAT)...)
+		}
+	}
+	var text strings.Builder
+	for _, line := range lines {
+		text.WriteString(line + CMKNewLine)
+	}
+	encoded, unrepresentable, err := EncodeText(text.String(), encoding)
+	if err != nil {
+		return fmt.Errorf("can not write makro '%s': %w", m.MakroName, err)
+	}
+	logUnrepresentable(m.MakroName, encoding, unrepresentable)
+	if _, err := w.Write(encoded); err != nil {
+		return fmt.Errorf("can not write makro '%s': %w", m.MakroName, err)
+	}
+	return nil
+}
+
+// see SaveWithComment
+func (m *M1) SaveToFile(makroFile string, comment string) error {
+	return m.SaveToFileWithOptions(makroFile, CMKSaveOptions{Comment: comment})
+}
+
+// see SaveWithOptions
+func (m *M1) SaveToFileWithOptions(makroFile string, options CMKSaveOptions) error {
+	f, err := os.Create(makroFile)
+	if err != nil {
+		return err
+	}
+	err = m.SaveWithOptions(f, options)
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package corpus
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// expected output of Save for every file in pathToCMKTestData
+var pathToCMKGoldenTestData = filepath.Join("..", "..", "tests", "testData", "CMK-golden")
+
+func TestM1SaveGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(pathToCMKTestData, "*.CMK"))
+	if err != nil || len(files) == 0 {
+		t.Error("no test files", err)
+		t.FailNow()
+	}
+	for _, file := range files {
+		makro, err := partialNewMakroFromCMKFile("", file)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var saved bytes.Buffer
+		if err := makro.Save(&saved); err != nil {
+			t.Error(err)
+			continue
+		}
+		golden, err := os.ReadFile(filepath.Join(pathToCMKGoldenTestData, filepath.Base(file)))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(saved.Bytes(), golden) {
+			t.Errorf("saved makro is different from golden file: %s\n%s", file, saved.String())
+		}
+
+		savedFile := filepath.Join(t.TempDir(), filepath.Base(file))
+		os.WriteFile(savedFile, saved.Bytes(), 0644)
+		reloaded, err := partialNewMakroFromCMKFile("", savedFile)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(makro, reloaded) {
+			t.Errorf("reloaded makro is different: %s\n%v\n%v", file, makro, reloaded)
+		}
+	}
+}
+
+func TestM1SaveRoundTrip(t *testing.T) {
+	makro := &M1{
+		MakroName: "zażółć",
+		Varijable: GenericNodeWithDat{DAT: `"// komentarz ąę",szerokość=18,"x=if(a=1; 2; 3)",,"y=a,b c"`},
+		Formule:   &GenericNodeWithDat{},
+		Pila:      []GenericNodeWithDat{{DAT: "J=1"}, {DAT: "J=2"}},
+	}
+	savedFile := filepath.Join(t.TempDir(), "zażółć.CMK")
+	if err := makro.SaveToFile(savedFile, "comment"); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	saved, _ := os.ReadFile(savedFile)
+	expected := "// comment\r\n\r\n[VARIJABLE]\r\n// komentarz \xb9\xea\r\nszeroko\x9c\xe6=18\r\nx=if(a=1; 2; 3)\r\n\r\ny=a,b c\r\n\r\n[FORMULE]\r\n\r\n[PILA1]\r\nJ=1\r\n\r\n[PILA2]\r\nJ=2\r\n"
+	if string(saved) != expected {
+		t.Errorf("saved makro is bad: %q", saved)
+	}
+	reloaded, err := partialNewMakroFromCMKFile("zażółć", savedFile)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// empty line is skipped when reading
+	if reloaded.Varijable.DAT != `"// komentarz ąę",szerokość=18,"x=if(a=1; 2; 3)","y=a,b c"` {
+		t.Errorf("Varijable is bad: %s", reloaded.Varijable.DAT)
+	}
+	if reloaded.Formule == nil || reloaded.Formule.DAT != "" {
+		t.Errorf("empty Formule is lost: %v", reloaded.Formule)
+	}
+	if !reflect.DeepEqual(reloaded.Pila, makro.Pila) {
+		t.Errorf("Pila is bad: %v", reloaded.Pila)
+	}
+}
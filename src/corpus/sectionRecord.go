@@ -0,0 +1,330 @@
+package corpus
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// single line of section: "KEY=value" or comment ("// text", "//=Kołek_3D"), line without "=" or with empty key ("=x")
+type SectionLine struct {
+	// empty for comments
+	Key   string
+	Value string
+	// line as it is in DAT (with quotes), empty for new or changed line
+	raw string
+}
+
+func (l SectionLine) IsComment() bool {
+	return l.Key == ""
+}
+
+func (l SectionLine) String() string {
+	if l.IsComment() {
+		return l.Value
+	}
+	return l.Key + "=" + l.Value
+}
+
+/*
+Section of makro (DAT of MSPI, MSGR, MSPO...) as ordered list of lines, see DecodeDAT. Lines that were not changed are written back exactly
+as they were read, so ParseSectionRecord(dat).DAT() == dat. Keys are not case sensitive.
+*/
+type SectionRecord struct {
+	Lines []SectionLine
+	// DAT as it was parsed, returned by DAT when no line was changed
+	dat string
+}
+
+func ParseSectionRecord(dat string) SectionRecord {
+	record := SectionRecord{Lines: []SectionLine{}}
+	for _, token := range tokenizeDAT(dat) {
+		key, value, found := strings.Cut(token.value, "=")
+		// line with empty key ("=x") is kept as it is, like line without "="
+		if !found || key == "" || strings.HasPrefix(token.value, "//") {
+			record.Lines = append(record.Lines, SectionLine{Value: token.value, raw: token.raw})
+			continue
+		}
+		record.Lines = append(record.Lines, SectionLine{Key: key, Value: value, raw: token.raw})
+	}
+	record.dat = dat
+	return record
+}
+
+func (r *SectionRecord) find(key string) int {
+	return slices.IndexFunc(r.Lines, func(line SectionLine) bool {
+		return !line.IsComment() && strings.EqualFold(line.Key, key)
+	})
+}
+
+// value of first line with key
+func (r *SectionRecord) Get(key string) (string, bool) {
+	index := r.find(key)
+	if index < 0 {
+		return "", false
+	}
+	return r.Lines[index].Value, true
+}
+
+// changes value of first line with key, new key is appended at the end
+func (r *SectionRecord) Set(key string, value string) {
+	index := r.find(key)
+	if index < 0 {
+		r.Lines = append(r.Lines, SectionLine{Key: key, Value: value})
+		return
+	}
+	if r.Lines[index].Value != value {
+		r.Lines[index].Value = value
+		r.Lines[index].raw = ""
+	}
+}
+
+// removes first line with key, returns false if there is no such key
+func (r *SectionRecord) Delete(key string) bool {
+	index := r.find(key)
+	if index < 0 {
+		return false
+	}
+	r.Lines = slices.Delete(r.Lines, index, index+1)
+	return true
+}
+
+// keys in order of lines, comments are skipped
+func (r *SectionRecord) Keys() []string {
+	keys := []string{}
+	for _, line := range r.Lines {
+		if !line.IsComment() {
+			keys = append(keys, line.Key)
+		}
+	}
+	return keys
+}
+
+// unchanged lines keep their quoting, changed lines are quoted like EncodeDAT does
+func (r SectionRecord) DAT() string {
+	lines := make([]string, len(r.Lines))
+	for i, line := range r.Lines {
+		lines[i] = line.String()
+	}
+	// whitespace between lines is kept too
+	if slices.Equal(lines, DecodeDAT(r.dat)) {
+		return r.dat
+	}
+	if len(lines) == 1 && lines[0] == "" {
+		return EncodeDAT(lines)
+	}
+	for i, line := range r.Lines {
+		if line.raw != "" && decodedDATLine(line.raw) == lines[i] {
+			lines[i] = line.raw
+		} else {
+			lines[i] = quoteDATLine(lines[i])
+		}
+	}
+	return strings.Join(lines, CMKLineSeparator)
+}
+
+// value of single line as it is in DAT
+func decodedDATLine(raw string) string {
+	tokens := tokenizeDAT(raw)
+	if len(tokens) != 1 {
+		return ""
+	}
+	return tokens[0].value
+}
+
+/*
+Fields of typed records (PilaRecord, PotrosniRecord...) are tagged with key of line: `cmk:"GB"`. Record field holds all lines,
+including keys that have no field and comments. Meaning of keys is reverse engineered from files saved by Corpus.
+*/
+
+// [PILA] saw cut / groove: "J=1,GB=if(typ_plecow=3;1;0),"GN=frezowanie pila",GD=wpust_glebokosc,..."
+type PilaRecord struct {
+	Record SectionRecord `cmk:"-"`
+	J      string        `cmk:"J"`
+	// condition, operation is done if it is not 0
+	GB string `cmk:"GB"`
+	// name
+	GN string `cmk:"GN"`
+	// depth
+	GD  string `cmk:"GD"`
+	GX  string `cmk:"GX"`
+	GY  string `cmk:"GY"`
+	PX  string `cmk:"PX"`
+	PY  string `cmk:"PY"`
+	GS  string `cmk:"GS"`
+	PSP string `cmk:"PSP"`
+}
+
+// [GRUPA] group of operations
+type GrupaRecord struct {
+	Record SectionRecord `cmk:"-"`
+	J      string        `cmk:"J"`
+	GB     string        `cmk:"GB"`
+	GN     string        `cmk:"GN"`
+}
+
+/*
+[POTROSNI] hardware (dowels, minifix...): "J=0,RT=0,GB=1,PP1=1,PS1=100.10.100,PK1=...,FX1=...,FY1=...,FZ1=...,FS1=0".
+Items are numbered from 1: PS<n> article, PK<n> condition, FX<n>, FY<n>, FZ<n> position, FS<n> side of plate, use Record.Get("PS2")
+*/
+type PotrosniRecord struct {
+	Record SectionRecord `cmk:"-"`
+	J      string        `cmk:"J"`
+	RT     string        `cmk:"RT"`
+	GB     string        `cmk:"GB"`
+}
+
+// [POCKET] pocket milling
+type PocketRecord struct {
+	Record SectionRecord `cmk:"-"`
+	J      string        `cmk:"J"`
+	GB     string        `cmk:"GB"`
+	GN     string        `cmk:"GN"`
+}
+
+// [RASTER] row of holes
+type RasterRecord struct {
+	Record SectionRecord `cmk:"-"`
+	J      string        `cmk:"J"`
+	GB     string        `cmk:"GB"`
+	GN     string        `cmk:"GN"`
+}
+
+// fills Record and tagged fields of record (pointer to typed record) from dat
+func decodeSectionRecord(dat string, record any) {
+	v := reflect.ValueOf(record).Elem()
+	sectionRecord := ParseSectionRecord(dat)
+	v.FieldByName("Record").Set(reflect.ValueOf(sectionRecord))
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("cmk")
+		if key == "" || key == "-" {
+			continue
+		}
+		value, _ := sectionRecord.Get(key)
+		v.Field(i).SetString(value)
+	}
+}
+
+// DAT of record, tagged fields override lines of Record. Field that is empty and not in Record is not added
+func encodeSectionRecord(record any) string {
+	v := reflect.ValueOf(record).Elem()
+	sectionRecord := v.FieldByName("Record").Interface().(SectionRecord)
+	sectionRecord.Lines = slices.Clone(sectionRecord.Lines)
+	for i := 0; i < v.NumField(); i++ {
+		key := v.Type().Field(i).Tag.Get("cmk")
+		if key == "" || key == "-" {
+			continue
+		}
+		value := v.Field(i).String()
+		if _, found := sectionRecord.Get(key); found || value != "" {
+			sectionRecord.Set(key, value)
+		}
+	}
+	return sectionRecord.DAT()
+}
+
+func (r *PilaRecord) DAT() string {
+	return encodeSectionRecord(r)
+}
+
+func (r *GrupaRecord) DAT() string {
+	return encodeSectionRecord(r)
+}
+
+func (r *PotrosniRecord) DAT() string {
+	return encodeSectionRecord(r)
+}
+
+func (r *PocketRecord) DAT() string {
+	return encodeSectionRecord(r)
+}
+
+func (r *RasterRecord) DAT() string {
+	return encodeSectionRecord(r)
+}
+
+func decodeSectionRecords[T any](nodes []GenericNodeWithDat) []*T {
+	records := make([]*T, len(nodes))
+	for i := range nodes {
+		records[i] = new(T)
+		decodeSectionRecord(nodes[i].DAT, records[i])
+	}
+	return records
+}
+
+// n-th record is written to n-th node, so attributes of existing nodes are kept
+func encodeSectionRecords[T any](nodes []GenericNodeWithDat, records []*T) []GenericNodeWithDat {
+	if len(records) == 0 {
+		return nil
+	}
+	encoded := make([]GenericNodeWithDat, len(records))
+	for i, record := range records {
+		if i < len(nodes) {
+			encoded[i] = nodes[i]
+		}
+		encoded[i].DAT = encodeSectionRecord(record)
+	}
+	return encoded
+}
+
+func (m *M1) PilaRecords() []*PilaRecord {
+	return decodeSectionRecords[PilaRecord](m.Pila)
+}
+
+func (m *M1) GrupaRecords() []*GrupaRecord {
+	return decodeSectionRecords[GrupaRecord](m.Grupa)
+}
+
+func (m *M1) PotrosniRecords() []*PotrosniRecord {
+	return decodeSectionRecords[PotrosniRecord](m.Potrosni)
+}
+
+func (m *M1) PocketRecords() []*PocketRecord {
+	return decodeSectionRecords[PocketRecord](m.Pocket)
+}
+
+func (m *M1) RasterRecords() []*RasterRecord {
+	return decodeSectionRecords[RasterRecord](m.Raster)
+}
+
+func (m *M1) SetPilaRecords(records []*PilaRecord) {
+	m.Pila = encodeSectionRecords(m.Pila, records)
+}
+
+func (m *M1) SetGrupaRecords(records []*GrupaRecord) {
+	m.Grupa = encodeSectionRecords(m.Grupa, records)
+}
+
+func (m *M1) SetPotrosniRecords(records []*PotrosniRecord) {
+	m.Potrosni = encodeSectionRecords(m.Potrosni, records)
+}
+
+func (m *M1) SetPocketRecords(records []*PocketRecord) {
+	m.Pocket = encodeSectionRecords(m.Pocket, records)
+}
+
+func (m *M1) SetRasterRecords(records []*RasterRecord) {
+	m.Raster = encodeSectionRecords(m.Raster, records)
+}
+
+// see GenericNodeWithC6Dat.DecodeC6Dat
+func ParseSectionRecordC6Dat(node *GenericNodeWithC6Dat) (SectionRecord, error) {
+	dat, err := node.DecodeC6Dat()
+	if err != nil {
+		return SectionRecord{}, err
+	}
+	return ParseSectionRecord(dat), nil
+}
+
+// C6DAT is encoded only if DAT of section changed, so untouched section keeps exactly the same C6DAT
+func SetSectionDATC6Dat(node *GenericNodeWithC6Dat, dat string) error {
+	if decoded, err := node.DecodeC6Dat(); err == nil && decoded == dat {
+		return nil
+	}
+	encoded, err := EncodeC6Dat(dat)
+	if err != nil {
+		return err
+	}
+	node.C6DAT = *encoded
+	return nil
+}
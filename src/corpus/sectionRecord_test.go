@@ -0,0 +1,123 @@
+package corpus
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSectionRecordRoundTrip(t *testing.T) {
+	tests := []string{
+		"",
+		"J=1",
+		`J=1,GB=if(typ_plecow=3;1;0),"GN=frezowanie pila",GD=wpust_glebokosc`,
+		`//=Kołek_3D,J=0,"// X position - pozycja X",FX1=przesuniecie_lewej`,
+		`J=1,,GB=1,`,
+		`"GN=a,b",J="1"`,
+		`J=1, "GN=spacja po przecinku"`,
+		`"GN=cudzyslow ""w"" nazwie"`,
+	}
+	for _, dat := range tests {
+		record := ParseSectionRecord(dat)
+		if record.DAT() != dat {
+			t.Errorf("expected '%s', got '%s'", dat, record.DAT())
+		}
+		pila := decodeSectionRecords[PilaRecord]([]GenericNodeWithDat{{DAT: dat}})[0]
+		if pila.DAT() != dat {
+			t.Errorf("typed record: expected '%s', got '%s'", dat, pila.DAT())
+		}
+	}
+}
+
+func TestSectionRecordEmptyKey(t *testing.T) {
+	record := ParseSectionRecord("J=1,=x")
+	record.Set("J", "2")
+	if record.DAT() != "J=2,=x" {
+		t.Errorf("line with empty key should be kept: '%s'", record.DAT())
+	}
+	if keys := record.Keys(); len(keys) != 1 || keys[0] != "J" {
+		t.Errorf("line with empty key is not a key: %v", keys)
+	}
+}
+
+func TestPilaRecordEdit(t *testing.T) {
+	makro := &M1{Pila: []GenericNodeWithDat{{DAT: `J=1,GB=if(typ_plecow=3;1;0),"GN=frezowanie pila",GD=wpust_glebokosc,XYZ=7,"// koniec"`}}}
+	records := makro.PilaRecords()
+	if len(records) != 1 {
+		t.Errorf("wrong number of records: %d", len(records))
+		t.FailNow()
+	}
+	pila := records[0]
+	if pila.J != "1" || pila.GB != "if(typ_plecow=3;1;0)" || pila.GN != "frezowanie pila" || pila.GD != "wpust_glebokosc" || pila.GX != "" {
+		t.Errorf("wrong fields: %+v", pila)
+	}
+	if value, found := pila.Record.Get("xyz"); !found || value != "7" {
+		t.Errorf("unknown key should be in record: '%s' %t", value, found)
+	}
+	pila.GD = "12"
+	pila.GN = "pila 4 mm"
+	pila.GX = "0"
+	makro.SetPilaRecords(records)
+	expected := `J=1,GB=if(typ_plecow=3;1;0),"GN=pila 4 mm",GD=12,XYZ=7,"// koniec",GX=0`
+	if makro.Pila[0].DAT != expected {
+		t.Errorf("expected '%s', got '%s'", expected, makro.Pila[0].DAT)
+	}
+}
+
+func TestSectionRecordSetDelete(t *testing.T) {
+	record := ParseSectionRecord("J=0,RT=0,PS1=100.10.100,PS2=264.43.081")
+	record.Set("ps2", "minifix_3d")
+	if !record.Delete("RT") || record.Delete("RT") {
+		t.Errorf("RT should be deleted once")
+	}
+	if record.DAT() != "J=0,PS1=100.10.100,PS2=minifix_3d" {
+		t.Errorf("wrong DAT: %s", record.DAT())
+	}
+	if keys := record.Keys(); len(keys) != 3 || keys[2] != "PS2" {
+		t.Errorf("wrong keys: %v", keys)
+	}
+}
+
+func TestSectionRecordsOfCorpusFile(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(pathToE3DTestDataVertsion17, "*.E3D"))
+	if err != nil || len(files) == 0 {
+		t.Errorf("no test files: %s", err)
+		t.FailNow()
+	}
+	potrosni := 0
+	for _, inputFile := range files {
+		_, elementFile, err := NewCorpusFile(inputFile)
+		if err != nil {
+			t.Error(err)
+			t.FailNow()
+		}
+		var visit func(makro *MM1)
+		visit = func(makro *MM1) {
+			for j := range makro.Potrosni {
+				node := &makro.Potrosni[j]
+				c6dat := node.C6DAT
+				record, err := ParseSectionRecordC6Dat(node)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				potrosni++
+				if err := SetSectionDATC6Dat(node, record.DAT()); err != nil || node.C6DAT != c6dat {
+					t.Errorf("%s: C6DAT of unchanged section should stay the same: %s", inputFile, err)
+				}
+			}
+			for j := range makro.Makro {
+				if makro.Makro[j].MAK != nil {
+					visit(makro.Makro[j].MAK)
+				}
+			}
+		}
+		elementFile.VisitElementsAndSubelements(func(element *Element) {
+			for i := range element.Elinks.MakLink {
+				visit(&element.Elinks.MakLink[i].MM1)
+			}
+		})
+	}
+	if potrosni == 0 {
+		t.Errorf("no [POTROSNI] in test files")
+	}
+}
@@ -0,0 +1,229 @@
+package corpus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+type StaleStatus int
+
+const (
+	MakroUpToDate StaleStatus = iota
+	MakroStale
+	MakroNotInLibrary
+)
+
+func (s StaleStatus) String() string {
+	switch s {
+	case MakroUpToDate:
+		return "up to date"
+	case MakroStale:
+		return "stale"
+	case MakroNotInLibrary:
+		return "not in library"
+	}
+	return fmt.Sprintf("StaleStatus(%d)", int(s))
+}
+
+func (s StaleStatus) MarshalText() ([]byte, error) {
+	switch s {
+	case MakroUpToDate:
+		return []byte("MakroUpToDate"), nil
+	case MakroStale:
+		return []byte("MakroStale"), nil
+	case MakroNotInLibrary:
+		return []byte("MakroNotInLibrary"), nil
+	}
+	return nil, fmt.Errorf("unknown StaleStatus: %d", int(s))
+}
+
+// single embedded makro compared with library
+type StaleRecord struct {
+	MakroName string      `json:"makro"`
+	File      string      `json:"file"`
+	Element   string      `json:"element"`
+	Plate     string      `json:"plate"`
+	CalledBy  string      `json:"calledBy"`
+	Status    StaleStatus `json:"status"`
+	// sections that differ from library, numbered like in CMK: "FORMULE", "PILA1", "MAKRO2"
+	Sections []string `json:"sections"`
+	// why makro could not be loaded from library
+	Error string `json:"error,omitempty"`
+}
+
+func compareSection(name string, embedded *GenericNodeWithDat, library *GenericNodeWithDat) []string {
+	embeddedDAT, libraryDAT := "", ""
+	if embedded != nil {
+		embeddedDAT = embedded.DAT
+	}
+	if library != nil {
+		libraryDAT = library.DAT
+	}
+	if !sameDATLines(embeddedDAT, libraryDAT) {
+		return []string{name}
+	}
+	return nil
+}
+
+// lines are compared, not how they are quoted: "GN=a" and ""GN=a"" are the same
+func sameDATLines(a string, b string) bool {
+	return slices.Equal(DecodeDAT(a), DecodeDAT(b))
+}
+
+func compareNumberedSections(name string, embedded []GenericNodeWithDat, library []GenericNodeWithDat) []string {
+	differences := []string{}
+	for i := range max(len(embedded), len(library)) {
+		if i >= len(embedded) || i >= len(library) || !sameDATLines(embedded[i].DAT, library[i].DAT) {
+			differences = append(differences, fmt.Sprintf("%s%d", name, i+1))
+		}
+	}
+	return differences
+}
+
+// keys of call in order, values are not included
+func makroCallKeys(call M1EmbeddedMakro) []string {
+	keys, _, _ := loadValuesFromSection(call.DAT)
+	for i := range keys {
+		keys[i] = strings.ToLower(keys[i])
+	}
+	return keys
+}
+
+/*
+[MAKRO] sections are compared by called submakro and keys of call. Values of call parameters (LACZ_BLENDA=1) are set in project
+and kept by replacement (see updateSubmakroCalls), so they are not compared.
+*/
+func compareMakroCalls(embedded []M1EmbeddedMakro, library []M1EmbeddedMakro) []string {
+	differences := []string{}
+	for i := range max(len(embedded), len(library)) {
+		if i >= len(embedded) || i >= len(library) ||
+			!strings.EqualFold(embedded[i].CalledWith(), library[i].CalledWith()) ||
+			!slices.Equal(makroCallKeys(embedded[i]), makroCallKeys(library[i])) {
+			differences = append(differences, fmt.Sprintf("MAKRO%d", i+1))
+		}
+	}
+	return differences
+}
+
+// sections other than [VARIJABLE] and [JOINT] that are different. Submakros are compared only by [MAKRO] section that calls them
+func DiffMakroSections(embedded *M1, library *M1) []string {
+	differences := compareSection("FORMULE", embedded.Formule, library.Formule)
+	differences = append(differences, compareNumberedSections("PILA", embedded.Pila, library.Pila)...)
+	differences = append(differences, compareNumberedSections("POTROSNI", embedded.Potrosni, library.Potrosni)...)
+	differences = append(differences, compareNumberedSections("POCKET", embedded.Pocket, library.Pocket)...)
+	differences = append(differences, compareNumberedSections("RASTER", embedded.Raster, library.Raster)...)
+	differences = append(differences, compareNumberedSections("GRUPA", embedded.Grupa, library.Grupa)...)
+	differences = append(differences, compareMakroCalls(embedded.Makro, library.Makro)...)
+	return differences
+}
+
+// compares every makro and submakro in inputFiles with library. Files that can not be read are skipped, errors are returned
+func FindStaleMakros(inputFiles []string, library *MakroLibrary) ([]StaleRecord, error) {
+	records := []StaleRecord{}
+	var errs []error
+	for _, inputFile := range inputFiles {
+		err := VisitMakrosInCorpusFile(inputFile, func(name string, makro *M1, usage MakroUsage) {
+			// makro that is not saved in any file
+			if name == "" {
+				return
+			}
+			record := StaleRecord{
+				MakroName: name,
+				File:      usage.File,
+				Element:   usage.Element,
+				Plate:     usage.Plate,
+				CalledBy:  usage.CalledBy,
+				Sections:  []string{},
+			}
+			libraryMakro, err := library.Get(name)
+			if err != nil {
+				record.Status = MakroNotInLibrary
+				record.Error = err.Error()
+			} else if record.Sections = DiffMakroSections(makro, libraryMakro); len(record.Sections) > 0 {
+				record.Status = MakroStale
+			}
+			records = append(records, record)
+		})
+		if err != nil {
+			err = fmt.Errorf("%s: %w", inputFile, err)
+			errs = append(errs, err)
+		}
+	}
+	return records, errors.Join(errs...)
+}
+
+// files with at least one stale makro, in order of records
+func StaleFiles(records []StaleRecord) []string {
+	files := []string{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		if record.Status == MakroStale && !seen[record.File] {
+			seen[record.File] = true
+			files = append(files, record.File)
+		}
+	}
+	return files
+}
+
+// human readable report, makros that are up to date are omitted unless all is set
+func WriteStaleReportText(w io.Writer, records []StaleRecord, all bool) error {
+	counts := map[StaleStatus]int{}
+	previousFile := ""
+	for _, record := range records {
+		counts[record.Status]++
+		if record.Status == MakroUpToDate && !all {
+			continue
+		}
+		if record.File != previousFile {
+			fmt.Fprintf(w, "File: '%s'\n", record.File)
+			previousFile = record.File
+		}
+		fmt.Fprintf(w, "  Cabinet '%s', plate '%s', makro '%s'", record.Element, record.Plate, record.MakroName)
+		if record.CalledBy != "" {
+			fmt.Fprintf(w, " (called by '%s')", record.CalledBy)
+		}
+		fmt.Fprintf(w, ": %s", record.Status)
+		switch record.Status {
+		case MakroStale:
+			fmt.Fprintf(w, ", different: %s", strings.Join(record.Sections, ", "))
+		case MakroNotInLibrary:
+			fmt.Fprintf(w, ": %s", record.Error)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "Makros: %d up to date, %d stale, %d not in library. Files to update: %d\n",
+		counts[MakroUpToDate], counts[MakroStale], counts[MakroNotInLibrary], len(StaleFiles(records)))
+	return err
+}
+
+func WriteStaleReportJSON(w io.Writer, records []StaleRecord) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+var staleCSVHeader = []string{"makro", "file", "element", "plate", "calledBy", "status", "sections", "error"}
+
+func WriteStaleReportCSV(w io.Writer, records []StaleRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(staleCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		status, err := r.Status.MarshalText()
+		if err != nil {
+			return err
+		}
+		row := []string{r.MakroName, r.File, r.Element, r.Plate, r.CalledBy, string(status), strings.Join(r.Sections, " "), r.Error}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,76 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindStaleMakros(t *testing.T) {
+	upToDateFile := filepath.Join(pathToE3DTestDataVertsion17, "simple_macro_in_macro.E3D")
+	staleFile := filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D")
+	makros, err := ExtractMakros([]string{upToDateFile})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	// library built from the same file is up to date, gorny is taken from simple.CMK which is different
+	makroDir := t.TempDir()
+	if _, err := WriteExtractedMakros(makroDir, makros, false, EncodingAuto); err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	cmk, _ := os.ReadFile(filepath.Join(pathToCMKTestData, "simple.CMK"))
+	os.WriteFile(filepath.Join(makroDir, "gorny.CMK"), cmk, 0644)
+
+	records, err := FindStaleMakros([]string{upToDateFile, staleFile}, NewMakroLibrary(makroDir, nil))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	statuses := map[string]StaleStatus{}
+	for _, record := range records {
+		if record.File == upToDateFile && record.Status != MakroUpToDate {
+			t.Errorf("makro should be up to date: %v", record)
+		}
+		if record.File == staleFile {
+			statuses[record.MakroName] = record.Status
+			if record.MakroName == "gorny" && !slices.Equal(record.Sections, []string{"FORMULE", "PILA1", "POTROSNI1", "POCKET1", "RASTER1", "GRUPA1"}) {
+				t.Errorf("wrong sections: %v", record.Sections)
+			}
+		}
+	}
+	if statuses["gorny"] != MakroStale || statuses["lewy"] != MakroNotInLibrary {
+		t.Errorf("wrong statuses: %v", statuses)
+	}
+	if files := StaleFiles(records); !slices.Equal(files, []string{staleFile}) {
+		t.Errorf("wrong stale files: %v", files)
+	}
+}
+
+func TestDiffMakroSections(t *testing.T) {
+	embedded := &M1{
+		Varijable: GenericNodeWithDat{DAT: "x=1"},
+		Pila:      []GenericNodeWithDat{{DAT: "J=1"}, {DAT: "J=2"}},
+		Makro:     []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "NAME=a"}}},
+	}
+	library := &M1{
+		Varijable: GenericNodeWithDat{DAT: "x=2"},
+		Formule:   &GenericNodeWithDat{},
+		Pila:      []GenericNodeWithDat{{DAT: "J=1"}},
+		Makro:     []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "NAME=b"}}},
+	}
+	// empty [FORMULE] is the same as missing one, [VARIJABLE] is not compared
+	if differences := DiffMakroSections(embedded, library); !slices.Equal(differences, []string{"PILA2", "MAKRO1"}) {
+		t.Errorf("wrong differences: %v", differences)
+	}
+}
+
+func TestDiffMakroSectionsQuoting(t *testing.T) {
+	embedded := &M1{Pila: []GenericNodeWithDat{{DAT: `J=1,"GN=rowek",  GD=5`}}, Formule: &GenericNodeWithDat{DAT: `"a=1"`}}
+	library := &M1{Pila: []GenericNodeWithDat{{DAT: `J=1,GN=rowek,GD=5`}}, Formule: &GenericNodeWithDat{DAT: `a=1`}}
+	if differences := DiffMakroSections(embedded, library); len(differences) != 0 {
+		t.Errorf("quoting and spaces between lines should not be compared: %v", differences)
+	}
+}
@@ -0,0 +1,146 @@
+package corpus
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+/*
+Encoding of text files. Corpus writes CMK files with Windows-1250, E3D files are UTF-8 with BOM.
+CMK files edited in other editors (VS Code) are often saved as UTF-8, so encoding of CMK is detected when reading:
+
+  - file starts with UTF-8 BOM: EncodingUTF8BOM
+  - file is valid UTF-8 and has non-ASCII characters: EncodingUTF8. Polish text in Windows-1250 is almost never valid UTF-8
+  - otherwise: EncodingWindows1250
+*/
+type TextEncoding int
+
+const (
+	// detect when reading, Windows-1250 when writing
+	EncodingAuto TextEncoding = iota
+	EncodingWindows1250
+	EncodingUTF8
+	EncodingUTF8BOM
+)
+
+var textEncodingNames = map[TextEncoding]string{
+	EncodingAuto:        "auto",
+	EncodingWindows1250: "windows-1250",
+	EncodingUTF8:        "utf-8",
+	EncodingUTF8BOM:     "utf-8-bom",
+}
+
+func (e TextEncoding) String() string {
+	name, found := textEncodingNames[e]
+	if !found {
+		return fmt.Sprintf("TextEncoding(%d)", int(e))
+	}
+	return name
+}
+
+func (e TextEncoding) MarshalText() ([]byte, error) {
+	name, found := textEncodingNames[e]
+	if !found {
+		return nil, fmt.Errorf("unknown TextEncoding: %d", int(e))
+	}
+	return []byte(name), nil
+}
+
+func (e *TextEncoding) UnmarshalText(text []byte) error {
+	for encoding, name := range textEncodingNames {
+		if strings.EqualFold(name, string(text)) {
+			*e = encoding
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown encoding: '%s', use one of: auto, windows-1250, utf-8, utf-8-bom", text)
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// see TextEncoding, never returns EncodingAuto
+func DetectEncoding(data []byte) TextEncoding {
+	if bytes.HasPrefix(data, utf8BOM) {
+		return EncodingUTF8BOM
+	}
+	if utf8.Valid(data) && hasNonASCII(data) {
+		return EncodingUTF8
+	}
+	return EncodingWindows1250
+}
+
+func hasNonASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// text of data and encoding that was used, EncodingAuto is detected. BOM is removed
+func DecodeText(data []byte, encoding TextEncoding) (string, TextEncoding, error) {
+	if encoding == EncodingAuto {
+		encoding = DetectEncoding(data)
+	}
+	switch encoding {
+	case EncodingUTF8, EncodingUTF8BOM:
+		return string(bytes.TrimPrefix(data, utf8BOM)), encoding, nil
+	default:
+		text, err := charmap.Windows1250.NewDecoder().Bytes(data)
+		return string(text), EncodingWindows1250, err
+	}
+}
+
+// characters of text that can not be written with encoding, every character once
+func UnrepresentableCharacters(text string, encoding TextEncoding) []rune {
+	unrepresentable := []rune{}
+	if encoding == EncodingUTF8 || encoding == EncodingUTF8BOM {
+		return unrepresentable
+	}
+	for _, r := range text {
+		if _, ok := charmap.Windows1250.EncodeRune(r); !ok && !strings.ContainsRune(string(unrepresentable), r) {
+			unrepresentable = append(unrepresentable, r)
+		}
+	}
+	return unrepresentable
+}
+
+// text encoded with encoding (EncodingAuto is Windows-1250). Characters that can not be encoded are replaced with "?" and returned
+func EncodeText(text string, encoding TextEncoding) ([]byte, []rune, error) {
+	unrepresentable := UnrepresentableCharacters(text, encoding)
+	switch encoding {
+	case EncodingUTF8:
+		return []byte(text), unrepresentable, nil
+	case EncodingUTF8BOM:
+		return append(slices.Clone(utf8BOM), text...), unrepresentable, nil
+	}
+	if len(unrepresentable) > 0 {
+		text = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(string(unrepresentable), r) {
+				return '?'
+			}
+			return r
+		}, text)
+	}
+	encoded, err := charmap.Windows1250.NewEncoder().Bytes([]byte(text))
+	return encoded, unrepresentable, err
+}
+
+// warning for characters replaced by EncodeText
+func logUnrepresentable(makroName string, encoding TextEncoding, unrepresentable []rune) {
+	if len(unrepresentable) == 0 {
+		return
+	}
+	if makroName == "" {
+		log.Printf("Warning: text has characters that can not be saved with %s, replaced with '?': %q", encoding, string(unrepresentable))
+		return
+	}
+	log.Printf("Warning: makro '%s' has characters that can not be saved with %s, replaced with '?': %q", makroName, encoding, string(unrepresentable))
+}
@@ -0,0 +1,103 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	type testCase struct {
+		data     string
+		expected TextEncoding
+	}
+	tests := []testCase{
+		{"", EncodingWindows1250},
+		{"a=1", EncodingWindows1250},
+		{"szeroko\x9c\xe6=18", EncodingWindows1250},
+		{"szerokość=18", EncodingUTF8},
+		{"\xef\xbb\xbfa=1", EncodingUTF8BOM},
+	}
+	for _, test := range tests {
+		if encoding := DetectEncoding([]byte(test.data)); encoding != test.expected {
+			t.Errorf("%q: expected %s, got %s", test.data, test.expected, encoding)
+		}
+	}
+}
+
+func TestEncodeDecodeText(t *testing.T) {
+	text := "// zażółć gęślą jaźń €"
+	for _, encoding := range []TextEncoding{EncodingWindows1250, EncodingUTF8, EncodingUTF8BOM} {
+		encoded, unrepresentable, err := EncodeText(text, encoding)
+		if err != nil || len(unrepresentable) != 0 {
+			t.Errorf("%s: %q %s", encoding, string(unrepresentable), err)
+		}
+		decoded, detected, err := DecodeText(encoded, EncodingAuto)
+		if err != nil || decoded != text || detected != encoding {
+			t.Errorf("%s: decoded as %s: '%s' %s", encoding, detected, decoded, err)
+		}
+	}
+	encoded, unrepresentable, err := EncodeText("a→b ж ż", EncodingAuto)
+	if err != nil || string(encoded) != "a?b ? \xbf" || string(unrepresentable) != "→ж" {
+		t.Errorf("unrepresentable characters should be replaced: %q %q %s", encoded, string(unrepresentable), err)
+	}
+}
+
+func TestReadMakroUTF8(t *testing.T) {
+	dir := t.TempDir()
+	windows1250File := filepath.Join(dir, "windows1250.CMK")
+	os.WriteFile(windows1250File, []byte("[VARIJABLE]\r\n// szeroko\x9c\xe6\r\nszeroko\x9c\xe6=18\r\n"), 0644)
+	utf8File := filepath.Join(dir, "utf8.CMK")
+	os.WriteFile(utf8File, []byte("\xef\xbb\xbf[VARIJABLE]\n// szerokość\nszerokość=18\n"), 0644)
+
+	expected, err := partialNewMakroFromCMKFile("makro", windows1250File)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	makro, err := partialNewMakroFromCMKFile("makro", utf8File)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(expected, makro) {
+		t.Errorf("UTF-8 makro is read differently: %s", makro.Varijable.DAT)
+	}
+
+	var saved bytes.Buffer
+	if err := makro.SaveWithOptions(&saved, CMKSaveOptions{Encoding: EncodingUTF8BOM}); err != nil {
+		t.Error(err)
+	}
+	if saved.String() != "\xef\xbb\xbf[VARIJABLE]\r\n// szerokość\r\nszerokość=18\r\n" {
+		t.Errorf("bad UTF-8 makro: %q", saved.String())
+	}
+}
+
+func TestEncodeCorpusFileKeepsBOM(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join(pathToE3DTestDataVertsion17, "simple.E3D"))
+	if err != nil || !bytes.HasPrefix(input, utf8BOM) {
+		t.Errorf("test file should start with BOM: %s", err)
+		t.FailNow()
+	}
+	handle := func(decoder *xml.Decoder, start xml.StartElement) xml.Token {
+		var elementFile ElementFile
+		decoder.DecodeElement(&elementFile, &start)
+		return elementFile
+	}
+	logger := log.New(io.Discard, "", 0)
+	for _, data := range [][]byte{input, input[len(utf8BOM):]} {
+		output, err := encodeCorpusFile(logger, data, false, handle, nil)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if bytes.HasPrefix(data, utf8BOM) != bytes.HasPrefix(output, utf8BOM) || bytes.Count(output, utf8BOM) > 1 {
+			t.Errorf("BOM should be kept as it is: %q", output[:10])
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package corpus
 
 import (
+	"fmt"
 	"log"
-	"regexp"
 	"strings"
 )
 
-func CMKFindName(oldVariablesNames []string, name string) (string, bool) {
+// name is not case sensitive and "_" prefix is ignored. If name is not found its renamed name is searched (see VariableRenames), renames can be nil
+func CMKFindName(oldVariablesNames []string, name string, renames VariableRenames) (string, bool) {
 	cleanupName, _ := strings.CutPrefix(name, "_")
 	cleanupName = strings.ToLower(cleanupName)
 	for index, possibleMatch := range oldVariablesNames {
@@ -16,6 +17,11 @@ func CMKFindName(oldVariablesNames []string, name string) (string, bool) {
 			return oldVariablesNames[index], true
 		}
 	}
+	if renamed, found := renames.Find(name); found {
+		if match, found := CMKFindName(oldVariablesNames, renamed, nil); found {
+			return match, true
+		}
+	}
 	return name, false
 }
 
@@ -24,8 +30,7 @@ func loadValuesFromSection(DAT string) ([]string, map[string]string, map[string]
 	variablesComments := map[string][]string{}
 	lastName := ""
 	values := map[string]string{}
-	for _, line := range strings.Split(DAT, CMKLineSeparator) {
-		lineTrimmed := decodeCMKLine(line)
+	for _, lineTrimmed := range DecodeDAT(DAT) {
 		isComment := strings.HasPrefix(lineTrimmed, "//")
 		if isComment {
 			variablesComments[lastName] = append(variablesComments[lastName], lineTrimmed)
@@ -35,13 +40,107 @@ func loadValuesFromSection(DAT string) ([]string, map[string]string, map[string]
 			variablesKeys = append(variablesKeys, lastName)
 			values[lastName] = nameValue[1]
 		} else {
-			log.Printf("DEBUG: unknown line when updating macro: '%s'", line)
+			log.Printf("DEBUG: unknown line when updating macro: '%s'", lineTrimmed)
 		}
 	}
 	return variablesKeys, values, variablesComments
 }
 
-var OnlyDigitsRegex = regexp.MustCompile(`\d*`)
+/*
+What to do when new makro adds "_" prefix to variable that was local in old makro. Global variable takes its value from "evar",
+so converting it discards the old local value:
+
+	[VARIJABLE] // old
+	grubosc=32
+
+	[VARIJABLE] // new
+	_grubosc=18
+
+	[VARIJABLE] // output, converted to global
+	_grubosc=32 // 32 is ignored, value is taken from evar.grubosc
+
+	[VARIJABLE] // output, remained local
+	grubosc=32
+*/
+type GlobalStrategy int
+
+const (
+	// convert only if old value is a number (see ClassifyExpression), expressions like "if(sz>50;18;32)" or "obj1.gr" stay local
+	OnlyIfValueIsNumber GlobalStrategy = iota
+	Always
+	// convert only if old value is the same as new value, so nothing is lost
+	OnlyIfValueIsTheSame
+	KeepLocal
+)
+
+func (s GlobalStrategy) String() string {
+	switch s {
+	case OnlyIfValueIsNumber:
+		return "only if value is number"
+	case Always:
+		return "always"
+	case OnlyIfValueIsTheSame:
+		return "only if value is the same"
+	case KeepLocal:
+		return "keep local"
+	}
+	return fmt.Sprintf("GlobalStrategy(%d)", int(s))
+}
+
+var globalStrategyNames = map[GlobalStrategy]string{
+	OnlyIfValueIsNumber:  "OnlyIfValueIsNumber",
+	Always:               "Always",
+	OnlyIfValueIsTheSame: "OnlyIfValueIsTheSame",
+	KeepLocal:            "KeepLocal",
+}
+
+// all strategies in order of declaration
+var GlobalStrategies = []GlobalStrategy{OnlyIfValueIsNumber, Always, OnlyIfValueIsTheSame, KeepLocal}
+
+func (s GlobalStrategy) MarshalText() ([]byte, error) {
+	name, found := globalStrategyNames[s]
+	if !found {
+		return nil, fmt.Errorf("unknown GlobalStrategy: %d", int(s))
+	}
+	return []byte(name), nil
+}
+
+// name is not case sensitive: "always" == "Always"
+func (s *GlobalStrategy) UnmarshalText(text []byte) error {
+	for strategy, name := range globalStrategyNames {
+		if strings.EqualFold(name, string(text)) {
+			*s = strategy
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown GlobalStrategy: '%s', use one of: OnlyIfValueIsNumber, Always, OnlyIfValueIsTheSame, KeepLocal", text)
+}
+
+// should local variable oldName=oldValue become global _newName
+func (s GlobalStrategy) ConvertToGlobal(oldValue string, newValue string) bool {
+	switch s {
+	case Always:
+		return true
+	case OnlyIfValueIsTheSame:
+		return oldValue == newValue
+	case OnlyIfValueIsNumber:
+		return ClassifyExpression(oldValue) == ExpressionNumber
+	}
+	return false
+}
+
+// how old and new makro are combined, shared by all makros of single run
+type MergeOptions struct {
+	GlobalStrategy GlobalStrategy
+	// old versions of makros used as base of three-way merge, see MergeMakro. nil means two-way merge (UpdateMakro)
+	Bases *MakroSnapshots
+	// variables renamed in library, old value is copied to new name
+	VariableRenames MakroVariableRenames
+	// per-variable rules that override default merge, nil means no rules
+	Policy *MergePolicy
+	// order and comments of merged sections, see VariableOrder
+	Order VariableOrder
+}
 
 type UpdateResult int
 
@@ -52,6 +151,14 @@ const (
 	ValueChanged
 	ValueChangedConvertedToGlobal
 	ValueChangedRemainedToLocal
+	// three-way merge: old value was not customized, value from new makro is taken
+	ValueUpdatedFromNew
+	// three-way merge: value was customized and changed in new makro, old value is kept
+	ValueConflict
+	// variable was renamed in new makro (see VariableRenames), old value is kept under new name
+	ValueRenamed
+	// variable was deleted from new makro, but is kept because of PolicyKeepOld
+	ValueKept
 )
 
 type Change struct {
@@ -60,21 +167,80 @@ type Change struct {
 	OldValue string
 	NewValue string
 	Result   UpdateResult
+	// set only for ValueChangedConvertedToGlobal and ValueChangedRemainedToLocal
+	Strategy *GlobalStrategy
+	// "VARIJABLE", "JOINT" or numbered submakro call: "MAKRO2"
+	Section string
+	// rule of MergePolicy that decided about variable, nil if default merge was used
+	Rule *PolicyRule
+}
+
+// old value is copied, name is taken from new makro unless variable remains local, see GlobalStrategy. Returns name to write
+func keepOldValue(logger *log.Logger, oldName string, newName string, oldValue string, newValue string, options MergeOptions) (string, Change) {
+	name := newName
+	if !strings.HasPrefix(oldName, `_`) && strings.HasPrefix(newName, `_`) {
+		strategy := options.GlobalStrategy
+		if strategy.ConvertToGlobal(oldValue, newValue) {
+			logger.Printf("  Copied old value: '%s=%s' (was local, now is global, strategy: %s)\n", name, oldValue, strategy)
+			return name, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueChangedConvertedToGlobal, Strategy: &strategy}
+		}
+		name, _ = strings.CutPrefix(newName, `_`)
+		logger.Printf("  Copied old value: '%s=%s' (variable remained local, new global name was: %s, strategy: %s)\n", name, oldValue, newName, strategy)
+		return name, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueChangedRemainedToLocal, Strategy: &strategy}
+	}
+	if normalizeVariableName(oldName) != normalizeVariableName(newName) {
+		logger.Printf("  Copied old value of renamed variable: '%s=%s' (old name: %s)\n", name, oldValue, oldName)
+		return name, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueRenamed}
+	}
+	if newValue != oldValue {
+		logger.Printf("  Copied old value: '%s=%s'\n", name, oldValue)
+		return name, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueChanged}
+	}
+	return name, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueSame}
 }
 
 /*
 	Update old makro in smart way. Modifies newMacro in place
 
-- do not touch old JOINT section
+- [JOINT] is merged per key, old values are kept and new keys are added, see updateSection
+- call parameters of submakros in [MAKRO] sections are merged like [VARIJABLE], see updateSubmakroCalls
 - do not touch old VARIJABLE, unless
 -- there is new variable
 -- maybe in future suport deleting unused variable
 - discard other old sections (groupa, potrosni, makro, pila)
 - todo handle case insensitive and global names: _VAR==VAR==var==vAr
+- local variable that becomes global (new name has "_" prefix) is converted according to options.GlobalStrategy
+- variable renamed in options.VariableRenames keeps old value under new name
+- variable matching rule of options.Policy is merged according to rule, see PolicyAction
 */
-func UpdateMakro(oldMacro *M1, macroToBeChanged *M1, renameTo *string, alwaysConvertLocalToGlobal bool) []Change {
-	oldVariablesKeys, oldValues, _ := loadValuesFromSection(oldMacro.Varijable.DAT)
-	newVariablesKeys, newValues, newVariablesComments := loadValuesFromSection(macroToBeChanged.Varijable.DAT)
+func UpdateMakro(oldMacro *M1, macroToBeChanged *M1, renameTo *string, options MergeOptions) []Change {
+	return updateMakro(log.Default(), oldMacro, macroToBeChanged, renameTo, options)
+}
+
+func updateMakro(logger *log.Logger, oldMacro *M1, macroToBeChanged *M1, renameTo *string, options MergeOptions) []Change {
+	logger.Println("Updating [VARIJABLE]")
+	varijableDAT, changes := updateVariables(logger, oldMacro.MakroName, "VARIJABLE", oldMacro.Varijable.DAT, macroToBeChanged.Varijable.DAT, options)
+	if renameTo != nil {
+		macroToBeChanged.MakroName = *renameTo
+	}
+	macroToBeChanged.Varijable.DAT = varijableDAT
+
+	logger.Println("Updating [JOINT]")
+	jointDAT, jointChanges := updateSection(logger, oldMacro.MakroName, "JOINT", sectionDAT(oldMacro.Joint), sectionDAT(macroToBeChanged.Joint), options)
+	setJointDAT(oldMacro, macroToBeChanged, jointDAT)
+	changes = append(changes, jointChanges...)
+	return append(changes, updateSubmakroCalls(logger, oldMacro, macroToBeChanged, options)...)
+}
+
+/*
+Two-way merge of variables: old values are kept (see keepOldValue), new variables are added and variables that are not in new DAT are deleted.
+Used for [VARIJABLE] and for call parameters in [MAKRO]. makroName and section select renames and policy rules. Returns DAT in order of new, or of old with OrderOld (not used for calls)
+*/
+func updateVariables(logger *log.Logger, makroName string, section string, oldDAT string, newDAT string, options MergeOptions) (string, []Change) {
+	oldVariablesKeys, oldValues, _ := loadValuesFromSection(oldDAT)
+	newVariablesKeys, newValues, newVariablesComments := loadValuesFromSection(newDAT)
+	renames := options.VariableRenames.ForMakro(makroName)
+	reversedRenames := renames.Reverse()
 
 	// combine old and new in "smart way"
 	var outputVarijable strings.Builder
@@ -87,44 +253,28 @@ func UpdateMakro(oldMacro *M1, macroToBeChanged *M1, renameTo *string, alwaysCon
 	updateResultVarijable := []Change{}
 	// old=1 // deleted
 	// old=2 // new value, converted to global
-	log.Println("Updating [VARIJABLE]")
 	for _, newName := range newVariablesKeys {
-		oldName, _ := CMKFindName(oldVariablesKeys, newName)
+		oldName, _ := CMKFindName(oldVariablesKeys, newName, reversedRenames)
 		newValue := newValues[newName]
-		// setting: convert local values to global: always; if value stays the same; convert to evar expression; Keep as is
-		// todo if oldValue is not integer, do not allow to convert it to global value
+		// todo convert to evar expression:
 		// one=4
 		// one=evar.one+20
 		// _one=4//4 is ignored
 		oldValue, oldValueExists := oldValues[oldName]
-		if oldValueExists {
-			name := newName
-			convertedToGlobal := alwaysConvertLocalToGlobal
-			if !strings.HasPrefix(oldName, `_`) && strings.HasPrefix(newName, `_`) {
-				if !alwaysConvertLocalToGlobal {
-					if !OnlyDigitsRegex.Match([]byte(oldValue)) {
-						// old expression does not contain only digits, it is not allowed to be made global
-						name, _ = strings.CutPrefix(newName, `_`)
-						convertedToGlobal = true
-					}
-				}
-				if convertedToGlobal {
-					updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueChangedConvertedToGlobal})
-					log.Printf("  Copied old value: '%s=%s' (was local, now is global)\n", name, oldValue)
-				} else {
-					updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueChangedRemainedToLocal})
-					log.Printf("  Copied old value: '%s=%s' (variable remained local, new global name was: %s)\n", name, oldValue, newName)
-				}
-			} else if newValues[newName] != oldValue {
-				updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueChanged})
-				log.Printf("  Copied old value: '%s=%s'\n", name, oldValue)
-			} else {
-				updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueSame})
+		if rule := options.Policy.Find(makroName, section, newName); rule != nil {
+			name, value, write, change := applyPolicyRule(logger, rule, oldName, newName, oldValue, newValue, oldValueExists, options)
+			updateResultVarijable = append(updateResultVarijable, change)
+			if !write {
+				continue
 			}
+			outputVarijable.WriteString(encodeCMKLine(name + "=" + value))
+		} else if oldValueExists {
+			name, change := keepOldValue(logger, oldName, newName, oldValue, newValue, options)
+			updateResultVarijable = append(updateResultVarijable, change)
 			outputVarijable.WriteString(encodeCMKLine(name + "=" + oldValue))
 		} else {
-			log.Printf("  Added value: '%s=%s'\n", newName, newValue)
-			updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueAdded})
+			logger.Printf("  Added value: '%s=%s'\n", newName, newValue)
+			updateResultVarijable = append(updateResultVarijable, Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue, Result: ValueAdded})
 			outputVarijable.WriteString(encodeCMKLine(newName + "=" + newValue))
 		}
 		for _, comment := range newVariablesComments[newName] {
@@ -132,29 +282,162 @@ func UpdateMakro(oldMacro *M1, macroToBeChanged *M1, renameTo *string, alwaysCon
 		}
 	}
 
-	// append missing new variables
-	// for name, newValue := range newValues {
-	// 	outputVarijable.WriteString(encodeCMKLine(name + "=" + newValue))
-	// 	for _, comment := range newVariablesComments[name] {
-	// 		outputVarijable.WriteString(encodeCMKLine(comment))
-	// 	}
-	// }
-
-	// old variables that no longer exist are discarded
+	// old variables that no longer exist are discarded, unless policy keeps them
 	for _, oldName := range oldVariablesKeys {
-		newName, _ := CMKFindName(newVariablesKeys, oldName)
-		newValue, _ := newValues[newName]
-		oldValue, newValueExists := newValues[newName]
-		if !newValueExists {
-			log.Printf("  Deleted value: '%s=%s (was )'\n", oldName, oldValue)
-			updateResultVarijable = append(updateResultVarijable, Change{&oldName, &newName, oldValue, newValue, ValueDeleted})
+		if _, found := CMKFindName(newVariablesKeys, oldName, renames); found {
+			continue
+		}
+		write, change := applyPolicyRuleToDeleted(logger, options.Policy.Find(makroName, section, oldName), oldName, oldValues[oldName])
+		updateResultVarijable = append(updateResultVarijable, change)
+		if write {
+			outputVarijable.WriteString(encodeCMKLine(oldName + "=" + oldValues[oldName]))
 		}
 	}
 
-	if renameTo != nil {
-		macroToBeChanged.MakroName = *renameTo
+	for i := range updateResultVarijable {
+		updateResultVarijable[i].Section = section
+	}
+	dat, _ := strings.CutSuffix(outputVarijable.String(), CMKLineSeparator)
+	if options.Order == OrderOld {
+		dat = orderLikeOld(oldDAT, dat, renames)
+	}
+	return dat, updateResultVarijable
+}
+
+// NAME of submakro call is always taken from new makro, it is used to match calls
+var submakroCallNameRule = PolicyRule{Makro: "*", Section: "MAKRO", Variable: "NAME", Action: PolicyTakeNew}
+
+/*
+Call parameters in [MAKRO] sections of macroToBeChanged are merged with old calls like [VARIJABLE] (see updateVariables),
+so values set in project (LACZ_BLENDA=1, INDEX=2) survive. Calls are matched by CalledWith, n-th call of submakro in new makro
+is merged with n-th call of the same submakro in old makro. Calls that are not in old makro are taken from new makro.
+Renames and policy rules (section "MAKRO") are looked up by name of called submakro.
+*/
+func updateSubmakroCalls(logger *log.Logger, oldMacro *M1, macroToBeChanged *M1, options MergeOptions) []Change {
+	changes := []Change{}
+	policy := &MergePolicy{Rules: []PolicyRule{submakroCallNameRule}}
+	if options.Policy != nil {
+		policy.Rules = append(policy.Rules, options.Policy.Rules...)
+	}
+	options.Policy = policy
+	// parameters of call are written in order of new makro, OrderOld is only for [VARIJABLE] and [JOINT]
+	options.Order = OrderNew
+	oldCalls := map[string][]*M1EmbeddedMakro{}
+	for i := range oldMacro.Makro {
+		name := strings.ToLower(oldMacro.Makro[i].CalledWith())
+		oldCalls[name] = append(oldCalls[name], &oldMacro.Makro[i])
+	}
+	for i := range macroToBeChanged.Makro {
+		newCall := &macroToBeChanged.Makro[i]
+		name := newCall.CalledWith()
+		section := fmt.Sprintf("MAKRO%d", i+1)
+		calls := oldCalls[strings.ToLower(name)]
+		if len(calls) == 0 {
+			logger.Printf("[%s] New call of submakro '%s'\n", section, name)
+			continue
+		}
+		oldCall := calls[0]
+		oldCalls[strings.ToLower(name)] = calls[1:]
+		logger.Printf("Updating [%s] (call of '%s')\n", section, name)
+		dat, callChanges := updateVariables(logger, name, "MAKRO", oldCall.DAT, newCall.DAT, options)
+		for j := range callChanges {
+			callChanges[j].Section = section
+		}
+		newCall.DAT = dat
+		changes = append(changes, callChanges...)
+	}
+	return changes
+}
+
+/*
+Two-way merge of section with "key=value" lines, used for [JOINT]:
+  - key in old and new: old value is kept
+  - key only in new: added
+  - key only in old: kept
+
+Rules of options.Policy for section override it (e.g. take-new for CONNECT). Returns DAT in order of new, kept keys are appended (see options.Order).
+*/
+func updateSection(logger *log.Logger, makroName string, section string, oldDAT string, newDAT string, options MergeOptions) (string, []Change) {
+	oldKeys, oldValues, oldComments := loadValuesFromSection(oldDAT)
+	newKeys, newValues, newComments := loadValuesFromSection(newDAT)
+	changes := []Change{}
+	var output strings.Builder
+	write := func(name string, value string, comments []string) {
+		output.WriteString(encodeCMKLine(name + "=" + value))
+		for _, comment := range comments {
+			output.WriteString(encodeCMKLine(comment))
+		}
+	}
+	initialComments := oldComments[InitialMacroKey]
+	if oldDAT == "" {
+		initialComments = newComments[InitialMacroKey]
+	}
+	for _, line := range initialComments {
+		output.WriteString(encodeCMKLine(line))
+	}
+	for _, newName := range newKeys {
+		oldName, oldExists := CMKFindName(oldKeys, newName, nil)
+		oldValue, newValue := oldValues[oldName], newValues[newName]
+		change := Change{OldName: &oldName, NewName: &newName, OldValue: oldValue, NewValue: newValue}
+		switch rule := options.Policy.Find(makroName, section, newName); {
+		case rule != nil:
+			var name, value string
+			var keep bool
+			name, value, keep, change = applyPolicyRule(logger, rule, oldName, newName, oldValue, newValue, oldExists, options)
+			if keep && oldExists && name == oldName {
+				write(name, value, oldComments[oldName])
+			} else if keep {
+				write(name, value, newComments[newName])
+			}
+		case !oldExists:
+			change.Result = ValueAdded
+			write(newName, newValue, newComments[newName])
+			logger.Printf("  [%s] Added value: '%s=%s'\n", section, newName, newValue)
+		case oldValue == newValue:
+			change.Result = ValueSame
+			write(oldName, oldValue, oldComments[oldName])
+		default:
+			change.Result = ValueChanged
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Copied old value: '%s=%s' (new value: '%s')\n", section, oldName, oldValue, newValue)
+		}
+		change.Section = section
+		changes = append(changes, change)
+	}
+	for _, oldName := range oldKeys {
+		if _, found := CMKFindName(newKeys, oldName, nil); found {
+			continue
+		}
+		oldValue := oldValues[oldName]
+		rule := options.Policy.Find(makroName, section, oldName)
+		change := Change{OldName: &oldName, NewName: &oldName, OldValue: oldValue, Result: ValueKept, Section: section, Rule: rule}
+		if rule != nil && (rule.Action == PolicyDrop || rule.Action == PolicyTakeNew) {
+			change.Result = ValueDeleted
+			logger.Printf("  [%s] Deleted value: '%s=%s' (rule %s)\n", section, oldName, oldValue, rule)
+		} else {
+			write(oldName, oldValue, oldComments[oldName])
+			logger.Printf("  [%s] Kept value that is not in new: '%s=%s'\n", section, oldName, oldValue)
+		}
+		changes = append(changes, change)
+	}
+	dat, _ := strings.CutSuffix(output.String(), CMKLineSeparator)
+	if options.Order == OrderOld {
+		dat = orderLikeOld(oldDAT, dat, nil)
+	}
+	return dat, changes
+}
+
+// [JOINT] of merged makro, section is created only if old or new makro has it
+func setJointDAT(oldMacro *M1, macroToBeChanged *M1, dat string) {
+	if dat == "" && oldMacro.Joint == nil && macroToBeChanged.Joint == nil {
+		return
+	}
+	joint := GenericNodeWithDat{}
+	if oldMacro.Joint != nil {
+		joint = *oldMacro.Joint
+	} else if macroToBeChanged.Joint != nil {
+		joint = *macroToBeChanged.Joint
 	}
-	macroToBeChanged.Varijable.DAT, _ = strings.CutSuffix(outputVarijable.String(), CMKLineSeparator)
-	macroToBeChanged.Joint = oldMacro.Joint
-	return updateResultVarijable
+	joint.DAT = dat
+	macroToBeChanged.Joint = &joint
 }
@@ -0,0 +1,208 @@
+package corpus
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+func TestUpdateMakroGlobalStrategy(t *testing.T) {
+	type testCase struct {
+		name     string
+		oldValue string
+		newValue string
+		strategy GlobalStrategy
+		expected string
+		result   UpdateResult
+	}
+	tests := []testCase{
+		{"always number", "32", "18", Always, "_grubosc=32", ValueChangedConvertedToGlobal},
+		{"always expression", "obj1.gr", "18", Always, "_grubosc=obj1.gr", ValueChangedConvertedToGlobal},
+		{"same value", "18", "18", OnlyIfValueIsTheSame, "_grubosc=18", ValueChangedConvertedToGlobal},
+		{"same value differs", "32", "18", OnlyIfValueIsTheSame, "grubosc=32", ValueChangedRemainedToLocal},
+		{"number", "32", "18", OnlyIfValueIsNumber, "_grubosc=32", ValueChangedConvertedToGlobal},
+		{"number decimal", "-2.5", "18", OnlyIfValueIsNumber, "_grubosc=-2.5", ValueChangedConvertedToGlobal},
+		{"number expression", "if(sz>50;18;32)", "18", OnlyIfValueIsNumber, "grubosc=if(sz>50;18;32)", ValueChangedRemainedToLocal},
+		{"number reference", "obj1.gr", "18", OnlyIfValueIsNumber, "grubosc=obj1.gr", ValueChangedRemainedToLocal},
+		{"number empty", "", "18", OnlyIfValueIsNumber, "grubosc=", ValueChangedRemainedToLocal},
+		{"keep local", "18", "18", KeepLocal, "grubosc=18", ValueChangedRemainedToLocal},
+		{"keep local number", "32", "18", KeepLocal, "grubosc=32", ValueChangedRemainedToLocal},
+	}
+	logger := log.New(io.Discard, "", 0)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "grubosc=" + test.oldValue}}
+			newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "_grubosc=" + test.newValue}}
+			changes := updateMakro(logger, oldMakro, newMakro, nil, MergeOptions{GlobalStrategy: test.strategy})
+			if newMakro.Varijable.DAT != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, newMakro.Varijable.DAT)
+			}
+			if len(changes) != 1 {
+				t.Errorf("wrong number of changes: %d", len(changes))
+				t.FailNow()
+			}
+			if changes[0].Result != test.result {
+				t.Errorf("expected result %s, got %s", test.result, changes[0].Result)
+			}
+			if changes[0].Strategy == nil || *changes[0].Strategy != test.strategy {
+				t.Errorf("change should record strategy %s: %v", test.strategy, changes[0].Strategy)
+			}
+		})
+	}
+}
+
+func TestUpdateMakroStrategyOnlyForGlobalConversion(t *testing.T) {
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1,b=2,_c=3"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1,b=5,_c=4,d=0"}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{GlobalStrategy: Always})
+	for _, change := range changes {
+		if change.Strategy != nil {
+			t.Errorf("strategy should not be set for %s: %s", *change.NewName, change.Result)
+		}
+	}
+	if newMakro.Varijable.DAT != "a=1,b=2,_c=3,d=0" {
+		t.Errorf("wrong variables: %q", newMakro.Varijable.DAT)
+	}
+}
+
+func TestGlobalStrategyUnmarshalText(t *testing.T) {
+	for _, strategy := range GlobalStrategies {
+		name, err := strategy.MarshalText()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var parsed GlobalStrategy
+		if err := parsed.UnmarshalText(name); err != nil || parsed != strategy {
+			t.Errorf("'%s' parsed as %s: %v", name, parsed, err)
+		}
+	}
+	var parsed GlobalStrategy
+	if err := parsed.UnmarshalText([]byte("keeplocal")); err != nil || parsed != KeepLocal {
+		t.Errorf("name should not be case sensitive: %s, %v", parsed, err)
+	}
+	if err := parsed.UnmarshalText([]byte("sometimes")); err == nil {
+		t.Errorf("unknown strategy should fail")
+	}
+}
+
+func TestUpdateMakroJoint(t *testing.T) {
+	policy := &MergePolicy{Rules: []PolicyRule{
+		{Makro: "*", Section: "JOINT", Variable: "CONNECT", Action: PolicyTakeNew},
+		{Makro: "*", Section: "JOINT", Variable: "stary", Action: PolicyDrop},
+		// VARIJABLE rule does not apply to [JOINT]
+		{Makro: "*", Variable: "mindistance", Action: PolicyTakeNew},
+	}}
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=23,mindistance=-14,//komentarz,user=1,stary=2"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=24,mindistance=-10,maxdistance=5"}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{Policy: policy})
+	expected := "CONNECT=24,mindistance=-14,//komentarz,maxdistance=5,user=1"
+	if newMakro.Joint.DAT != expected {
+		t.Errorf("expected '%s', got '%s'", expected, newMakro.Joint.DAT)
+	}
+	if oldMakro.Joint.DAT != "CONNECT=23,mindistance=-14,//komentarz,user=1,stary=2" {
+		t.Errorf("old makro should not be modified: '%s'", oldMakro.Joint.DAT)
+	}
+	results := map[string]UpdateResult{}
+	for _, change := range changes {
+		if change.Section == "JOINT" {
+			results[*change.OldName] = change.Result
+		}
+	}
+	expectedResults := map[string]UpdateResult{
+		"CONNECT":     ValueUpdatedFromNew,
+		"mindistance": ValueChanged,
+		"maxdistance": ValueAdded,
+		"user":        ValueKept,
+		"stary":       ValueDeleted,
+	}
+	for name, result := range expectedResults {
+		if results[name] != result {
+			t.Errorf("'%s': expected %s, got %s", name, result, results[name])
+		}
+	}
+}
+
+func TestUpdateMakroJointOnlyInNew(t *testing.T) {
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=24"}}
+	updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{})
+	if newMakro.Joint == nil || newMakro.Joint.DAT != "CONNECT=24" {
+		t.Errorf("[JOINT] from new makro should be added: %v", newMakro.Joint)
+	}
+	oldMakro, newMakro = &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}}, &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}}
+	updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{})
+	if newMakro.Joint != nil {
+		t.Errorf("[JOINT] should not be created: %v", newMakro.Joint)
+	}
+}
+
+func TestUpdateMakroJointPolicyOnNewKeyKeepsComments(t *testing.T) {
+	policy := &MergePolicy{Rules: []PolicyRule{{Makro: "*", Section: "JOINT", Variable: "maxdistance", Action: PolicyTakeNew}}}
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=23,//stary"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Joint: &GenericNodeWithDat{DAT: "CONNECT=24,maxdistance=5,//nowy"}}
+	updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{Policy: policy})
+	expected := "CONNECT=23,//stary,maxdistance=5,//nowy"
+	if newMakro.Joint.DAT != expected {
+		t.Errorf("expected '%s', got '%s'", expected, newMakro.Joint.DAT)
+	}
+}
+
+func TestUpdateMakroSubmakroCalls(t *testing.T) {
+	call := func(dat string) M1EmbeddedMakro {
+		return M1EmbeddedMakro{GenericNodeWithDat: GenericNodeWithDat{DAT: dat}}
+	}
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Makro: []M1EmbeddedMakro{
+		call("J=0,NAME=blenda,INDEX=1,LACZ_BLENDA=1"),
+		call("J=0,NAME=Zawiasy,INDEX=2,ilosc=3"),
+		call("J=0,NAME=zawiasy,INDEX=3,ilosc=4,stary=1"),
+		call("J=0,NAME=usuniete,INDEX=4"),
+	}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Makro: []M1EmbeddedMakro{
+		call("J=0,NAME=zawiasy,INDEX=1,ilosc=2,nowy=0"),
+		call("J=0,NAME=zawiasy,INDEX=1,ilosc=2,nowy=0"),
+		call("J=0,NAME=zawiasy,INDEX=1,ilosc=2,nowy=0"),
+		call("J=0,NAME=blenda,INDEX=1,LACZ_BLENDA=,przesuniecie_lewej="),
+	}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{})
+	expected := []string{
+		// NAME is taken from new makro, n-th call is matched with n-th call of the same submakro
+		"J=0,NAME=zawiasy,INDEX=2,ilosc=3,nowy=0",
+		"J=0,NAME=zawiasy,INDEX=3,ilosc=4,nowy=0",
+		"J=0,NAME=zawiasy,INDEX=1,ilosc=2,nowy=0",
+		"J=0,NAME=blenda,INDEX=1,LACZ_BLENDA=1,przesuniecie_lewej=",
+	}
+	for i, dat := range expected {
+		if newMakro.Makro[i].DAT != dat {
+			t.Errorf("[MAKRO%d]: expected '%s', got '%s'", i+1, dat, newMakro.Makro[i].DAT)
+		}
+	}
+	results := map[string]UpdateResult{}
+	for _, change := range changes {
+		results[change.Section+"."+*change.OldName] = change.Result
+	}
+	expectedResults := map[string]UpdateResult{
+		"MAKRO1.NAME":        ValueUpdatedFromNew,
+		"MAKRO1.ilosc":       ValueChanged,
+		"MAKRO1.nowy":        ValueAdded,
+		"MAKRO2.stary":       ValueDeleted,
+		"MAKRO4.LACZ_BLENDA": ValueChanged,
+	}
+	for name, result := range expectedResults {
+		if results[name] != result {
+			t.Errorf("'%s': expected %s, got %s", name, result, results[name])
+		}
+	}
+	if _, found := results["MAKRO3.ilosc"]; found {
+		t.Errorf("new call without old call should not be merged: %v", results)
+	}
+}
+
+func TestUpdateMakroDeletedValue(t *testing.T) {
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1,stary=5"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=2"}}
+	changes := UpdateMakro(oldMakro, newMakro, nil, MergeOptions{})
+	if len(changes) != 2 || changes[1].Result != ValueDeleted || changes[1].OldValue != "5" || changes[1].NewValue != "" {
+		t.Errorf("deleted value should keep old value: %+v", changes)
+	}
+}
@@ -0,0 +1,168 @@
+package corpus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+Order of variables in merged section:
+
+	[VARIJABLE] // old
+	// wymiary
+	b=2
+	a=1
+	// ustawione recznie
+	old=5
+
+	[VARIJABLE] // new
+	a=1
+	added=3
+	b=2
+
+	[VARIJABLE] // OrderNew
+	a=1
+	added=3
+	b=2
+
+	[VARIJABLE] // OrderOld
+	// wymiary
+	b=2
+	a=1
+	// ustawione recznie
+	added=3
+	// deleted in new makro:
+	// old=5
+*/
+type VariableOrder int
+
+const (
+	// order and comments of new makro
+	OrderNew VariableOrder = iota
+	// order and comments of old makro, added variables are placed after variable that precedes them in new makro, deleted are listed in trailing comment
+	OrderOld
+)
+
+func (o VariableOrder) String() string {
+	name, found := variableOrderNames[o]
+	if !found {
+		return fmt.Sprintf("VariableOrder(%d)", int(o))
+	}
+	return name
+}
+
+var variableOrderNames = map[VariableOrder]string{
+	OrderNew: "new",
+	OrderOld: "old",
+}
+
+func (o VariableOrder) MarshalText() ([]byte, error) {
+	name, found := variableOrderNames[o]
+	if !found {
+		return nil, fmt.Errorf("unknown VariableOrder: %d", int(o))
+	}
+	return []byte(name), nil
+}
+
+func (o *VariableOrder) UnmarshalText(text []byte) error {
+	for order, name := range variableOrderNames {
+		if strings.EqualFold(name, string(text)) {
+			*o = order
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown order: '%s', use one of: new, old", text)
+}
+
+// first line of trailing comment with variables deleted in new makro, see OrderOld
+const deletedVariablesHeader = "// deleted in new makro:"
+
+// comments of variable and lines of deleted block written by previous update (after deletedVariablesHeader)
+func splitDeletedBlock(comments []string) ([]string, []string) {
+	index := slices.Index(comments, deletedVariablesHeader)
+	if index < 0 {
+		return comments, nil
+	}
+	return comments[:index], comments[index+1:]
+}
+
+/*
+Reorders mergedDAT (result of merge, in order of new makro) in order of oldDAT, see OrderOld. Values are taken from mergedDAT,
+comments of variables that exist in old makro from oldDAT. Deleted block of previous update is extended, not repeated.
+*/
+func orderLikeOld(oldDAT string, mergedDAT string, renames VariableRenames) string {
+	if oldDAT == "" || mergedDAT == "" {
+		return mergedDAT
+	}
+	oldKeys, oldValues, oldComments := loadValuesFromSection(oldDAT)
+	mergedKeys, mergedValues, mergedComments := loadValuesFromSection(mergedDAT)
+
+	// old variable -> merged variable, one to one
+	mergedNames := map[string]string{}
+	isMerged := map[string]bool{}
+	deleted := []string{}
+	for _, oldName := range oldKeys {
+		mergedName, found := CMKFindName(mergedKeys, oldName, renames)
+		if !found || isMerged[mergedName] {
+			deleted = append(deleted, oldName)
+			continue
+		}
+		mergedNames[oldName] = mergedName
+		isMerged[mergedName] = true
+	}
+	// added variables are written after merged variable that precedes them in new makro, InitialMacroKey is start of section
+	addedAfter := map[string][]string{}
+	anchor := InitialMacroKey
+	for _, name := range mergedKeys {
+		if isMerged[name] {
+			anchor = name
+			continue
+		}
+		addedAfter[anchor] = append(addedAfter[anchor], name)
+	}
+	previousDeleted := []string{}
+	for _, name := range append([]string{InitialMacroKey}, oldKeys...) {
+		var previous []string
+		oldComments[name], previous = splitDeletedBlock(oldComments[name])
+		previousDeleted = append(previousDeleted, previous...)
+	}
+
+	var output strings.Builder
+	write := func(lines ...string) {
+		for _, line := range lines {
+			output.WriteString(encodeCMKLine(line))
+		}
+	}
+	writeAdded := func(anchor string) {
+		for _, name := range addedAfter[anchor] {
+			write(name + "=" + mergedValues[name])
+			write(mergedComments[name]...)
+		}
+	}
+	write(oldComments[InitialMacroKey]...)
+	writeAdded(InitialMacroKey)
+	for _, oldName := range oldKeys {
+		mergedName, found := mergedNames[oldName]
+		if !found {
+			continue
+		}
+		write(mergedName + "=" + mergedValues[mergedName])
+		write(oldComments[oldName]...)
+		writeAdded(mergedName)
+	}
+	if len(deleted) > 0 || len(previousDeleted) > 0 {
+		write(deletedVariablesHeader)
+		write(previousDeleted...)
+		for _, oldName := range deleted {
+			line := "// " + oldName + "=" + oldValues[oldName]
+			if slices.Contains(previousDeleted, line) {
+				continue
+			}
+			write(line)
+			write(oldComments[oldName]...)
+		}
+	}
+	dat, _ := strings.CutSuffix(output.String(), CMKLineSeparator)
+	return dat
+}
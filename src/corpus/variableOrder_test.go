@@ -0,0 +1,78 @@
+package corpus
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+func TestUpdateMakroOrderOld(t *testing.T) {
+	type testCase struct {
+		name     string
+		old      string
+		new      string
+		expected string
+	}
+	tests := []testCase{
+		{"same order", "a=1,b=2", "a=3,b=4", "a=1,b=2"},
+		{"old order", "b=2,a=1", "a=3,b=4", "b=2,a=1"},
+		{"old comments", "//wymiary,b=2,a=1,//recznie", "//nowe,a=3,//a,b=4", "//wymiary,b=2,a=1,//recznie"},
+		{"added after neighbour", "b=2,a=1", "a=3,added=5,b=4", "b=2,a=1,added=5"},
+		{"added at start", "b=2,a=1", "first=0,a=3,b=4", "first=0,b=2,a=1"},
+		{"added with comment", "a=1", "a=3,added=5,//nowa", "a=1,added=5,//nowa"},
+		{"deleted", "b=2,old=7,a=1", "a=3,b=4", `b=2,a=1,"// deleted in new makro:","// old=7"`},
+		{"deleted again", `a=1,"// deleted in new makro:","// old=7"`, "b=4", `b=4,"// deleted in new makro:","// old=7","// a=1"`},
+		{"global", "grubosc=32,a=1", "a=3,_grubosc=18", "_grubosc=32,a=1"},
+	}
+	logger := log.New(io.Discard, "", 0)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: test.old}}
+			newMakro := &M1{Varijable: GenericNodeWithDat{DAT: test.new}}
+			updateMakro(logger, oldMakro, newMakro, nil, MergeOptions{GlobalStrategy: Always, Order: OrderOld})
+			if newMakro.Varijable.DAT != test.expected {
+				t.Errorf("expected '%s', got '%s'", test.expected, newMakro.Varijable.DAT)
+			}
+		})
+	}
+}
+
+func TestUpdateMakroOrderOldRenamed(t *testing.T) {
+	renames := MakroVariableRenames{}
+	renames.Add(AllMakros, "przesuniecie", "offset")
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "przesuniecie=5,a=1"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=3,offset=0"}}
+	updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{VariableRenames: renames, Order: OrderOld})
+	if newMakro.Varijable.DAT != "offset=5,a=1" {
+		t.Errorf("renamed variable should keep old position: %s", newMakro.Varijable.DAT)
+	}
+}
+
+func TestMergeMakroOrderOld(t *testing.T) {
+	base := &M1{Varijable: GenericNodeWithDat{DAT: "a=1,b=2,c=3"}}
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "c=3,b=9,a=1"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=5,b=2,d=4"}}
+	mergeMakro(log.New(io.Discard, "", 0), base, oldMakro, newMakro, nil, MergeOptions{Order: OrderOld})
+	if newMakro.Varijable.DAT != `b=9,d=4,a=5,"// deleted in new makro:","// c=3"` {
+		t.Errorf("wrong three-way merge in old order: %s", newMakro.Varijable.DAT)
+	}
+}
+
+func TestVariableOrderUnmarshalText(t *testing.T) {
+	var order VariableOrder
+	if err := order.UnmarshalText([]byte("Old")); err != nil || order != OrderOld {
+		t.Errorf("expected old, got %s: %s", order, err)
+	}
+	if err := order.UnmarshalText([]byte("library")); err == nil {
+		t.Errorf("unknown order should fail")
+	}
+}
+
+func TestUpdateMakroOrderOldSubmakroCall(t *testing.T) {
+	oldMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=1"}, Makro: []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "J=0,NAME=zawiasy,INDEX=1,stary=1"}}}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "a=3"}, Makro: []M1EmbeddedMakro{{GenericNodeWithDat: GenericNodeWithDat{DAT: "J=0,NAME=zawiasy,INDEX=1,nowy=0"}}}}
+	updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{Order: OrderOld})
+	if newMakro.Makro[0].DAT != "J=0,NAME=zawiasy,INDEX=1,nowy=0" {
+		t.Errorf("call should not get comment with deleted parameters: %s", newMakro.Makro[0].DAT)
+	}
+}
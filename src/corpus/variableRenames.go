@@ -0,0 +1,101 @@
+package corpus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// old variable name -> new variable name. Names are not case sensitive and "_" prefix is ignored
+type VariableRenames map[string]string
+
+// new name for name, or old name if renames are reversed
+func (r VariableRenames) Find(name string) (string, bool) {
+	if renamed, found := r[normalizeVariableName(name)]; found {
+		return renamed, true
+	}
+	return "", false
+}
+
+// new variable name -> old variable name
+func (r VariableRenames) Reverse() VariableRenames {
+	reversed := VariableRenames{}
+	for oldName, newName := range r {
+		reversed[normalizeVariableName(newName)] = oldName
+	}
+	return reversed
+}
+
+// renames that apply to every makro
+const AllMakros = "*"
+
+// makro name (or AllMakros) -> renames of its variables. Makro names are not case sensitive
+type MakroVariableRenames map[string]VariableRenames
+
+func (r MakroVariableRenames) Add(makroName string, oldName string, newName string) {
+	makroName = strings.ToLower(makroName)
+	if r[makroName] == nil {
+		r[makroName] = VariableRenames{}
+	}
+	r[makroName][normalizeVariableName(oldName)] = newName
+}
+
+// renames for makro combined with renames for AllMakros, renames for makro win
+func (r MakroVariableRenames) ForMakro(makroName string) VariableRenames {
+	renames := VariableRenames{}
+	for _, source := range []string{AllMakros, makroName} {
+		// keys loaded from job file are not normalized
+		for name, sourceRenames := range r {
+			if !strings.EqualFold(name, source) {
+				continue
+			}
+			for oldName, newName := range sourceRenames {
+				renames[normalizeVariableName(oldName)] = newName
+			}
+		}
+	}
+	return renames
+}
+
+// "old=new" renames variable in every makro, "makro:old=new" only in makro
+func (r MakroVariableRenames) Parse(rename string) error {
+	makroName := AllMakros
+	if before, after, found := strings.Cut(rename, ":"); found {
+		makroName, rename = before, after
+	}
+	oldName, newName, found := strings.Cut(rename, "=")
+	oldName, newName = strings.TrimSpace(oldName), strings.TrimSpace(newName)
+	if !found || oldName == "" || newName == "" || makroName == "" {
+		return fmt.Errorf("invalid variable rename: '%s', use 'old=new' or 'makro:old=new'", rename)
+	}
+	r.Add(makroName, oldName, newName)
+	return nil
+}
+
+/*
+Settings of single run saved in file, JSON:
+
+	{
+		"variableRenames": {
+			"*": {"przesuniecie_zawias": "offset_zawias"},
+			"Zawiasy": {"ilosc": "liczba_zawiasow"}
+		}
+	}
+*/
+type Job struct {
+	// makro name or "*" -> old variable name -> new variable name
+	VariableRenames MakroVariableRenames `json:"variableRenames"`
+}
+
+func LoadJobFile(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read job file: %w", err)
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("invalid job file '%s': %w", path, err)
+	}
+	return &job, nil
+}
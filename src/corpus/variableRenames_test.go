@@ -0,0 +1,93 @@
+package corpus
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpdateMakroVariableRenames(t *testing.T) {
+	renames := MakroVariableRenames{}
+	renames.Add("Zawiasy", "przesuniecie_zawias", "offset_zawias")
+	oldMakro := &M1{MakroName: "Zawiasy", Varijable: GenericNodeWithDat{DAT: "ilosc=3,przesuniecie_zawias=120"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "ilosc=2,offset_zawias=100"}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{VariableRenames: renames})
+	expected := "ilosc=3,offset_zawias=120"
+	if newMakro.Varijable.DAT != expected {
+		t.Errorf("expected '%s', got '%s'", expected, newMakro.Varijable.DAT)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("renamed variable should be neither added nor deleted: %v", changes)
+	}
+	if changes[1].Result != ValueRenamed || *changes[1].OldName != "przesuniecie_zawias" || *changes[1].NewName != "offset_zawias" {
+		t.Errorf("wrong change of renamed variable: %s %s -> %s", changes[1].Result, *changes[1].OldName, *changes[1].NewName)
+	}
+}
+
+func TestUpdateMakroVariableRenamesOtherMakro(t *testing.T) {
+	renames := MakroVariableRenames{}
+	renames.Add("Zawiasy", "przesuniecie_zawias", "offset_zawias")
+	oldMakro := &M1{MakroName: "Szuflady", Varijable: GenericNodeWithDat{DAT: "przesuniecie_zawias=120"}}
+	newMakro := &M1{Varijable: GenericNodeWithDat{DAT: "offset_zawias=100"}}
+	changes := updateMakro(log.New(io.Discard, "", 0), oldMakro, newMakro, nil, MergeOptions{VariableRenames: renames})
+	if newMakro.Varijable.DAT != "offset_zawias=100" {
+		t.Errorf("rename of other makro should not be used: '%s'", newMakro.Varijable.DAT)
+	}
+	if len(changes) != 2 || changes[0].Result != ValueAdded || changes[1].Result != ValueDeleted {
+		t.Errorf("expected added and deleted: %v", changes)
+	}
+}
+
+func TestCMKFindNameRenamed(t *testing.T) {
+	renames := VariableRenames{"old": "_New"}
+	if name, found := CMKFindName([]string{"a", "new"}, "_OLD", renames); !found || name != "new" {
+		t.Errorf("renamed name should be found: %s %v", name, found)
+	}
+	if name, found := CMKFindName([]string{"old", "new"}, "old", renames); !found || name != "old" {
+		t.Errorf("exact match should win over rename: %s %v", name, found)
+	}
+	if name, found := CMKFindName([]string{"a"}, "old", nil); found || name != "old" {
+		t.Errorf("searched name should be returned when not found: %s %v", name, found)
+	}
+}
+
+func TestMakroVariableRenamesParse(t *testing.T) {
+	renames := MakroVariableRenames{}
+	for _, rename := range []string{"a=b", "Zawiasy:c = d", "Zawiasy:a=e"} {
+		if err := renames.Parse(rename); err != nil {
+			t.Error(err)
+		}
+	}
+	forMakro := renames.ForMakro("Zawiasy")
+	if forMakro["a"] != "e" || forMakro["c"] != "d" {
+		t.Errorf("makro rename should override rename for all makros: %v", forMakro)
+	}
+	if forMakro := renames.ForMakro("zawiasy"); forMakro["a"] != "e" || forMakro["c"] != "d" {
+		t.Errorf("makro name should not be case sensitive: %v", forMakro)
+	}
+	if other := renames.ForMakro("Szuflady"); len(other) != 1 || other["a"] != "b" {
+		t.Errorf("only renames for all makros expected: %v", other)
+	}
+	for _, invalid := range []string{"a", "=b", "a=", ":a=b"} {
+		if err := renames.Parse(invalid); err == nil {
+			t.Errorf("'%s' should be invalid", invalid)
+		}
+	}
+}
+
+func TestLoadJobFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.json")
+	os.WriteFile(path, []byte(`{"variableRenames": {"*": {"a": "b"}, "Zawiasy": {"c": "d"}}}`), 0644)
+	job, err := LoadJobFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forMakro := job.VariableRenames.ForMakro("ZAWIASY"); forMakro["a"] != "b" || forMakro["c"] != "d" {
+		t.Errorf("wrong renames: %v", forMakro)
+	}
+	if _, err := LoadJobFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing job file should fail")
+	}
+}
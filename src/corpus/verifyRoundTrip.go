@@ -0,0 +1,301 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// difference found after decoding and encoding file without changes
+type RoundTripDifference struct {
+	// element path, see MakroPath
+	Path    string
+	Message string
+}
+
+// first byte that differs
+type ByteDifference struct {
+	Offset int
+	Line   int
+	Column int
+	// few bytes around difference
+	Original string
+	Output   string
+}
+
+type RoundTripReport struct {
+	File               string
+	PreserveFormatting bool
+	OriginalSize       int
+	OutputSize         int
+	// differences in names, attributes (C6DAT is compared decoded), text and comments. Whitespace and attribute order is ignored
+	Semantic []RoundTripDifference
+	// nil if output is byte-identical
+	Byte *ByteDifference
+}
+
+func (r RoundTripReport) Identical() bool {
+	return len(r.Semantic) == 0 && r.Byte == nil
+}
+
+/*
+Decodes and encodes file without changing anything, the same way as makro replacement does.
+In preserveFormatting mode every makro is encoded again and spliced into original file.
+Returns encoded file and report of differences.
+*/
+func VerifyRoundTrip(inputFile string, preserveFormatting bool, minify bool) ([]byte, *RoundTripReport, error) {
+	input, err := os.ReadFile(inputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening input file: %w", err)
+	}
+	output, err := RoundTripCorpusFile(input, preserveFormatting, minify)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := CompareCorpusFiles(input, output)
+	if err != nil {
+		return output, nil, err
+	}
+	report.File = inputFile
+	report.PreserveFormatting = preserveFormatting
+	return output, report, nil
+}
+
+func RoundTripCorpusFile(input []byte, preserveFormatting bool, minify bool) ([]byte, error) {
+	logger := log.New(io.Discard, "", 0)
+	changedMakros := map[string]any{}
+	markAllMakros := func(elementPath string, element *Element) {
+		for i := range element.Elinks.Spoj {
+			for slot, makro := range element.Elinks.Spoj[i].Makros() {
+				changedMakros[MakroPath(elementPath, false, i, slot+1)] = makro
+			}
+		}
+		for i := range element.Elinks.MakLink {
+			for slot, makro := range element.Elinks.MakLink[i].Makros() {
+				changedMakros[MakroPath(elementPath, true, i, slot+1)] = makro
+			}
+		}
+	}
+	var decodeErr error
+	handleE3DFile := func(decoder *xml.Decoder, start xml.StartElement) xml.Token {
+		var rootCorpusFile ElementFile
+		decoder.Strict = true
+		decodeErr = decoder.DecodeElement(&rootCorpusFile, &start)
+		decoder.Strict = false
+		rootCorpusFile.visitElementsWithPath(markAllMakros)
+		return rootCorpusFile
+	}
+	handleS3DFile := func(decoder *xml.Decoder, start xml.StartElement) xml.Token {
+		var rootCorpusFile ProjectFile
+		decoder.Strict = true
+		decodeErr = decoder.DecodeElement(&rootCorpusFile, &start)
+		decoder.Strict = false
+		rootCorpusFile.visitElementsWithPath(markAllMakros)
+		return rootCorpusFile
+	}
+	output, err := encodeCorpusFile(logger, input, minify, handleE3DFile, handleS3DFile)
+	if err != nil {
+		return nil, err
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("error decoding corpus file: %w", decodeErr)
+	}
+	if preserveFormatting {
+		return spliceMakros(input, changedMakros)
+	}
+	return output, nil
+}
+
+// semantic and byte level comparison
+func CompareCorpusFiles(original []byte, output []byte) (*RoundTripReport, error) {
+	report := RoundTripReport{OriginalSize: len(original), OutputSize: len(output)}
+	originalTree, err := parseXMLTree(original)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse original: %w", err)
+	}
+	outputTree, err := parseXMLTree(output)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse output: %w", err)
+	}
+	compareXMLNodes(originalTree, outputTree, "", &report.Semantic)
+	report.Byte = firstByteDifference(original, output)
+	return &report, nil
+}
+
+type xmlNode struct {
+	Name     string
+	Attr     []xml.Attr
+	Text     string
+	Children []*xmlNode
+}
+
+const xmlCommentNodeName = "#comment"
+
+// whitespace only text is dropped, document itself is the root node
+func parseXMLTree(data []byte) (*xmlNode, error) {
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		current := stack[len(stack)-1]
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{Name: strings.ToUpper(t.Name.Local), Attr: t.Attr}
+			current.Children = append(current.Children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("unexpected end element: %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			current.Text += strings.TrimSpace(strings.TrimPrefix(string(t), "\uFEFF"))
+		case xml.Comment:
+			current.Children = append(current.Children, &xmlNode{Name: xmlCommentNodeName, Text: strings.TrimSpace(string(t))})
+		}
+	}
+	return root, nil
+}
+
+func attrValue(attr xml.Attr) string {
+	if attr.Name.Local == "C6DAT" {
+		decoded, err := (&GenericNodeWithC6Dat{C6DAT: attr.Value}).DecodeC6Dat()
+		if err == nil {
+			return decoded
+		}
+	}
+	return attr.Value
+}
+
+func compareXMLNodes(original *xmlNode, output *xmlNode, path string, differences *[]RoundTripDifference) {
+	addDifference := func(format string, args ...any) {
+		*differences = append(*differences, RoundTripDifference{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if original.Name != output.Name {
+		addDifference("element '%s' became '%s'", original.Name, output.Name)
+		return
+	}
+	if original.Text != output.Text {
+		addDifference("text '%s' became '%s'", original.Text, output.Text)
+	}
+
+	outputAttr := map[string]string{}
+	for _, attr := range output.Attr {
+		outputAttr[attr.Name.Local] = attrValue(attr)
+	}
+	for _, attr := range original.Attr {
+		value, found := outputAttr[attr.Name.Local]
+		if !found {
+			addDifference("attribute %s='%s' is missing", attr.Name.Local, attr.Value)
+			continue
+		}
+		if originalValue := attrValue(attr); originalValue != value {
+			addDifference("attribute %s='%s' became '%s'", attr.Name.Local, originalValue, value)
+		}
+		delete(outputAttr, attr.Name.Local)
+	}
+	for _, attr := range output.Attr {
+		if _, added := outputAttr[attr.Name.Local]; added {
+			addDifference("attribute %s='%s' was added", attr.Name.Local, attr.Value)
+		}
+	}
+
+	// children are paired by name and index among siblings of the same name, so single moved element is reported once
+	childNames := func(node *xmlNode) []string {
+		names := []string{}
+		for _, child := range node.Children {
+			names = append(names, child.Name)
+		}
+		return names
+	}
+	originalNames, outputNames := childNames(original), childNames(output)
+	if strings.Join(originalNames, ",") != strings.Join(outputNames, ",") {
+		addDifference("children changed: %s became %s", strings.Join(originalNames, ","), strings.Join(outputNames, ","))
+	}
+	childrenByName := func(node *xmlNode) map[string][]*xmlNode {
+		children := map[string][]*xmlNode{}
+		for _, child := range node.Children {
+			children[child.Name] = append(children[child.Name], child)
+		}
+		return children
+	}
+	outputChildren := childrenByName(output)
+	counts := map[string]int{}
+	for _, child := range original.Children {
+		index := counts[child.Name]
+		counts[child.Name]++
+		childPath := fmt.Sprintf("%s[%d]", child.Name, index)
+		if path != "" {
+			childPath = path + "/" + childPath
+		}
+		if index >= len(outputChildren[child.Name]) {
+			*differences = append(*differences, RoundTripDifference{Path: childPath, Message: "element is missing"})
+			continue
+		}
+		compareXMLNodes(child, outputChildren[child.Name][index], childPath, differences)
+	}
+	for name, children := range outputChildren {
+		for index := counts[name]; index < len(children); index++ {
+			childPath := fmt.Sprintf("%s[%d]", name, index)
+			if path != "" {
+				childPath = path + "/" + childPath
+			}
+			*differences = append(*differences, RoundTripDifference{Path: childPath, Message: "element was added"})
+		}
+	}
+}
+
+func firstByteDifference(original []byte, output []byte) *ByteDifference {
+	if bytes.Equal(original, output) {
+		return nil
+	}
+	offset := 0
+	for offset < len(original) && offset < len(output) && original[offset] == output[offset] {
+		offset++
+	}
+	line := bytes.Count(original[:offset], []byte("\n")) + 1
+	column := offset - (bytes.LastIndexByte(original[:offset], '\n') + 1) + 1
+	snippet := func(data []byte) string {
+		return string(data[max(0, offset-20):min(len(data), offset+40)])
+	}
+	return &ByteDifference{Offset: offset, Line: line, Column: column, Original: snippet(original), Output: snippet(output)}
+}
+
+// human readable report
+func WriteRoundTripReportText(w io.Writer, reports []RoundTripReport) error {
+	for _, report := range reports {
+		mode := "full encode"
+		if report.PreserveFormatting {
+			mode = "preserve formatting"
+		}
+		if report.Identical() {
+			fmt.Fprintf(w, "File: '%s' (%s): identical\n", report.File, mode)
+			continue
+		}
+		fmt.Fprintf(w, "File: '%s' (%s): %d bytes -> %d bytes\n", report.File, mode, report.OriginalSize, report.OutputSize)
+		if report.Byte != nil {
+			fmt.Fprintf(w, "  First byte difference at offset %d (line %d, column %d)\n", report.Byte.Offset, report.Byte.Line, report.Byte.Column)
+			fmt.Fprintf(w, "    original: %q\n", report.Byte.Original)
+			fmt.Fprintf(w, "    output:   %q\n", report.Byte.Output)
+		}
+		if len(report.Semantic) == 0 {
+			fmt.Fprintf(w, "  No semantic differences\n")
+		}
+		for _, difference := range report.Semantic {
+			fmt.Fprintf(w, "  %s: %s\n", difference.Path, difference.Message)
+		}
+	}
+	_, err := fmt.Fprintf(w, "Files: %d\n", len(reports))
+	return err
+}
@@ -0,0 +1,123 @@
+package corpus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRoundTripPreserveFormatting(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(pathToE3DTestDataVertsion17, "*.E3D"))
+	if err != nil || len(files) == 0 {
+		t.Error("no test files", err)
+		t.FailNow()
+	}
+	// version 16 files are written with &#34; or &quot;
+	files16, err := filepath.Glob(filepath.Join(pathToE3DTestDataVertsion16, "*.E3D"))
+	if err != nil || len(files16) == 0 {
+		t.Error("no test files", err)
+		t.FailNow()
+	}
+	for _, file := range append(files, files16...) {
+		_, report, err := VerifyRoundTrip(file, true, false)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !report.Identical() {
+			t.Errorf("file is not byte-identical: '%s': %v %v", file, report.Semantic, report.Byte)
+		}
+	}
+}
+
+func TestScanMakroLocations(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(pathToE3DTestDataVertsion16, "simple_in_simple.E3D"))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	locations, err := scanMakroLocations(data)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(locations) == 0 {
+		t.Error("no makro found")
+	}
+	for path, location := range locations {
+		if !strings.HasPrefix(path, "ELEMENT[0]/") || !makroPathRegex.MatchString(path) {
+			t.Errorf("unexpected path: %s", path)
+		}
+		makro := string(data[location.Start:location.End])
+		name := strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], "[0]")
+		if !strings.HasPrefix(makro, "<"+name+" ") || !strings.HasSuffix(makro, "</"+name+">") {
+			t.Errorf("wrong location of %s: %s", path, makro)
+		}
+	}
+}
+
+func TestReplaceMakroPreserveFormatting(t *testing.T) {
+	makroName := "gorny"
+	makro, err := NewMakroFromCMKFile(&makroName, filepath.Join(pathToCMKTestData, "simple.CMK"), nil, nil)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	inputFile := filepath.Join(pathToE3DTestDataVertsion16, "simple.E3D")
+	outputFile := filepath.Join(t.TempDir(), "simple.E3D")
+	_, err = ReplaceMakroInCorpusFile(inputFile, outputFile, map[string]*M1{makroName: makro}, map[string]string{}, ReplaceOptions{PreserveFormatting: true})
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	original, _ := os.ReadFile(inputFile)
+	output, _ := os.ReadFile(outputFile)
+	report, err := CompareCorpusFiles(original, output)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(report.Semantic) == 0 {
+		t.Error("makro was not updated")
+	}
+	for _, difference := range report.Semantic {
+		if !strings.Contains(difference.Path, "/SPOJ[0]/M1[0]") {
+			t.Errorf("difference outside of updated makro: %s: %s", difference.Path, difference.Message)
+		}
+	}
+	// bytes before first makro are copied
+	locations, _ := scanMakroLocations(original)
+	firstMakro := int64(len(original))
+	for _, location := range locations {
+		firstMakro = min(firstMakro, location.Start)
+	}
+	if report.Byte == nil || int64(report.Byte.Offset) < firstMakro {
+		t.Errorf("file before makro is not byte-identical: %v", report.Byte)
+	}
+}
+
+func TestCompareCorpusFiles(t *testing.T) {
+	original := []byte(`<ELEMENTFILE><ELEMENT ENAME="a"><EVAR VAR0="x=1"/></ELEMENT></ELEMENTFILE>`)
+	output := []byte(`<ELEMENTFILE><ELEMENT ENAME="b"/></ELEMENTFILE>`)
+	report, err := CompareCorpusFiles(original, output)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if report.Byte == nil || report.Byte.Offset != 29 {
+		t.Errorf("wrong byte difference: %v", report.Byte)
+	}
+	messages := []string{}
+	for _, difference := range report.Semantic {
+		messages = append(messages, difference.Path+": "+difference.Message)
+	}
+	expected := []string{
+		"ELEMENTFILE[0]/ELEMENT[0]: attribute ENAME='a' became 'b'",
+		"ELEMENTFILE[0]/ELEMENT[0]: children changed: EVAR became ",
+		"ELEMENTFILE[0]/ELEMENT[0]/EVAR[0]: element is missing",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong differences:\n%s", strings.Join(messages, "\n"))
+	}
+}
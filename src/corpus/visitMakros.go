@@ -0,0 +1,79 @@
+package corpus
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// place where makro is used
+type MakroUsage struct {
+	File string
+	// cabinet, ENAME
+	Element string
+	// plate, DNAME
+	Plate string
+	// makro that calls this one in [MAKRO] section, empty for makro placed directly on plate
+	CalledBy string
+}
+
+/*
+Visits every makro in corpus file: M1 from SPOJ (version 16), MM1 from MAKLINK (version 17, decoded to M1) and all their submakros.
+Submakros are named the way they are called in [MAKRO] section (see CalledWith), so name can contain folder: "folder/makro".
+*/
+func VisitMakrosInCorpusFile(inputFile string, f func(name string, makro *M1, usage MakroUsage)) error {
+	projectFile, elementFile, err := NewCorpusFile(inputFile)
+	if err != nil {
+		return err
+	}
+	if projectFile != nil {
+		elementFile = &projectFile.ElementFile
+	}
+	var errs []error
+	visitMakro := func(element *Element, adIndexValue string, makro *M1) {
+		usage := MakroUsage{File: inputFile, Element: element.EName.Value}
+		if adIndex, err := strconv.Atoi(adIndexValue); err == nil && adIndex >= 0 && adIndex < len(element.Daske.AD) {
+			usage.Plate = element.Daske.AD[adIndex].DName.Value
+		}
+		makro.VisitSubmakros(func(parent *M1, embededParent *M1EmbeddedMakro, child *M1EmbeddedMakro) {
+			if child == nil {
+				f(parent.MakroName, parent, usage)
+				return
+			}
+			if child.MAK == nil {
+				return
+			}
+			submakroUsage := usage
+			if embededParent != nil {
+				submakroUsage.CalledBy = embededParent.CalledWith()
+			} else {
+				submakroUsage.CalledBy = parent.MakroName
+			}
+			f(child.CalledWith(), child.MAK, submakroUsage)
+		})
+	}
+	elementFile.VisitElementsAndSubelements(func(element *Element) {
+		for i := range element.Elinks.Spoj {
+			for _, makro := range element.Elinks.Spoj[i].Makros() {
+				if !makro.isEmpty() {
+					visitMakro(element, element.Elinks.Spoj[i].O1.Value, makro)
+				}
+			}
+		}
+		for i := range element.Elinks.MakLink {
+			makLink := &element.Elinks.MakLink[i]
+			for _, mm := range makLink.Makros() {
+				makro, err := NewM1(mm)
+				if err != nil {
+					err = fmt.Errorf("cabinet '%s': can not decode makro '%s': %w", element.EName.Value, mm.MakroName, err)
+					errs = append(errs, err)
+					continue
+				}
+				if !makro.isEmpty() {
+					visitMakro(element, makLink.OB1.Value, makro)
+				}
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,176 @@
+package corpus
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// order of attributes and children as read from file, used to write makro back the same way Corpus wrote it
+type xmlLayout struct {
+	Attr     []string
+	Children []string
+	// layout of every child, the same index as in Children
+	ChildLayouts []*xmlLayout
+}
+
+func newXMLLayout(node *GenericNode) *xmlLayout {
+	layout := &xmlLayout{}
+	for _, attr := range node.Attr {
+		layout.Attr = append(layout.Attr, attr.Name.Local)
+	}
+	for i := range node.Content {
+		layout.Children = append(layout.Children, node.Content[i].XMLName.Local)
+		layout.ChildLayouts = append(layout.ChildLayouts, newXMLLayout(&node.Content[i]))
+	}
+	return layout
+}
+
+func (gn *GenericNode) isEmpty() bool {
+	for _, attr := range gn.Attr {
+		if attr.Value != "" {
+			return false
+		}
+	}
+	return len(gn.Content) == 0 && strings.TrimSpace(gn.Chardata) == ""
+}
+
+// reorders attributes and children of node the same way as in layout.
+// Attributes and elements that are not in layout go to the end, the empty ones are dropped
+func (layout *xmlLayout) apply(node *GenericNode) {
+	attrPosition := map[string]int{}
+	for i, name := range layout.Attr {
+		attrPosition[name] = i
+	}
+	attrs := []xml.Attr{}
+	for _, attr := range node.Attr {
+		if _, found := attrPosition[attr.Name.Local]; found || attr.Value != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	position := func(attr xml.Attr) int {
+		if i, found := attrPosition[attr.Name.Local]; found {
+			return i
+		}
+		return len(layout.Attr)
+	}
+	sort.SliceStable(attrs, func(i, j int) bool { return position(attrs[i]) < position(attrs[j]) })
+	node.Attr = attrs
+
+	// children are matched by name and index among siblings with the same name
+	childPosition := map[string]int{}
+	counts := map[string]int{}
+	for i, name := range layout.Children {
+		childPosition[fmt.Sprintf("%s[%d]", name, counts[name])] = i
+		counts[name]++
+	}
+	type positionedNode struct {
+		position int
+		node     GenericNode
+	}
+	children := []positionedNode{}
+	counts = map[string]int{}
+	for _, child := range node.Content {
+		name := child.XMLName.Local
+		i, found := childPosition[fmt.Sprintf("%s[%d]", name, counts[name])]
+		counts[name]++
+		if !found {
+			if child.isEmpty() {
+				continue
+			}
+			i = len(layout.Children)
+		} else {
+			layout.ChildLayouts[i].apply(&child)
+		}
+		children = append(children, positionedNode{i, child})
+	}
+	sort.SliceStable(children, func(i, j int) bool { return children[i].position < children[j].position })
+	node.Content = node.Content[:0]
+	for _, child := range children {
+		node.Content = append(node.Content, child.node)
+	}
+}
+
+// v is decoded from node as if it was read from file
+func (gn *GenericNode) decodeInto(v any) error {
+	data, err := xml.Marshal(gn)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// encodes v and decodes it as GenericNode
+func encodeToGenericNode(v any, start xml.StartElement) (*GenericNode, error) {
+	var data bytes.Buffer
+	encoder := xml.NewEncoder(&data)
+	if err := encoder.EncodeElement(v, start); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	var node GenericNode
+	if err := xml.Unmarshal(data.Bytes(), &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// remembers order of sections, Corpus does not always write them in the same order
+func (m *M1) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var node GenericNode
+	if err := d.DecodeElement(&node, &start); err != nil {
+		return err
+	}
+	type Alias M1
+	if err := node.decodeInto((*Alias)(m)); err != nil {
+		return err
+	}
+	m.layout = newXMLLayout(&node)
+	return nil
+}
+
+// makro read from file is written with the same order of sections and attributes
+func (m M1) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type Alias M1
+	if m.layout == nil {
+		return e.EncodeElement(Alias(m), start)
+	}
+	node, err := encodeToGenericNode(Alias(m), start)
+	if err != nil {
+		return err
+	}
+	m.layout.apply(node)
+	return e.EncodeElement(node, start)
+}
+
+// see M1.UnmarshalXML
+func (m *MM1) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var node GenericNode
+	if err := d.DecodeElement(&node, &start); err != nil {
+		return err
+	}
+	type Alias MM1
+	if err := node.decodeInto((*Alias)(m)); err != nil {
+		return err
+	}
+	m.layout = newXMLLayout(&node)
+	return nil
+}
+
+// see M1.MarshalXML
+func (m MM1) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type Alias MM1
+	if m.layout == nil {
+		return e.EncodeElement(Alias(m), start)
+	}
+	node, err := encodeToGenericNode(Alias(m), start)
+	if err != nil {
+		return err
+	}
+	m.layout.apply(node)
+	return e.EncodeElement(node, start)
+}